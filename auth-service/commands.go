@@ -0,0 +1,296 @@
+package main
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/repositories"
+	"auth-service/utils"
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: auth-service <command> [flags]
+
+Commands:
+  serve                               start the HTTP server (default)
+  migrate up                          apply pending schema migrations
+  migrate down [--steps N]            revert the last N migrations (default 1)
+  migrate status                      list migrations and whether they are applied
+  user create --name N --email E      create a user; add --admin to grant ADMIN
+  user set-password --email E         replace a user's password and sign them out
+  sessions revoke --user EMAIL|ID     sign a user out of every session
+  keys rotate                         replace the JWT signing key now
+  config                              print the effective configuration, secrets redacted
+
+Settings come from the environment, a .env file and the YAML file named by
+CONFIG_FILE. Every command except help refuses to start with invalid settings.
+Passwords are read from the first line of standard input.
+`
+
+// cliClient identifies operator actions in the security event log.
+var cliClient = models.ClientInfo{UserAgent: "auth-service-cli"}
+
+// openDB and stdin are where commands get their database and passwords;
+// tests replace them.
+var (
+	openDB           = utils.NewGormDB
+	stdin  io.Reader = os.Stdin
+)
+
+var commands = map[string]func(args []string) error{
+	"serve": func([]string) error {
+		return serve()
+	},
+	"migrate":  runMigrate,
+	"user":     runUser,
+	"sessions": runSessions,
+	"keys":     runKeys,
+	"config": func([]string) error {
+		fmt.Print(config.Current())
+		return nil
+	},
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	switch args[0] {
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+	if _, err := config.Load(""); err != nil {
+		return err
+	}
+	return command(args[1:])
+}
+
+func runMigrate(args []string) error {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return errors.New("migrate needs one of: up, down, status")
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if args[0] == "down" && *steps < 1 {
+		return errors.New("--steps must be at least 1")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer utils.CloseGormDB(db)
+	migrator, err := utils.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %s\n", migration)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %s\n", migration)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\tSTATE")
+		for _, status := range statuses {
+			appliedAt, state := "-", "pending"
+			if status.AppliedAt != nil {
+				appliedAt, state = status.AppliedAt.Format(time.RFC3339), "applied"
+			}
+			if status.Modified {
+				state = "modified"
+			} else if status.Unknown {
+				state = "unknown"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, appliedAt, state)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
+
+func runUser(args []string) error {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "set-password") {
+		return errors.New("user needs one of: create, set-password")
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	name := flags.String("name", "", "display name of the new user")
+	email := flags.String("email", "", "email address of the user")
+	admin := flags.Bool("admin", false, "grant the ADMIN role to the new user")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("--email is required")
+	}
+	if args[0] == "create" && *name == "" {
+		return errors.New("--name is required")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer utils.CloseGormDB(db)
+	authRepo := repositories.NewAuthRepository(db)
+
+	switch args[0] {
+	case "create":
+		password, err := readPassword(stdin)
+		if err != nil {
+			return err
+		}
+
+		// The operator vouches for the address, so no verification email is
+		// needed before the user can sign in. The user and the ADMIN grant
+		// are created together, so a failed grant can simply be retried.
+		var user *models.User
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			user, err = repositories.NewAuthRepository(tx).CreateUser(*name, *email, password, true)
+			if err != nil {
+				return err
+			}
+			if *admin {
+				return repositories.NewRoleRepository(tx).GrantRole(user.ID, models.RoleAdmin)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created user %s (%s)\n", user.ID, user.Email)
+		return nil
+	case "set-password":
+		user, err := findUser(authRepo, *email)
+		if err != nil {
+			return err
+		}
+		password, err := readPassword(stdin)
+		if err != nil {
+			return err
+		}
+
+		if err := authRepo.SetPassword(user.ID, password, cliClient); err != nil {
+			return err
+		}
+		fmt.Printf("password of %s replaced, all sessions revoked\n", user.Email)
+		return nil
+	}
+	return fmt.Errorf("unknown user command %q", args[0])
+}
+
+func runSessions(args []string) error {
+	if len(args) == 0 || args[0] != "revoke" {
+		return errors.New("sessions needs: revoke")
+	}
+
+	flags := flag.NewFlagSet("sessions revoke", flag.ContinueOnError)
+	userRef := flags.String("user", "", "email address or id of the user")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *userRef == "" {
+		return errors.New("--user is required")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer utils.CloseGormDB(db)
+	authRepo := repositories.NewAuthRepository(db)
+
+	user, err := findUser(authRepo, *userRef)
+	if err != nil {
+		return err
+	}
+	if err := authRepo.RevokeUserSessions(user.ID, cliClient); err != nil {
+		return err
+	}
+	fmt.Printf("revoked all sessions of %s\n", user.Email)
+	return nil
+}
+
+func runKeys(args []string) error {
+	if len(args) == 0 || args[0] != "rotate" {
+		return errors.New("keys needs: rotate")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer utils.CloseGormDB(db)
+
+	// Running servers publish the new key on their next refresh but keep
+	// signing with the old one until verifiers have had time to fetch it.
+	key, err := repositories.NewKeyRepository(db).RotateSigningKey(utils.SigningAlgorithm(), utils.SigningKeyOverlap())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("new signing key %s (%s) published, signs from %s; previous keys expire %s later\n",
+		key.ID, key.Algorithm, key.ActivatesAt.Local().Format(time.RFC3339), utils.SigningKeyOverlap())
+	return nil
+}
+
+func findUser(authRepo repositories.AuthRepositoryInterface, ref string) (*models.User, error) {
+	if userID, err := uuid.Parse(ref); err == nil {
+		return authRepo.GetUser(userID)
+	}
+
+	user, err := authRepo.GetUserByEmail(ref)
+	if err == nil && user == nil {
+		return nil, models.ErrUserNotFound
+	}
+	return user, err
+}
+
+func readPassword(r io.Reader) (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", errors.New("no password on standard input")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/repositories"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strings"
+	"testing"
+)
+
+// useMockDB makes commands open a sqlmock database and read the given
+// standard input, and checks that the command closed the database.
+func useMockDB(t *testing.T, input string) sqlmock.Sqlmock {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Set(config.Default())
+	previousOpen, previousStdin := openDB, stdin
+	openDB = func() (*gorm.DB, error) { return db, nil }
+	stdin = strings.NewReader(input)
+	t.Cleanup(func() {
+		openDB, stdin = previousOpen, previousStdin
+		config.Set(nil)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if sqlDB.Ping() == nil {
+			t.Error("database left open")
+		}
+	})
+	return mock
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	err := run([]string{"frobnicate"})
+	if err == nil || !strings.Contains(err.Error(), "Usage:") {
+		t.Fatalf("err = %v, want the usage text", err)
+	}
+}
+
+func TestCommandsValidateArgumentsFirst(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func([]string) error
+		args []string
+	}{
+		{"migrate without subcommand", runMigrate, nil},
+		{"migrate sideways", runMigrate, []string{"sideways"}},
+		{"migrate down zero steps", runMigrate, []string{"down", "--steps", "0"}},
+		{"user without subcommand", runUser, nil},
+		{"user create without email", runUser, []string{"create", "--name", "Ops"}},
+		{"user create without name", runUser, []string{"create", "--email", "ops@example.com"}},
+		{"user set-password without email", runUser, []string{"set-password"}},
+		{"user unknown flag", runUser, []string{"create", "--root"}},
+		{"sessions without subcommand", runSessions, nil},
+		{"sessions revoke without user", runSessions, []string{"revoke"}},
+		{"keys without subcommand", runKeys, []string{"delete"}},
+	}
+
+	previous := openDB
+	t.Cleanup(func() { openDB = previous })
+	opened := false
+	openDB = func() (*gorm.DB, error) {
+		opened = true
+		return nil, errors.New("no database in this test")
+	}
+
+	for _, tt := range tests {
+		opened = false
+		if err := tt.run(tt.args); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+		if opened {
+			t.Errorf("%s: opened the database before rejecting the arguments", tt.name)
+		}
+	}
+}
+
+func TestUserCreateAdmin(t *testing.T) {
+	mock := useMockDB(t, "correct horse battery staple\n")
+	userID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users \(name, email, password_hash, email_verified_at\)`).
+		WithArgs("Ops", "ops@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(userID, "Ops", "ops@example.com"))
+	mock.ExpectExec(`INSERT INTO user_roles`).
+		WithArgs(userID, models.RoleAdmin).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := runUser([]string{"create", "--name", "Ops", "--email", "ops@example.com", "--admin"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserCreateAdminGrantFails(t *testing.T) {
+	mock := useMockDB(t, "correct horse battery staple\n")
+	userID := uuid.New()
+
+	// The user is rolled back with the grant, so the command can be re-run.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(userID, "Ops", "ops@example.com"))
+	mock.ExpectExec(`INSERT INTO user_roles`).
+		WithArgs(userID, models.RoleAdmin).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if err := runUser([]string{"create", "--name", "Ops", "--email", "ops@example.com", "--admin"}); err == nil {
+		t.Fatal("created the user without the ADMIN role")
+	}
+}
+
+func TestUserCreateRejectsWeakPassword(t *testing.T) {
+	mock := useMockDB(t, "short\n")
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	if err := runUser([]string{"create", "--name", "Ops", "--email", "ops@example.com"}); err != models.ErrWeakPassword {
+		t.Fatalf("err = %v, want %v", err, models.ErrWeakPassword)
+	}
+}
+
+func TestUserSetPassword(t *testing.T) {
+	mock := useMockDB(t, "correct horse battery staple")
+	userID := uuid.New()
+
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(userID, "user@example.com"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET password_hash = \$1 WHERE id = \$2`).
+		WithArgs(sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE refresh_tokens`).
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(userID, models.EventPasswordSet, sqlmock.AnyArg(), "auth-service-cli", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := runUser([]string{"set-password", "--email", "user@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionsRevokeByID(t *testing.T) {
+	mock := useMockDB(t, "")
+	userID := uuid.New()
+
+	// Looked up once to resolve the reference and once by the repository.
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM users WHERE id = \$1`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(userID, "user@example.com"))
+	}
+	mock.ExpectExec(`UPDATE refresh_tokens\s+SET is_revoked = true\s+WHERE user_id = \$1 AND is_revoked = false`).
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(userID, models.EventSessionsRevoked, sqlmock.AnyArg(), "auth-service-cli", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := runSessions([]string{"revoke", "--user", userID.String()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeUserRepo answers user lookups from a fixed list.
+type fakeUserRepo struct {
+	repositories.AuthRepositoryInterface
+	users []models.User
+}
+
+func (f *fakeUserRepo) GetUser(userID uuid.UUID) (*models.User, error) {
+	for i := range f.users {
+		if f.users[i].ID == userID {
+			return &f.users[i], nil
+		}
+	}
+	return nil, models.ErrUserNotFound
+}
+
+func (f *fakeUserRepo) GetUserByEmail(email string) (*models.User, error) {
+	for i := range f.users {
+		if f.users[i].Email == email {
+			return &f.users[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func TestFindUser(t *testing.T) {
+	user := models.User{ID: uuid.New(), Email: "user@example.com"}
+	repo := &fakeUserRepo{users: []models.User{user}}
+
+	for _, ref := range []string{user.ID.String(), "user@example.com"} {
+		if found, err := findUser(repo, ref); err != nil || found.ID != user.ID {
+			t.Errorf("findUser(%q) = %v, %v", ref, found, err)
+		}
+	}
+	for _, ref := range []string{uuid.NewString(), "nobody@example.com"} {
+		if _, err := findUser(repo, ref); err != models.ErrUserNotFound {
+			t.Errorf("findUser(%q): err = %v, want %v", ref, err, models.ErrUserNotFound)
+		}
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"secret\n", "secret", false},
+		{"secret\r\nignored\n", "secret", false},
+		{"no newline", "no newline", false},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := readPassword(strings.NewReader(tt.input))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("readPassword(%q) = %q, %v", tt.input, got, err)
+		}
+	}
+}
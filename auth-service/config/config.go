@@ -0,0 +1,319 @@
+// Package config holds the settings of the service. They are loaded once at
+// startup from built-in defaults, an optional YAML file, a .env file and the
+// environment, in increasing order of precedence, and validated before
+// anything else runs.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// MinSecretLength is the shortest accepted value for the keys that protect
+// tokens and data at rest.
+const MinSecretLength = 32
+
+// FileEnv names the environment variable pointing at the optional YAML file.
+const FileEnv = "CONFIG_FILE"
+
+type Config struct {
+	Port        int    `yaml:"port" env:"PORT"`
+	PublicURL   string `yaml:"publicUrl" env:"PUBLIC_URL"`
+	FrontendURL string `yaml:"frontendUrl" env:"FRONTEND_URL"`
+
+	RequireEmailVerification bool   `yaml:"requireEmailVerification" env:"REQUIRE_EMAIL_VERIFICATION"`
+	RateLimits               string `yaml:"rateLimits" env:"RATE_LIMITS"`
+
+	// RefreshTokenSecret keys the hashes of stored tokens and the signatures
+	// of email links. Changing it signs everyone out.
+	RefreshTokenSecret Secret `yaml:"refreshTokenSecret" env:"REFRESH_TOKEN_SECRET"`
+	// DataEncryptionKey encrypts TOTP secrets and other data at rest.
+	DataEncryptionKey Secret `yaml:"dataEncryptionKey" env:"DATA_ENCRYPTION_KEY"`
+
+	Server   ServerConfig            `yaml:"server"`
+	Database DatabaseConfig          `yaml:"database"`
+	JWT      JWTConfig               `yaml:"jwt"`
+	Tokens   TokenConfig             `yaml:"tokens"`
+	Cookie   CookieConfig            `yaml:"cookie"`
+	Lockout  LockoutConfig           `yaml:"lockout"`
+	Mail     MailConfig              `yaml:"mail"`
+	WebAuthn WebAuthnConfig          `yaml:"webauthn"`
+	OIDC     map[string]OIDCProvider `yaml:"oidcProviders"`
+}
+
+// ServerConfig bounds how long clients may take over a request and how the
+// server stops. On SIGTERM readiness fails for DrainDelay, so load balancers
+// stop sending traffic, then in-flight requests get ShutdownTimeout to finish.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT"`
+	MaxHeaderBytes    int           `yaml:"maxHeaderBytes" env:"SERVER_MAX_HEADER_BYTES"`
+
+	DrainDelay      time.Duration `yaml:"drainDelay" env:"SERVER_DRAIN_DELAY"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password Secret `yaml:"password" env:"DB_PASSWORD"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"sslMode" env:"DB_SSLMODE"`
+
+	MaxOpenConns    int           `yaml:"maxOpenConns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"maxIdleConns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime" env:"DB_CONN_MAX_LIFETIME"`
+}
+
+type JWTConfig struct {
+	Issuer           string `yaml:"issuer" env:"JWT_ISSUER"`
+	Audience         string `yaml:"audience" env:"JWT_AUDIENCE"`
+	SigningAlgorithm string `yaml:"signingAlgorithm" env:"JWT_SIGNING_ALG"`
+
+	// KeyRotationInterval is how often a new signing key replaces the active
+	// one. The replaced key stays published for KeyOverlap so that tokens it
+	// signed keep verifying until they expire.
+	KeyRotationInterval time.Duration `yaml:"keyRotationInterval" env:"JWT_KEY_ROTATION_INTERVAL"`
+	KeyOverlap          time.Duration `yaml:"keyOverlap" env:"JWT_KEY_OVERLAP"`
+}
+
+// TokenConfig holds how long each kind of token or challenge stays valid.
+type TokenConfig struct {
+	Access            time.Duration `yaml:"access" env:"ACCESS_TOKEN_LIFETIME"`
+	Refresh           time.Duration `yaml:"refresh" env:"REFRESH_TOKEN_LIFETIME"`
+	PasswordReset     time.Duration `yaml:"passwordReset" env:"PASSWORD_RESET_TOKEN_LIFETIME"`
+	EmailVerification time.Duration `yaml:"emailVerification" env:"EMAIL_VERIFICATION_TOKEN_LIFETIME"`
+	MFAChallenge      time.Duration `yaml:"mfaChallenge" env:"MFA_CHALLENGE_LIFETIME"`
+	WebAuthnChallenge time.Duration `yaml:"webauthnChallenge" env:"WEBAUTHN_CHALLENGE_LIFETIME"`
+	MagicLink         time.Duration `yaml:"magicLink" env:"MAGIC_LINK_LIFETIME"`
+	OAuthState        time.Duration `yaml:"oauthState" env:"OAUTH_STATE_LIFETIME"`
+	AuthorizationCode time.Duration `yaml:"authorizationCode" env:"AUTHORIZATION_CODE_LIFETIME"`
+	Service           time.Duration `yaml:"service" env:"SERVICE_TOKEN_LIFETIME"`
+	APIKey            time.Duration `yaml:"apiKey" env:"API_KEY_LIFETIME"`
+	// APIKeyRotationGrace keeps a rotated key working while services roll
+	// out its replacement.
+	APIKeyRotationGrace time.Duration `yaml:"apiKeyRotationGrace" env:"API_KEY_ROTATION_GRACE"`
+}
+
+// CookieConfig applies to every cookie the service sets. Secure must be on
+// whenever the service is reached over HTTPS.
+type CookieConfig struct {
+	Secure   bool   `yaml:"secure" env:"COOKIE_SECURE"`
+	Domain   string `yaml:"domain" env:"COOKIE_DOMAIN"`
+	SameSite string `yaml:"sameSite" env:"COOKIE_SAMESITE"`
+}
+
+// LockoutConfig controls how failed logins lock an account or a source IP.
+type LockoutConfig struct {
+	Threshold   int           `yaml:"threshold" env:"LOGIN_MAX_FAILED_ATTEMPTS"`
+	IPThreshold int           `yaml:"ipThreshold" env:"LOGIN_MAX_FAILED_ATTEMPTS_PER_IP"`
+	Window      time.Duration `yaml:"window" env:"LOGIN_FAILURE_WINDOW"`
+	BaseLock    time.Duration `yaml:"baseLock" env:"LOGIN_LOCKOUT_BASE"`
+	MaxLock     time.Duration `yaml:"maxLock" env:"LOGIN_LOCKOUT_MAX"`
+}
+
+// MailConfig selects SMTP delivery. Without Addr, mail is only logged.
+type MailConfig struct {
+	Addr     string `yaml:"addr" env:"SMTP_ADDR"`
+	From     string `yaml:"from" env:"SMTP_FROM"`
+	Username string `yaml:"username" env:"SMTP_USERNAME"`
+	Password Secret `yaml:"password" env:"SMTP_PASSWORD"`
+}
+
+// WebAuthnConfig describes this service to authenticators. Empty values are
+// derived from FrontendURL.
+type WebAuthnConfig struct {
+	RPID    string   `yaml:"rpId" env:"WEBAUTHN_RP_ID"`
+	RPName  string   `yaml:"rpName" env:"WEBAUTHN_RP_NAME"`
+	Origins []string `yaml:"origins" env:"WEBAUTHN_ORIGINS"`
+}
+
+// OIDCProvider configures one identity provider for social login. In the
+// environment, OIDC_PROVIDERS lists the provider names and each one is set
+// through OIDC_<NAME>_* variables, e.g. OIDC_GOOGLE_CLIENT_ID. Plain OAuth2
+// providers leave Issuer empty and set AuthURL, TokenURL and UserInfoURL.
+type OIDCProvider struct {
+	Issuer       string   `yaml:"issuer" env:"ISSUER"`
+	ClientID     string   `yaml:"clientId" env:"CLIENT_ID"`
+	ClientSecret Secret   `yaml:"clientSecret" env:"CLIENT_SECRET"`
+	Scopes       []string `yaml:"scopes"`
+	AuthURL      string   `yaml:"authUrl" env:"AUTH_URL"`
+	TokenURL     string   `yaml:"tokenUrl" env:"TOKEN_URL"`
+	JWKSURL      string   `yaml:"jwksUrl" env:"JWKS_URL"`
+	UserInfoURL  string   `yaml:"userInfoUrl" env:"USERINFO_URL"`
+}
+
+const defaultRateLimits = "/login=ip:20/1m,email:10/1m;" +
+	"/login/mfa=ip:20/1m;" +
+	"/login/webauthn/begin=ip:30/1m;" +
+	"/login/webauthn/finish=ip:30/1m;" +
+	"/login/magic=ip:10/1h,email:5/1h;" +
+	"/login/magic/verify=ip:20/1m;" +
+	"/login/oauth/:provider=ip:30/1m;" +
+	"/token=ip:60/1m;" +
+	"/service/token=ip:60/1m;" +
+	"/register=ip:10/1h;" +
+	"/refresh=ip:60/1m;" +
+	"/password/forgot=ip:5/1h,email:3/1h;" +
+	"/password/reset=ip:10/1m,ip:30/1h;" +
+	"/email/verify=ip:10/1m,ip:30/1h;" +
+	"/email/verify/resend=ip:5/1h,email:3/1h;" +
+	"/me/mfa/totp/disable=ip:5/1m,ip:20/1h;" +
+	"/me/webauthn/register/finish=ip:5/1m,ip:20/1h;" +
+	"/me/webauthn/credentials/:id=ip:10/1m,ip:40/1h"
+
+// Default returns the built-in settings. They are complete except for the
+// database credentials and secrets, which have no safe default.
+func Default() *Config {
+	return &Config{
+		Port:        8080,
+		PublicURL:   "http://localhost:8080",
+		FrontendURL: "http://localhost:3000",
+		RateLimits:  defaultRateLimits,
+		Server: ServerConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    64 << 10,
+			DrainDelay:        5 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			SSLMode:         "disable",
+			MaxOpenConns:    100,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: time.Hour,
+		},
+		JWT: JWTConfig{
+			Issuer:              "auth-service",
+			Audience:            "shopper",
+			SigningAlgorithm:    "RS256",
+			KeyRotationInterval: 30 * 24 * time.Hour,
+			KeyOverlap:          24 * time.Hour,
+		},
+		Tokens: TokenConfig{
+			Access:              15 * time.Minute,
+			Refresh:             7 * 24 * time.Hour,
+			PasswordReset:       30 * time.Minute,
+			EmailVerification:   24 * time.Hour,
+			MFAChallenge:        5 * time.Minute,
+			WebAuthnChallenge:   5 * time.Minute,
+			MagicLink:           10 * time.Minute,
+			OAuthState:          10 * time.Minute,
+			AuthorizationCode:   time.Minute,
+			Service:             5 * time.Minute,
+			APIKey:              90 * 24 * time.Hour,
+			APIKeyRotationGrace: 24 * time.Hour,
+		},
+		Cookie: CookieConfig{
+			SameSite: "lax",
+		},
+		Lockout: LockoutConfig{
+			Threshold:   5,
+			IPThreshold: 50,
+			Window:      time.Hour,
+			BaseLock:    time.Minute,
+			MaxLock:     time.Hour,
+		},
+		WebAuthn: WebAuthnConfig{
+			RPName: "Shopper",
+		},
+	}
+}
+
+var (
+	current  atomic.Pointer[Config]
+	defaults = Default()
+)
+
+// Current returns the configuration installed by Load, or the defaults when
+// nothing has been loaded.
+func Current() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
+	}
+	return defaults
+}
+
+// Set installs cfg as the Current configuration without validating it; nil
+// restores the defaults. Tests use it to run code under specific settings.
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// Load reads the configuration, validates it and makes it the Current one.
+// The YAML file at path, or at $CONFIG_FILE when path is empty, is optional;
+// a missing .env file is ignored. Every problem found is reported at once.
+func Load(path string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := errors.Join(cfg.applyEnv(), cfg.Validate()); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	current.Store(cfg)
+	return cfg, nil
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// String renders the configuration as YAML with every secret redacted, for
+// logs and the config command.
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("config: %v", err)
+	}
+	return string(out)
+}
+
+// SameSiteMode converts the SameSite setting for net/http.
+func (c CookieConfig) SameSiteMode() http.SameSite {
+	switch c.SameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"auth-service/pkg/ratelimit"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultRateLimits(t *testing.T) {
+	routes, err := ratelimit.ParseRoutes(defaultRateLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every route that checks a credential or a secret token.
+	for _, route := range []string{
+		"/login", "/login/mfa", "/login/webauthn/finish", "/login/magic/verify",
+		"/password/forgot", "/password/reset", "/email/verify", "/email/verify/resend",
+		"/me/mfa/totp/disable", "/me/webauthn/register/finish", "/me/webauthn/credentials/:id", "/token", "/service/token", "/refresh",
+	} {
+		if len(routes[route]) == 0 {
+			t.Errorf("no default rate limit for %s", route)
+		}
+	}
+}
+
+const (
+	testSecret = "0123456789abcdef0123456789abcdef"
+	testKey    = "fedcba9876543210fedcba9876543210"
+)
+
+// validConfig returns the defaults completed with the settings that have
+// none.
+func validConfig() *Config {
+	cfg := Default()
+	cfg.RefreshTokenSecret = testSecret
+	cfg.DataEncryptionKey = testKey
+	cfg.Database.User = "auth"
+	cfg.Database.Password = "db-password"
+	cfg.Database.Name = "auth"
+	return cfg
+}
+
+// setRequiredEnv provides what Load needs besides the defaults.
+func setRequiredEnv(t *testing.T) {
+	t.Setenv("REFRESH_TOKEN_SECRET", testSecret)
+	t.Setenv("DATA_ENCRYPTION_KEY", testKey)
+	t.Setenv("DB_USER", "auth")
+	t.Setenv("DB_NAME", "auth")
+	t.Setenv(FileEnv, "")
+	t.Cleanup(func() { Set(nil) })
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("valid configuration rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"missing secret", func(c *Config) { c.RefreshTokenSecret = "" }, "refreshTokenSecret (REFRESH_TOKEN_SECRET) is required"},
+		{"short secret", func(c *Config) { c.DataEncryptionKey = "short" }, "dataEncryptionKey (DATA_ENCRYPTION_KEY) must be at least 32 characters"},
+		{"port", func(c *Config) { c.Port = 70000 }, "port (PORT)"},
+		{"relative URL", func(c *Config) { c.PublicURL = "/auth" }, "publicUrl (PUBLIC_URL) must be an absolute URL"},
+		{"database user", func(c *Config) { c.Database.User = "" }, "database.user (DB_USER) is required"},
+		{"idle above open", func(c *Config) { c.Database.MaxIdleConns = 200 }, "database.maxIdleConns"},
+		{"signing algorithm", func(c *Config) { c.JWT.SigningAlgorithm = "HS256" }, "jwt.signingAlgorithm"},
+		{"token lifetime", func(c *Config) { c.Tokens.Access = 0 }, "tokens.access (ACCESS_TOKEN_LIFETIME) must be positive"},
+		{"key rotation interval", func(c *Config) { c.JWT.KeyRotationInterval = 0 }, "jwt.keyRotationInterval (JWT_KEY_ROTATION_INTERVAL) must be positive"},
+		{"access token outlives its key", func(c *Config) { c.Tokens.Access = 48 * time.Hour }, "must not be longer than jwt.keyOverlap"},
+		{"same site", func(c *Config) { c.Cookie.SameSite = "sometimes" }, "cookie.sameSite (COOKIE_SAMESITE)"},
+		{"same site none without secure", func(c *Config) { c.Cookie.SameSite = "none" }, "requires cookie.secure"},
+		{"lockout", func(c *Config) { c.Lockout.MaxLock = time.Second }, "lockout.maxLock"},
+		{"mail without sender", func(c *Config) { c.Mail.Addr = "smtp.example.com:587" }, "mail.from (SMTP_FROM) is required"},
+		{"webauthn origin", func(c *Config) { c.WebAuthn.Origins = []string{"example.com"} }, "webauthn.origins"},
+		{"oidc endpoints", func(c *Config) {
+			c.OIDC = map[string]OIDCProvider{"github": {ClientID: "id", AuthURL: "https://github.com/login/oauth/authorize"}}
+		}, "oidcProviders.github needs an issuer"},
+	}
+
+	for _, tt := range tests {
+		cfg := validConfig()
+		tt.change(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	err := (&Config{}).Validate()
+	if err == nil {
+		t.Fatal("empty configuration accepted")
+	}
+	for _, want := range []string{"REFRESH_TOKEN_SECRET", "DATA_ENCRYPTION_KEY", "DB_HOST", "JWT_ISSUER", "ACCESS_TOKEN_LIFETIME"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("PORT", "9090")
+	t.Setenv("ACCESS_TOKEN_LIFETIME", "10m")
+	t.Setenv("DB_MAX_OPEN_CONNS", "20")
+	t.Setenv("COOKIE_SECURE", "true")
+	t.Setenv("WEBAUTHN_ORIGINS", "https://shop.example.com, https://m.example.com")
+	t.Setenv("OIDC_PROVIDERS", "Google")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("OIDC_GOOGLE_SCOPES", "openid email")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Current() != cfg {
+		t.Error("Load did not install the configuration")
+	}
+
+	if cfg.Port != 9090 || cfg.Tokens.Access != 10*time.Minute || cfg.Database.MaxOpenConns != 20 || !cfg.Cookie.Secure {
+		t.Errorf("environment not applied: port %d, access %s, max open %d, secure %t",
+			cfg.Port, cfg.Tokens.Access, cfg.Database.MaxOpenConns, cfg.Cookie.Secure)
+	}
+	if got := cfg.WebAuthn.Origins; len(got) != 2 || got[1] != "https://m.example.com" {
+		t.Errorf("origins = %q", got)
+	}
+	google := cfg.OIDC["google"]
+	if google.ClientID != "google-client" || len(google.Scopes) != 2 {
+		t.Errorf("google provider = %+v", google)
+	}
+	if cfg.Tokens.Refresh != Default().Tokens.Refresh {
+		t.Errorf("unset refresh lifetime = %s, want the default", cfg.Tokens.Refresh)
+	}
+}
+
+func TestLoadRejectsInvalidEnv(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("PORT", "eighty")
+	t.Setenv("ACCESS_TOKEN_LIFETIME", "15")
+	t.Setenv("DATA_ENCRYPTION_KEY", "")
+
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("invalid environment accepted")
+	}
+	for _, want := range []string{"PORT:", "ACCESS_TOKEN_LIFETIME:", "DATA_ENCRYPTION_KEY) is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+	if Current() != defaults {
+		t.Error("an invalid configuration was installed")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeFile(t, `
+port: 7070
+database:
+  host: db.internal
+  maxOpenConns: 40
+tokens:
+  refresh: 72h
+cookie:
+  domain: example.com
+`)
+	// The environment wins over the file.
+	t.Setenv("DB_MAX_OPEN_CONNS", "30")
+	t.Setenv(FileEnv, path)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 7070 || cfg.Database.Host != "db.internal" || cfg.Tokens.Refresh != 72*time.Hour || cfg.Cookie.Domain != "example.com" {
+		t.Errorf("file not applied: %+v", cfg)
+	}
+	if cfg.Database.MaxOpenConns != 30 {
+		t.Errorf("maxOpenConns = %d, want the environment's 30", cfg.Database.MaxOpenConns)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	setRequiredEnv(t)
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing config file accepted")
+	}
+	// A misspelt key would otherwise be ignored silently.
+	if _, err := Load(writeFile(t, "tokens:\n  acess: 5m\n")); err == nil || !strings.Contains(err.Error(), "acess") {
+		t.Errorf("unknown key: err = %v", err)
+	}
+	if _, err := Load(writeFile(t, "")); err != nil {
+		t.Errorf("empty config file: %v", err)
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	cfg := validConfig()
+	cfg.Mail.Password = "smtp-password"
+	cfg.OIDC = map[string]OIDCProvider{"google": {Issuer: "https://accounts.google.com", ClientID: "id", ClientSecret: "oidc-secret"}}
+
+	jsonOut, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]string{
+		"String": cfg.String(),
+		"%v":     fmt.Sprintf("%v", cfg.RefreshTokenSecret),
+		"%+v":    fmt.Sprintf("%+v", *cfg),
+		"%#v":    fmt.Sprintf("%#v", *cfg),
+		"JSON":   string(jsonOut),
+	}
+	for format, out := range outputs {
+		for _, secret := range []string{testSecret, testKey, "db-password", "smtp-password", "oidc-secret"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s output reveals %q", format, secret)
+			}
+		}
+	}
+	if !strings.Contains(cfg.String(), "refreshTokenSecret: '[redacted]'") {
+		t.Errorf("String does not show the redacted secret:\n%s", cfg)
+	}
+
+	if cfg.RefreshTokenSecret.Reveal() != testSecret {
+		t.Error("Reveal does not return the value")
+	}
+	if Secret("").String() != "" {
+		t.Error("an unset secret prints as set")
+	}
+}
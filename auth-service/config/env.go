@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides settings with the environment variables named in the
+// env tags. Lists are comma separated, except OIDC scopes which follow the
+// space separated OAuth convention.
+func (c *Config) applyEnv() error {
+	var errs []error
+	setFromEnv(reflect.ValueOf(c).Elem(), "", &errs)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if c.OIDC == nil {
+			c.OIDC = make(map[string]OIDCProvider)
+		}
+
+		provider := c.OIDC[name]
+		setFromEnv(reflect.ValueOf(&provider).Elem(), "OIDC_"+strings.ToUpper(name)+"_", &errs)
+		if scopes, ok := os.LookupEnv("OIDC_" + strings.ToUpper(name) + "_SCOPES"); ok {
+			provider.Scopes = strings.Fields(scopes)
+		}
+		c.OIDC[name] = provider
+	}
+
+	return errors.Join(errs...)
+}
+
+func setFromEnv(v reflect.Value, prefix string, errs *[]error) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			setFromEnv(field, prefix, errs)
+			continue
+		}
+
+		key, ok := v.Type().Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		key = prefix + key
+
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		if err := setValue(field, strings.TrimSpace(value)); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+const redacted = "[redacted]"
+
+// Secret is a string that does not print. fmt, JSON and YAML output show a
+// placeholder instead of the value; use Reveal to get it.
+type Secret string
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
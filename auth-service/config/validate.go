@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+)
+
+var signingAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// Validate checks the configuration and returns every problem found, joined.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	required := func(value, name string) {
+		check(value != "", "%s is required", name)
+	}
+	secret := func(value Secret, name string) {
+		check(value != "", "%s is required", name)
+		check(value == "" || len(value) >= MinSecretLength, "%s must be at least %d characters", name, MinSecretLength)
+	}
+	absoluteURL := func(value, name string) {
+		u, err := url.Parse(value)
+		check(err == nil && u.IsAbs() && u.Host != "", "%s must be an absolute URL", name)
+	}
+	positive := func(d time.Duration, name string) {
+		check(d > 0, "%s must be positive", name)
+	}
+
+	check(c.Port > 0 && c.Port < 65536, "port (PORT) must be between 1 and 65535")
+	absoluteURL(c.PublicURL, "publicUrl (PUBLIC_URL)")
+	absoluteURL(c.FrontendURL, "frontendUrl (FRONTEND_URL)")
+	secret(c.RefreshTokenSecret, "refreshTokenSecret (REFRESH_TOKEN_SECRET)")
+	secret(c.DataEncryptionKey, "dataEncryptionKey (DATA_ENCRYPTION_KEY)")
+
+	s := c.Server
+	positive(s.ReadHeaderTimeout, "server.readHeaderTimeout (SERVER_READ_HEADER_TIMEOUT)")
+	positive(s.ReadTimeout, "server.readTimeout (SERVER_READ_TIMEOUT)")
+	positive(s.WriteTimeout, "server.writeTimeout (SERVER_WRITE_TIMEOUT)")
+	positive(s.IdleTimeout, "server.idleTimeout (SERVER_IDLE_TIMEOUT)")
+	check(s.MaxHeaderBytes >= 4<<10, "server.maxHeaderBytes (SERVER_MAX_HEADER_BYTES) must be at least 4096")
+	check(s.DrainDelay >= 0, "server.drainDelay (SERVER_DRAIN_DELAY) must not be negative")
+	positive(s.ShutdownTimeout, "server.shutdownTimeout (SERVER_SHUTDOWN_TIMEOUT)")
+
+	db := c.Database
+	required(db.Host, "database.host (DB_HOST)")
+	required(db.User, "database.user (DB_USER)")
+	required(db.Name, "database.name (DB_NAME)")
+	check(db.Port > 0 && db.Port < 65536, "database.port (DB_PORT) must be between 1 and 65535")
+	check(db.MaxOpenConns > 0, "database.maxOpenConns (DB_MAX_OPEN_CONNS) must be positive")
+	check(db.MaxIdleConns >= 0 && db.MaxIdleConns <= db.MaxOpenConns,
+		"database.maxIdleConns (DB_MAX_IDLE_CONNS) must be between 0 and maxOpenConns")
+	check(db.ConnMaxLifetime >= 0, "database.connMaxLifetime (DB_CONN_MAX_LIFETIME) must not be negative")
+
+	required(c.JWT.Issuer, "jwt.issuer (JWT_ISSUER)")
+	required(c.JWT.Audience, "jwt.audience (JWT_AUDIENCE)")
+	check(slices.Contains(signingAlgorithms, c.JWT.SigningAlgorithm),
+		"jwt.signingAlgorithm (JWT_SIGNING_ALG) must be one of %v", signingAlgorithms)
+	positive(c.JWT.KeyRotationInterval, "jwt.keyRotationInterval (JWT_KEY_ROTATION_INTERVAL)")
+	positive(c.JWT.KeyOverlap, "jwt.keyOverlap (JWT_KEY_OVERLAP)")
+
+	t := c.Tokens
+	positive(t.Access, "tokens.access (ACCESS_TOKEN_LIFETIME)")
+	positive(t.Refresh, "tokens.refresh (REFRESH_TOKEN_LIFETIME)")
+	positive(t.PasswordReset, "tokens.passwordReset (PASSWORD_RESET_TOKEN_LIFETIME)")
+	positive(t.EmailVerification, "tokens.emailVerification (EMAIL_VERIFICATION_TOKEN_LIFETIME)")
+	positive(t.MFAChallenge, "tokens.mfaChallenge (MFA_CHALLENGE_LIFETIME)")
+	positive(t.WebAuthnChallenge, "tokens.webauthnChallenge (WEBAUTHN_CHALLENGE_LIFETIME)")
+	positive(t.MagicLink, "tokens.magicLink (MAGIC_LINK_LIFETIME)")
+	positive(t.OAuthState, "tokens.oauthState (OAUTH_STATE_LIFETIME)")
+	positive(t.AuthorizationCode, "tokens.authorizationCode (AUTHORIZATION_CODE_LIFETIME)")
+	positive(t.Service, "tokens.service (SERVICE_TOKEN_LIFETIME)")
+	positive(t.APIKey, "tokens.apiKey (API_KEY_LIFETIME)")
+	check(t.APIKeyRotationGrace >= 0, "tokens.apiKeyRotationGrace (API_KEY_ROTATION_GRACE) must not be negative")
+	// Access tokens signed just before a rotation must outlive their key's
+	// retirement, or they fail verification while still unexpired.
+	check(t.Access <= c.JWT.KeyOverlap,
+		"tokens.access (ACCESS_TOKEN_LIFETIME) must not be longer than jwt.keyOverlap (JWT_KEY_OVERLAP)")
+
+	check(slices.Contains([]string{"lax", "strict", "none"}, c.Cookie.SameSite),
+		"cookie.sameSite (COOKIE_SAMESITE) must be lax, strict or none")
+	check(c.Cookie.SameSite != "none" || c.Cookie.Secure,
+		"cookie.sameSite none requires cookie.secure (COOKIE_SECURE)")
+
+	l := c.Lockout
+	check(l.Threshold > 0, "lockout.threshold (LOGIN_MAX_FAILED_ATTEMPTS) must be positive")
+	check(l.IPThreshold > 0, "lockout.ipThreshold (LOGIN_MAX_FAILED_ATTEMPTS_PER_IP) must be positive")
+	positive(l.Window, "lockout.window (LOGIN_FAILURE_WINDOW)")
+	positive(l.BaseLock, "lockout.baseLock (LOGIN_LOCKOUT_BASE)")
+	check(l.MaxLock >= l.BaseLock, "lockout.maxLock (LOGIN_LOCKOUT_MAX) must not be shorter than baseLock")
+
+	if c.Mail.Addr != "" {
+		required(c.Mail.From, "mail.from (SMTP_FROM)")
+	}
+
+	for _, origin := range c.WebAuthn.Origins {
+		absoluteURL(origin, "webauthn.origins (WEBAUTHN_ORIGINS)")
+	}
+
+	for name, p := range c.OIDC {
+		required(p.ClientID, "oidcProviders."+name+".clientId")
+		check(p.Issuer != "" || (p.AuthURL != "" && p.TokenURL != "" && p.UserInfoURL != ""),
+			"oidcProviders.%s needs an issuer, or authUrl, tokenUrl and userInfoUrl", name)
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,19 +1,30 @@
 package handlers
 
 import (
+	"auth-service/config"
 	"auth-service/models"
 	"auth-service/repositories"
+	"auth-service/utils"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
+const magicLinkNonceCookie = "magicLinkNonce"
+
 type AuthHandler struct {
 	authRepo repositories.AuthRepositoryInterface
+	mailer   utils.MailSender
 }
 
-func NewAuthHandler(authRepo repositories.AuthRepositoryInterface) *AuthHandler {
-	return &AuthHandler{authRepo: authRepo}
+func NewAuthHandler(authRepo repositories.AuthRepositoryInterface, mailer utils.MailSender) *AuthHandler {
+	return &AuthHandler{authRepo: authRepo, mailer: mailer}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -28,12 +39,22 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authRepo.Register(input.Name, input.Email, input.Password)
+	result, err := h.authRepo.Register(input.Name, input.Email, input.Password, clientInfo(c))
 	if err != nil {
 		c.JSON(getStatusCode(err), err)
 		return
 	}
 
+	h.sendVerificationEmail(result.User)
+
+	if result.RefreshToken == nil {
+		c.JSON(http.StatusCreated, models.AuthenticationResponse{
+			User:                      result.User,
+			EmailVerificationRequired: true,
+		})
+		return
+	}
+
 	setRefreshTokenCookie(c, result.RefreshToken)
 	c.JSON(http.StatusOK, models.AuthenticationResponse{
 		User:            result.User,
@@ -54,7 +75,109 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authRepo.Login(input.Email, input.Password)
+	result, err := h.authRepo.Login(input.Email, input.Password, clientInfo(c))
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	writeLoginResult(c, result)
+}
+
+// LoginMFA exchanges the challenge returned by Login and a TOTP or recovery
+// code for a session.
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var input struct {
+		MFAToken string `json:"mfaToken" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	result, err := h.authRepo.LoginMFA(input.MFAToken, input.Code, clientInfo(c))
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	writeLoginResult(c, result)
+}
+
+// RequestMagicLink emails a sign-in link. The response never reveals whether
+// the account exists; the nonce cookie is set either way.
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	nonce, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrInternalServer)
+		return
+	}
+
+	link, err := h.authRepo.RequestMagicLink(input.Email, nonce)
+	if err != nil {
+		log.Printf("magic link request failed: %v", err)
+	}
+	if link != nil {
+		go h.sendMail(models.Email{
+			To:      link.User.Email,
+			Subject: "Your sign-in link",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nUse the link below to sign in. It expires at %s and only works in the browser where you requested it.\n\n%s\n\nIf you did not ask for this, you can ignore this email.",
+				link.User.Name,
+				link.ExpiresAt.Format(time.RFC1123),
+				utils.FrontendURL("/login/magic?token="+url.QueryEscape(link.Token)),
+			),
+		})
+	}
+
+	setCookie(c, magicLinkNonceCookie, nonce, int(config.Current().Tokens.MagicLink.Seconds()), "/login/magic")
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "if an account with that email exists, a sign-in link has been sent",
+	})
+}
+
+func (h *AuthHandler) VerifyMagicLink(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	nonce, _ := c.Cookie(magicLinkNonceCookie)
+	result, err := h.authRepo.VerifyMagicLink(input.Token, nonce, clientInfo(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	setCookie(c, magicLinkNonceCookie, "", -1, "/login/magic")
+	writeLoginResult(c, result)
+}
+
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	token, err := c.Cookie("refreshToken")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.New("token_required", "refresh token required"))
+		return
+	}
+
+	result, err := h.authRepo.RefreshToken(token, clientInfo(c))
 	if err != nil {
 		c.JSON(getStatusCode(err), err)
 		return
@@ -62,28 +185,340 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	setRefreshTokenCookie(c, result.RefreshToken)
 	c.JSON(http.StatusOK, models.AuthenticationResponse{
-		User:            result.User,
 		AccessToken:     result.AccessToken,
 		AccessTokenExp:  result.AccessTokenExpiry,
 		RefreshTokenExp: result.RefreshToken.ExpiresAt.Unix(),
 	})
 }
 
-func (h *AuthHandler) RefreshToken(c *gin.Context) {
+func (h *AuthHandler) Logout(c *gin.Context) {
+	token, err := c.Cookie("refreshToken")
+	// The cookie is expired whatever the outcome, so a browser holding an
+	// unknown or revoked token is still signed out.
+	clearRefreshTokenCookie(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.New("token_required", "refresh token required"))
+		return
+	}
+
+	if err := h.authRepo.Logout(token); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
 	token, err := c.Cookie("refreshToken")
+	// The cookie is expired whatever the outcome, so a browser holding an
+	// unknown or revoked token is still signed out.
+	clearRefreshTokenCookie(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, models.New("token_required", "refresh token required"))
 		return
 	}
 
-	result, err := h.authRepo.RefreshToken(token)
+	if err := h.authRepo.LogoutAll(token); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	token, err := c.Cookie("refreshToken")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.New("token_required", "refresh token required"))
+		return
+	}
+
+	sessions, err := h.authRepo.ListSessions(token)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	token, err := c.Cookie("refreshToken")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.New("token_required", "refresh token required"))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.authRepo.RevokeSession(token, sessionID); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	reset, err := h.authRepo.RequestPasswordReset(input.Email)
+	if err != nil {
+		log.Printf("password reset request failed: %v", err)
+	}
+	if reset != nil {
+		go h.sendMail(models.Email{
+			To:      reset.User.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nUse the link below to choose a new password. It expires at %s.\n\n%s\n\nIf you did not ask for this, you can ignore this email.",
+				reset.User.Name,
+				reset.ExpiresAt.Format(time.RFC1123),
+				utils.FrontendURL("/password/reset?token="+url.QueryEscape(reset.Token)),
+			),
+		})
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "if an account with that email exists, a reset link has been sent",
+	})
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=8"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.authRepo.ResetPassword(input.Token, input.Password, clientInfo(c)); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	clearRefreshTokenCookie(c)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) Me(c *gin.Context) {
+	userID := currentUserID(c)
+
+	user, err := h.authRepo.GetUser(userID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	roles, err := h.authRepo.GetUserRoles(userID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.UserWithRoles{User: user, Roles: roles})
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	currentToken, _ := c.Cookie("refreshToken")
+	if err := h.authRepo.ChangePassword(currentUserID(c), input.CurrentPassword, input.NewPassword, currentToken, clientInfo(c)); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) EnrollTOTP(c *gin.Context) {
+	enrollment, err := h.authRepo.EnrollTOTP(currentUserID(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *AuthHandler) ConfirmTOTP(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	codes, err := h.authRepo.ConfirmTOTP(currentUserID(c), input.Code, clientInfo(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	var input struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.authRepo.DisableTOTP(currentUserID(c), input.Password, input.Code, clientInfo(c)); err != nil {
+		setRetryAfter(c, err)
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) UnlockAccount(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	// ?ip= also unlocks an address the user was locked out from.
+	var ip string
+	if raw := c.Query("ip"); raw != "" {
+		parsed := net.ParseIP(raw)
+		if parsed == nil {
+			c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+			return
+		}
+		ip = parsed.String()
+	}
+
+	if err := h.authRepo.UnlockAccount(userID, ip, clientInfo(c)); err != nil {
 		c.JSON(getStatusCode(err), err)
 		return
 	}
 
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	user, err := h.authRepo.VerifyEmail(input.Token, clientInfo(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	user, err := h.authRepo.GetUserByEmail(input.Email)
+	if err != nil {
+		log.Printf("verification resend failed: %v", err)
+	}
+	if user != nil && user.EmailVerifiedAt == nil {
+		h.sendVerificationEmail(user)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "if an unverified account with that email exists, a verification link has been sent",
+	})
+}
+
+func (h *AuthHandler) sendVerificationEmail(user *models.User) {
+	token, err := utils.GenerateEmailVerificationToken(user)
+	if err != nil {
+		log.Printf("failed to generate verification token for %s: %v", user.Email, err)
+		return
+	}
+
+	go h.sendMail(models.Email{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s",
+			user.Name,
+			config.Current().Tokens.EmailVerification,
+			utils.FrontendURL("/email/verify?token="+url.QueryEscape(token)),
+		),
+	})
+}
+
+func (h *AuthHandler) sendMail(email models.Email) {
+	if err := h.mailer.Send(email); err != nil {
+		log.Printf("failed to send %q to %s: %v", email.Subject, email.To, err)
+	}
+}
+
+func clientInfo(c *gin.Context) models.ClientInfo {
+	return models.ClientInfo{
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
+	}
+}
+
+// writeLoginResult answers a sign-in with either the MFA challenge or the
+// session tokens and refresh cookie.
+func writeLoginResult(c *gin.Context, result *models.AuthenticationResult) {
+	if result.MFAChallenge != nil {
+		c.JSON(http.StatusOK, models.AuthenticationResponse{
+			MFARequired: true,
+			MFAToken:    result.MFAChallenge.Token,
+			MFATokenExp: result.MFAChallenge.ExpiresAt.Unix(),
+			MFAMethods:  result.MFAChallenge.Methods,
+		})
+		return
+	}
+
 	setRefreshTokenCookie(c, result.RefreshToken)
 	c.JSON(http.StatusOK, models.AuthenticationResponse{
+		User:            result.User,
 		AccessToken:     result.AccessToken,
 		AccessTokenExp:  result.AccessTokenExpiry,
 		RefreshTokenExp: result.RefreshToken.ExpiresAt.Unix(),
@@ -93,26 +528,50 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 func setRefreshTokenCookie(c *gin.Context, token *models.RefreshToken) {
 	secondsUntilExpiry := int(token.ExpiresAt.Unix() - time.Now().Unix())
 
-	c.SetCookie(
-		"refreshToken",
-		token.Token.String(),
-		secondsUntilExpiry,
-		"/",
-		"",
-		false,
-		true,
-	)
+	setCookie(c, "refreshToken", token.Token, secondsUntilExpiry, "/")
+}
+
+func clearRefreshTokenCookie(c *gin.Context) {
+	setCookie(c, "refreshToken", "", -1, "/")
+}
+
+// setCookie sets an HTTP-only cookie with the configured Secure, Domain and
+// SameSite attributes. A negative maxAge deletes the cookie.
+func setCookie(c *gin.Context, name, value string, maxAge int, path string) {
+	writeCookie(c, config.Current().Cookie, name, value, maxAge, path)
+}
+
+func writeCookie(c *gin.Context, cookie config.CookieConfig, name, value string, maxAge int, path string) {
+	c.SetSameSite(cookie.SameSiteMode())
+	c.SetCookie(name, value, maxAge, path, cookie.Domain, cookie.Secure, true)
+}
+
+func setRetryAfter(c *gin.Context, err error) {
+	if appErr, ok := err.(*models.AppError); ok && appErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.FormatInt(appErr.RetryAfter, 10))
+	}
 }
 
 func getStatusCode(err error) int {
 	switch err.(*models.AppError).Code {
-	case "user_already_exists", "invalid_credentials":
+	case "user_already_exists", "invalid_credentials", "role_already_exists", "role_protected", "last_admin", "permission_already_exists",
+		"mfa_already_enabled", "passkey_already_registered", "identity_conflict", "service_account_already_exists":
 		return http.StatusConflict
-	case "token_not_found":
+	case "token_not_found", "session_not_found", "user_not_found", "role_not_found", "role_not_assigned",
+		"permission_not_found", "permission_not_assigned", "passkey_not_found", "provider_not_found",
+		"client_not_found", "consent_not_found", "service_account_not_found", "api_key_not_found":
 		return http.StatusNotFound
-	case "token_revoked", "token_expired":
+	case "token_revoked", "token_expired", "token_reuse_detected", "email_not_verified", "scope_not_allowed":
 		return http.StatusForbidden
-	case "invalid_input":
+	case "unauthorized", "mfa_invalid_code", "mfa_challenge_invalid", "passkey_invalid", "invalid_client",
+		"api_key_invalid":
+		return http.StatusUnauthorized
+	case "account_locked":
+		return http.StatusLocked
+	case "provider_error":
+		return http.StatusBadGateway
+	case "invalid_input", "wrong_password", "reset_token_invalid", "verification_token_invalid", "weak_password", "mfa_not_enrolled",
+		"reauthentication_required", "magic_link_invalid", "identity_email_missing", "oauth_state_invalid", "invalid_grant":
 		return http.StatusBadRequest
 	default:
 		return http.StatusInternalServerError
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/pkg/webauthn"
+	"auth-service/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeAuthRepo implements the methods a test needs; calling any other one
+// panics on the nil embedded interface.
+type fakeAuthRepo struct {
+	repositories.AuthRepositoryInterface
+	logoutErr         error
+	changePasswordErr error
+	unlockedIP        string
+	magicLink         *models.MagicLink
+	magicLinkNonce    string
+	magicLinkResult   *models.AuthenticationResult
+	passkeyProof      models.Reauthentication
+}
+
+func (f *fakeAuthRepo) Logout(string) error {
+	return f.logoutErr
+}
+
+func (f *fakeAuthRepo) FinishPasskeyRegistration(_ uuid.UUID, name string, _ *webauthn.RegistrationResponse, proof models.Reauthentication, _ models.ClientInfo) (*models.WebAuthnCredential, error) {
+	f.passkeyProof = proof
+	return &models.WebAuthnCredential{Name: name}, nil
+}
+
+func (f *fakeAuthRepo) DeletePasskey(_, _ uuid.UUID, proof models.Reauthentication, _ models.ClientInfo) error {
+	f.passkeyProof = proof
+	return nil
+}
+
+func (f *fakeAuthRepo) LogoutAll(string) error {
+	return f.logoutErr
+}
+
+func (f *fakeAuthRepo) ChangePassword(uuid.UUID, string, string, string, models.ClientInfo) error {
+	return f.changePasswordErr
+}
+
+func (f *fakeAuthRepo) UnlockAccount(_ uuid.UUID, ip string, _ models.ClientInfo) error {
+	f.unlockedIP = ip
+	return nil
+}
+
+func (f *fakeAuthRepo) RequestMagicLink(_, nonce string) (*models.MagicLink, error) {
+	f.magicLinkNonce = nonce
+	return f.magicLink, nil
+}
+
+func (f *fakeAuthRepo) VerifyMagicLink(_, nonce string, _ models.ClientInfo) (*models.AuthenticationResult, error) {
+	f.magicLinkNonce = nonce
+	if f.magicLinkResult == nil {
+		return nil, models.ErrMagicLinkInvalid
+	}
+	return f.magicLinkResult, nil
+}
+
+// fakeMailer hands sent messages to the test, since handlers send mail in the
+// background.
+type fakeMailer chan models.Email
+
+func (m fakeMailer) Send(email models.Email) error {
+	m <- email
+	return nil
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestLogoutAlwaysClearsCookie(t *testing.T) {
+	tests := []struct {
+		name       string
+		cookie     string
+		repoErr    error
+		wantStatus int
+	}{
+		{"valid token", "token", nil, http.StatusNoContent},
+		{"revoked token", "token", models.New("token_revoked", "token revoked"), http.StatusForbidden},
+		{"unknown token", "token", models.New("token_not_found", "token not found"), http.StatusNotFound},
+		{"no cookie", "", nil, http.StatusUnauthorized},
+	}
+
+	for _, path := range []string{"/logout", "/logout/all"} {
+		for _, tt := range tests {
+			t.Run(path+" "+tt.name, func(t *testing.T) {
+				h := NewAuthHandler(&fakeAuthRepo{logoutErr: tt.repoErr}, nil)
+				r := gin.New()
+				r.POST("/logout", h.Logout)
+				r.POST("/logout/all", h.LogoutAll)
+
+				req := httptest.NewRequest(http.MethodPost, path, nil)
+				if tt.cookie != "" {
+					req.AddCookie(&http.Cookie{Name: "refreshToken", Value: tt.cookie})
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+				}
+				cleared := false
+				for _, header := range w.Header().Values("Set-Cookie") {
+					if strings.HasPrefix(header, "refreshToken=;") && strings.Contains(header, "Max-Age=0") {
+						cleared = true
+					}
+				}
+				if !cleared {
+					t.Errorf("refreshToken cookie not expired: %q", w.Header().Values("Set-Cookie"))
+				}
+			})
+		}
+	}
+}
+
+func TestChangePasswordStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		repoErr    error
+		wantStatus int
+	}{
+		{"changed", `{"currentPassword":"old","newPassword":"new-password"}`, nil, http.StatusNoContent},
+		{"wrong current password", `{"currentPassword":"guess","newPassword":"new-password"}`, models.ErrWrongPassword, http.StatusBadRequest},
+		{"weak new password", `{"currentPassword":"old","newPassword":"short"}`, models.ErrWeakPassword, http.StatusBadRequest},
+		{"missing field", `{"newPassword":"new-password"}`, nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAuthHandler(&fakeAuthRepo{changePasswordErr: tt.repoErr}, nil)
+			r := gin.New()
+			r.POST("/me/password", h.ChangePassword)
+
+			req := httptest.NewRequest(http.MethodPost, "/me/password", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPasskeyChangesPassProof(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+	}{
+		{http.MethodPost, "/me/webauthn/register/finish", `{"name":"Laptop","credential":{},"password":"password"}`, http.StatusCreated},
+		{http.MethodDelete, "/me/webauthn/credentials/" + uuid.NewString(), `{"password":"password"}`, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		repo := &fakeAuthRepo{}
+		h := NewAuthHandler(repo, nil)
+		r := gin.New()
+		r.POST("/me/webauthn/register/finish", h.FinishPasskeyRegistration)
+		r.DELETE("/me/webauthn/credentials/:id", h.DeletePasskey)
+
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.wantStatus || repo.passkeyProof.Password != "password" {
+			t.Errorf("%s %s: status %d, proof %+v", tt.method, tt.path, w.Code, repo.passkeyProof)
+		}
+	}
+}
+
+func TestUnlockAccountAddress(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantIP     string
+	}{
+		{"", http.StatusNoContent, ""},
+		{"?ip=203.0.113.7", http.StatusNoContent, "203.0.113.7"},
+		{"?ip=2001:DB8:0:0::1", http.StatusNoContent, "2001:db8::1"},
+		{"?ip=not-an-ip", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			repo := &fakeAuthRepo{}
+			r := gin.New()
+			r.POST("/admin/users/:id/unlock", NewAuthHandler(repo, nil).UnlockAccount)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/users/"+uuid.NewString()+"/unlock"+tt.query, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if repo.unlockedIP != tt.wantIP {
+				t.Errorf("unlocked address %q, want %q", repo.unlockedIP, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestRequestMagicLinkBindsBrowser(t *testing.T) {
+	for _, known := range []bool{true, false} {
+		repo := &fakeAuthRepo{}
+		if known {
+			repo.magicLink = &models.MagicLink{
+				User:      &models.User{Name: "User", Email: "user@example.com"},
+				Token:     "link-token",
+				ExpiresAt: time.Now().Add(10 * time.Minute),
+			}
+		}
+		mailer := make(fakeMailer, 1)
+		r := gin.New()
+		r.POST("/login/magic", NewAuthHandler(repo, mailer).RequestMagicLink)
+
+		req := httptest.NewRequest(http.MethodPost, "/login/magic", strings.NewReader(`{"email":"user@example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		// The response is the same whether or not the account exists.
+		if w.Code != http.StatusAccepted {
+			t.Errorf("known=%v: status = %d, want %d", known, w.Code, http.StatusAccepted)
+		}
+		cookie := responseCookie(w, magicLinkNonceCookie)
+		if cookie == nil || cookie.Value == "" || cookie.Value != repo.magicLinkNonce || !cookie.HttpOnly || cookie.Path != "/login/magic" {
+			t.Fatalf("known=%v: nonce cookie = %+v, want the nonce the link was bound to", known, cookie)
+		}
+
+		if known {
+			select {
+			case email := <-mailer:
+				if email.To != "user@example.com" || !strings.Contains(email.Body, "link-token") || strings.Contains(email.Body, cookie.Value) {
+					t.Errorf("email = %+v, want the link but not the browser nonce", email)
+				}
+			case <-time.After(time.Second):
+				t.Error("no email sent")
+			}
+		}
+	}
+}
+
+func TestVerifyMagicLinkUsesNonceCookie(t *testing.T) {
+	session := &models.AuthenticationResult{
+		User:         &models.User{Email: "user@example.com"},
+		AccessToken:  "access-token",
+		RefreshToken: &models.RefreshToken{Token: "refresh-token", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+
+	tests := []struct {
+		name        string
+		nonce       string
+		result      *models.AuthenticationResult
+		wantStatus  int
+		wantRefresh bool
+	}{
+		{"requesting browser", "browser-nonce", session, http.StatusOK, true},
+		{"other browser", "", nil, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeAuthRepo{magicLinkResult: tt.result}
+			r := gin.New()
+			r.POST("/login/magic/verify", NewAuthHandler(repo, nil).VerifyMagicLink)
+
+			req := httptest.NewRequest(http.MethodPost, "/login/magic/verify", strings.NewReader(`{"token":"link-token"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.nonce != "" {
+				req.AddCookie(&http.Cookie{Name: magicLinkNonceCookie, Value: tt.nonce})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if repo.magicLinkNonce != tt.nonce {
+				t.Errorf("verified with nonce %q, want the cookie value %q", repo.magicLinkNonce, tt.nonce)
+			}
+			if refresh := responseCookie(w, "refreshToken"); (refresh != nil && refresh.Value == "refresh-token") != tt.wantRefresh {
+				t.Errorf("refresh cookie = %+v", refresh)
+			}
+			if tt.wantRefresh {
+				if nonce := responseCookie(w, magicLinkNonceCookie); nonce == nil || nonce.MaxAge >= 0 {
+					t.Errorf("nonce cookie = %+v, want it cleared after use", nonce)
+				}
+			}
+		})
+	}
+}
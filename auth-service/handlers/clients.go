@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/repositories"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type ClientHandler struct {
+	clientRepo repositories.ClientRepositoryInterface
+}
+
+func NewClientHandler(clientRepo repositories.ClientRepositoryInterface) *ClientHandler {
+	return &ClientHandler{clientRepo: clientRepo}
+}
+
+func (h *ClientHandler) ListClients(c *gin.Context) {
+	clients, err := h.clientRepo.ListClients()
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, clients)
+}
+
+func (h *ClientHandler) GetClient(c *gin.Context) {
+	client, err := h.clientRepo.GetClient(c.Param("id"))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, client)
+}
+
+func (h *ClientHandler) CreateClient(c *gin.Context) {
+	var input struct {
+		Name         string   `json:"name" binding:"required"`
+		Public       bool     `json:"public"`
+		FirstParty   bool     `json:"firstParty"`
+		RedirectURIs []string `json:"redirectUris"`
+		GrantTypes   []string `json:"grantTypes"`
+		Scopes       []string `json:"scopes"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if len(input.GrantTypes) == 0 {
+		input.GrantTypes = []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+	}
+	if len(input.Scopes) == 0 {
+		input.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	client, err := h.clientRepo.CreateClient(input.Name, !input.Public, input.FirstParty, input.RedirectURIs, input.GrantTypes, input.Scopes)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, client)
+}
+
+func (h *ClientHandler) DeleteClient(c *gin.Context) {
+	if err := h.clientRepo.DeleteClient(c.Param("id")); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"auth-service/pkg/jwtauth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// currentUserID returns the id of the caller authenticated by
+// jwtauth.Middleware. Routes using it run behind jwtauth.RequireUser, so the
+// token always carries a user id.
+func currentUserID(c *gin.Context) uuid.UUID {
+	userID, _ := uuid.Parse(jwtauth.UserID(c))
+	return userID
+}
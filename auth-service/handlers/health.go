@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+var errShuttingDown = errors.New("shutting down")
+
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	checks []HealthCheck
+}
+
+func NewHealthHandler(checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{checks: checks}
+}
+
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, models.HealthReport{Status: models.HealthStatusUp})
+}
+
+func (h *HealthHandler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	report := models.HealthReport{
+		Status: models.HealthStatusUp,
+		Checks: make(map[string]models.ComponentHealth, len(h.checks)),
+	}
+
+	for _, check := range h.checks {
+		if err := check.Check(ctx); err != nil {
+			report.Status = models.HealthStatusDown
+			report.Checks[check.Name] = models.ComponentHealth{Status: models.HealthStatusDown, Error: err.Error()}
+			continue
+		}
+		report.Checks[check.Name] = models.ComponentHealth{Status: models.HealthStatusUp}
+	}
+
+	status := http.StatusOK
+	if report.Status != models.HealthStatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// ShutdownCheck fails once ctx is done, so readiness turns down while the
+// server drains and load balancers stop routing new requests to it.
+func ShutdownCheck(ctx context.Context) HealthCheck {
+	return HealthCheck{
+		Name: "shutdown",
+		Check: func(context.Context) error {
+			if ctx.Err() != nil {
+				return errShuttingDown
+			}
+			return nil
+		},
+	}
+}
+
+func DatabaseCheck(db *gorm.DB) HealthCheck {
+	return HealthCheck{
+		Name: "database",
+		Check: func(ctx context.Context) error {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.PingContext(ctx)
+		},
+	}
+}
+
+func MigrationsCheck() HealthCheck {
+	return HealthCheck{
+		Name: "migrations",
+		Check: func(ctx context.Context) error {
+			if !utils.MigrationsApplied() {
+				return errors.New("migrations not applied")
+			}
+			return nil
+		},
+	}
+}
+
+func SigningKeyCheck(keySet *utils.KeySet) HealthCheck {
+	return HealthCheck{
+		Name: "signing_key",
+		Check: func(ctx context.Context) error {
+			if !keySet.Loaded() {
+				return utils.ErrNoSigningKey
+			}
+			return nil
+		},
+	}
+}
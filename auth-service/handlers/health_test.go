@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveHealth(t *testing.T, h *HealthHandler, path string) (int, models.HealthReport) {
+	t.Helper()
+	r := gin.New()
+	r.GET("/health/live", h.Live)
+	r.GET("/health/ready", h.Ready)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var report models.HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, report
+}
+
+func staticCheck(name string, err error) HealthCheck {
+	return HealthCheck{Name: name, Check: func(context.Context) error { return err }}
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []HealthCheck
+		wantStatus int
+		wantDown   []string
+	}{
+		{"no checks", nil, http.StatusOK, nil},
+		{"all up", []HealthCheck{staticCheck("a", nil), staticCheck("b", nil)}, http.StatusOK, nil},
+		{"one down", []HealthCheck{staticCheck("a", nil), staticCheck("b", errors.New("boom"))}, http.StatusServiceUnavailable, []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, report := serveHealth(t, NewHealthHandler(tt.checks...), "/health/ready")
+			if code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", code, tt.wantStatus)
+			}
+			for _, name := range tt.wantDown {
+				if report.Checks[name].Status != models.HealthStatusDown || report.Checks[name].Error == "" {
+					t.Errorf("check %s = %+v, want down with an error", name, report.Checks[name])
+				}
+			}
+			if len(tt.wantDown) > 0 && report.Status != models.HealthStatusDown {
+				t.Errorf("report status = %s, want down", report.Status)
+			}
+		})
+	}
+}
+
+func TestReadyBoundsSlowChecks(t *testing.T) {
+	slow := HealthCheck{Name: "slow", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	start := time.Now()
+	code, _ := serveHealth(t, NewHealthHandler(slow), "/health/ready")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if elapsed := time.Since(start); elapsed > readinessTimeout+time.Second {
+		t.Errorf("readiness took %s", elapsed)
+	}
+}
+
+func TestLiveIgnoresChecks(t *testing.T) {
+	code, report := serveHealth(t, NewHealthHandler(staticCheck("db", errors.New("down"))), "/health/live")
+	if code != http.StatusOK || report.Status != models.HealthStatusUp {
+		t.Errorf("live = %d %+v, want 200 up", code, report)
+	}
+}
+
+func TestShutdownCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewHealthHandler(ShutdownCheck(ctx))
+
+	if code, _ := serveHealth(t, h, "/health/ready"); code != http.StatusOK {
+		t.Errorf("status before shutdown = %d, want %d", code, http.StatusOK)
+	}
+
+	cancel()
+	code, report := serveHealth(t, h, "/health/ready")
+	if code != http.StatusServiceUnavailable || report.Checks["shutdown"].Error != errShuttingDown.Error() {
+		t.Errorf("during shutdown: status %d, report %+v", code, report)
+	}
+	if code, _ := serveHealth(t, h, "/health/live"); code != http.StatusOK {
+		t.Errorf("liveness during shutdown = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestDatabaseCheck(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectPing()
+	if err := DatabaseCheck(db).Check(context.Background()); err != nil {
+		t.Errorf("healthy database: %v", err)
+	}
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	if err := DatabaseCheck(db).Check(context.Background()); err == nil {
+		t.Error("failed ping reported healthy")
+	}
+}
+
+func TestSigningKeyCheck(t *testing.T) {
+	if err := SigningKeyCheck(&utils.KeySet{}).Check(context.Background()); !errors.Is(err, utils.ErrNoSigningKey) {
+		t.Errorf("empty keyset err = %v, want %v", err, utils.ErrNoSigningKey)
+	}
+}
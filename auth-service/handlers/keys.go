@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"auth-service/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type KeysHandler struct {
+	keySet *utils.KeySet
+}
+
+func NewKeysHandler(keySet *utils.KeySet) *KeysHandler {
+	return &KeysHandler{keySet: keySet}
+}
+
+func (h *KeysHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(utils.JWKSMaxAge.Seconds())))
+	c.JSON(http.StatusOK, h.keySet.JWKS())
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/pkg/oidc"
+	"auth-service/repositories"
+	"auth-service/utils"
+	"context"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const oauthStateCookie = "oauthState"
+
+// OAuthHandler signs users in through external identity providers. Both
+// endpoints are browser redirects; the outcome is passed back to the
+// storefront, which then calls /refresh for an access token.
+type OAuthHandler struct {
+	authRepo  repositories.AuthRepositoryInterface
+	providers map[string]*oidc.Provider
+}
+
+func NewOAuthHandler(authRepo repositories.AuthRepositoryInterface, providers map[string]*oidc.Provider) *OAuthHandler {
+	return &OAuthHandler{authRepo: authRepo, providers: providers}
+}
+
+func (h *OAuthHandler) Start(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ErrProviderNotFound)
+		return
+	}
+
+	state, errState := oidc.NewCodeVerifier()
+	nonce, errNonce := oidc.NewCodeVerifier()
+	verifier, errVerifier := oidc.NewCodeVerifier()
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrInternalServer)
+		return
+	}
+
+	if err := h.authRepo.SaveOAuthState(&models.OAuthState{
+		State:        state,
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    utils.TokenExpiryTime(config.Current().Tokens.OAuthState),
+	}); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), oidc.DefaultTimeout)
+	defer cancel()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		log.Printf("identity provider %s unavailable: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, models.ErrProviderFailed)
+		return
+	}
+
+	// The state cookie ties the callback to this browser, so a victim cannot
+	// be signed in to an attacker's account with a forged callback URL.
+	setStateCookie(c, state, int(config.Current().Tokens.OAuthState.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (h *OAuthHandler) Callback(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ErrProviderNotFound)
+		return
+	}
+
+	cookieState, _ := c.Cookie(oauthStateCookie)
+	setStateCookie(c, "", -1)
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		redirectWithError(c, models.ErrProviderFailed)
+		return
+	}
+
+	state := c.Query("state")
+	if state == "" || state != cookieState {
+		redirectWithError(c, models.ErrOAuthStateInvalid)
+		return
+	}
+
+	pending, err := h.authRepo.ConsumeOAuthState(state, provider.Name)
+	if err != nil {
+		redirectWithError(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), oidc.DefaultTimeout)
+	defer cancel()
+
+	identity, err := provider.Authenticate(ctx, c.Query("code"), pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		log.Printf("sign-in with %s failed: %v", provider.Name, err)
+		redirectWithError(c, models.ErrProviderFailed)
+		return
+	}
+
+	result, err := h.authRepo.LoginWithIdentity(&models.ExternalIdentity{
+		Provider:      provider.Name,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Name:          identity.Name,
+	}, clientInfo(c))
+	if err != nil {
+		redirectWithError(c, err)
+		return
+	}
+
+	if result.MFAChallenge != nil {
+		query := url.Values{}
+		query.Set("mfaToken", result.MFAChallenge.Token)
+		query.Set("methods", strings.Join(result.MFAChallenge.Methods, ","))
+		c.Redirect(http.StatusFound, utils.FrontendURL("/login/mfa?"+query.Encode()))
+		return
+	}
+
+	setRefreshTokenCookie(c, result.RefreshToken)
+	c.Redirect(http.StatusFound, utils.FrontendURL("/login/callback"))
+}
+
+func (h *OAuthHandler) ListIdentities(c *gin.Context) {
+	identities, err := h.authRepo.ListIdentities(currentUserID(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+func redirectWithError(c *gin.Context, err error) {
+	code := models.ErrInternalServer.Code
+	if appErr, ok := err.(*models.AppError); ok {
+		code = appErr.Code
+	}
+	c.Redirect(http.StatusFound, utils.FrontendURL("/login?error="+url.QueryEscape(code)))
+}
+
+// setStateCookie relaxes SameSite=Strict to Lax: a strict cookie would not be
+// sent on the redirect back from the provider.
+func setStateCookie(c *gin.Context, value string, maxAge int) {
+	cookie := config.Current().Cookie
+	if cookie.SameSite == "strict" {
+		cookie.SameSite = "lax"
+	}
+	writeCookie(c, cookie, oauthStateCookie, value, maxAge, "/login/oauth")
+}
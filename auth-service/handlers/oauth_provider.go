@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/pkg/jwtauth"
+	"auth-service/repositories"
+	"auth-service/utils"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// AuthorizationHandler makes the service an OAuth 2.0 authorization server
+// and OpenID Connect provider for registered clients. Users sign in through
+// the storefront as usual; /authorize recognises them by their refresh
+// cookie. Users approve the scopes of partner clients once on the
+// storefront's consent page; first-party clients skip it. Clients get
+// delegated tokens limited to the granted scopes, never the user's
+// first-party session.
+type AuthorizationHandler struct {
+	authRepo   repositories.AuthRepositoryInterface
+	clientRepo repositories.ClientRepositoryInterface
+}
+
+func NewAuthorizationHandler(authRepo repositories.AuthRepositoryInterface, clientRepo repositories.ClientRepositoryInterface) *AuthorizationHandler {
+	return &AuthorizationHandler{authRepo: authRepo, clientRepo: clientRepo}
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Discovery serves the OpenID Connect discovery document. Relying parties
+// compare the issuer with the URL they fetched this from, so JWT_ISSUER
+// should be set to PUBLIC_URL when the provider is used.
+func (h *AuthorizationHandler) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                utils.JWTIssuer(),
+		"authorization_endpoint":                utils.PublicURL("/authorize"),
+		"token_endpoint":                        utils.PublicURL("/token"),
+		"userinfo_endpoint":                     utils.PublicURL("/userinfo"),
+		"jwks_uri":                              utils.PublicURL("/.well-known/jwks.json"),
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{utils.SigningAlgorithm()},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"claims_supported":                      []string{"sub", "name", "email", "email_verified"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+// Authorize starts the authorization code flow. Errors about the client or
+// redirect URI are shown here rather than redirected, so an unregistered URI
+// never receives anything.
+func (h *AuthorizationHandler) Authorize(c *gin.Context) {
+	client, err := h.clientRepo.GetClient(c.Query("client_id"))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	redirectURI := c.Query("redirect_uri")
+	if !client.AllowsRedirect(redirectURI) {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	state := c.Query("state")
+	scopes := strings.Fields(c.Query("scope"))
+
+	switch {
+	case c.Query("response_type") != "code":
+		redirectAuthorizeError(c, redirectURI, state, "unsupported_response_type")
+		return
+	case !client.AllowsGrant(models.GrantAuthorizationCode):
+		redirectAuthorizeError(c, redirectURI, state, "unauthorized_client")
+		return
+	case c.Query("code_challenge") == "" || c.Query("code_challenge_method") != "S256":
+		redirectAuthorizeError(c, redirectURI, state, "invalid_request")
+		return
+	case len(scopes) == 0 || !client.AllowsScopes(scopes):
+		redirectAuthorizeError(c, redirectURI, state, "invalid_scope")
+		return
+	}
+
+	prompts := strings.Fields(c.Query("prompt"))
+	token, _ := c.Cookie("refreshToken")
+	user, err := h.authRepo.SessionUser(token)
+	if err != nil {
+		if slices.Contains(prompts, "none") {
+			redirectAuthorizeError(c, redirectURI, state, "login_required")
+			return
+		}
+		returnTo := utils.PublicURL(c.Request.URL.RequestURI())
+		c.Redirect(http.StatusFound, utils.FrontendURL("/login?return_to="+url.QueryEscape(returnTo)))
+		return
+	}
+
+	if !client.FirstParty {
+		consent, err := h.clientRepo.GetConsent(user.ID, client.ID)
+		if err != nil && err != models.ErrConsentNotFound {
+			redirectAuthorizeError(c, redirectURI, state, "server_error")
+			return
+		}
+		if err != nil || !consent.Covers(scopes) || slices.Contains(prompts, "consent") {
+			if slices.Contains(prompts, "none") {
+				redirectAuthorizeError(c, redirectURI, state, "consent_required")
+				return
+			}
+			askConsent(c, client, scopes, redirectURI, state)
+			return
+		}
+	}
+
+	code, err := h.clientRepo.CreateAuthorizationCode(&models.AuthorizationCode{
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectURI:   redirectURI,
+		Scope:         strings.Join(scopes, " "),
+		Nonce:         c.Query("nonce"),
+		CodeChallenge: c.Query("code_challenge"),
+		ExpiresAt:     utils.TokenExpiryTime(config.Current().Tokens.AuthorizationCode),
+	})
+	if err != nil {
+		redirectAuthorizeError(c, redirectURI, state, "server_error")
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if state != "" {
+		params.Set("state", state)
+	}
+	c.Redirect(http.StatusFound, withQuery(redirectURI, params))
+}
+
+// askConsent sends the user to the storefront's consent page. After granting
+// the scopes through PUT /me/consents/:client the page continues to
+// return_to, which repeats this request without prompt=consent; if the user
+// declines it goes to deny_to, which tells the client so.
+func askConsent(c *gin.Context, client *models.Client, scopes []string, redirectURI, state string) {
+	query := c.Request.URL.Query()
+	query.Del("prompt")
+
+	params := url.Values{}
+	params.Set("client_id", client.ID)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("return_to", utils.PublicURL("/authorize?"+query.Encode()))
+	params.Set("deny_to", authorizeErrorURL(redirectURI, state, "access_denied"))
+	c.Redirect(http.StatusFound, utils.FrontendURL("/consent?"+params.Encode()))
+}
+
+// GetConsent tells the consent page which client is asking and what the user
+// already approved for it.
+func (h *AuthorizationHandler) GetConsent(c *gin.Context) {
+	client, err := h.clientRepo.GetClient(c.Param("client"))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	consent, err := h.clientRepo.GetConsent(currentUserID(c), client.ID)
+	if err == models.ErrConsentNotFound {
+		consent, err = &models.Consent{ClientID: client.ID, Scopes: models.StringList{}}, nil
+	}
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	consent.ClientName = client.Name
+	c.JSON(http.StatusOK, consent)
+}
+
+// GrantConsent records the user's approval of scopes for a client. It only
+// accepts first-party access tokens, so a client cannot approve itself.
+func (h *AuthorizationHandler) GrantConsent(c *gin.Context) {
+	var input struct {
+		Scope string `json:"scope" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	client, err := h.clientRepo.GetClient(c.Param("client"))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	scopes := strings.Fields(input.Scope)
+	if len(scopes) == 0 || !client.AllowsScopes(scopes) {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	consent, err := h.clientRepo.GrantConsent(currentUserID(c), client.ID, scopes)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	consent.ClientName = client.Name
+	c.JSON(http.StatusOK, consent)
+}
+
+func (h *AuthorizationHandler) RevokeConsent(c *gin.Context) {
+	if err := h.clientRepo.RevokeConsent(currentUserID(c), c.Param("client")); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthorizationHandler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	clientID, secret, basic := clientCredentials(c)
+	client, err := h.clientRepo.AuthenticateClient(clientID, secret)
+	if err != nil {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeTokenError(c, err)
+		return
+	}
+
+	grant := c.PostForm("grant_type")
+	if !client.AllowsGrant(grant) {
+		writeOAuthError(c, http.StatusBadRequest, "unauthorized_client", "grant type not allowed for this client")
+		return
+	}
+
+	info := clientInfo(c)
+	info.ClientID = client.ID
+
+	switch grant {
+	case models.GrantAuthorizationCode:
+		h.exchangeAuthorizationCode(c, client, info)
+	case models.GrantRefreshToken:
+		result, err := h.authRepo.RefreshToken(c.PostForm("refresh_token"), info)
+		if err != nil {
+			writeTokenError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, userTokenResponse(client, result))
+	case models.GrantClientCredentials:
+		if !client.Confidential() {
+			writeOAuthError(c, http.StatusBadRequest, "unauthorized_client", "public clients cannot use client credentials")
+			return
+		}
+
+		scopes := strings.Fields(c.PostForm("scope"))
+		if len(scopes) == 0 {
+			scopes = client.Scopes
+		} else if !client.AllowsScopes(scopes) {
+			writeOAuthError(c, http.StatusBadRequest, "invalid_scope", "scope not allowed for this client")
+			return
+		}
+
+		accessToken, _, err := utils.GenerateClientAccessToken(client.ID, scopes)
+		if err != nil {
+			writeOAuthError(c, http.StatusInternalServerError, "server_error", "failed to generate access token")
+			return
+		}
+		c.JSON(http.StatusOK, tokenResponse{
+			AccessToken: accessToken,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(config.Current().Tokens.Access.Seconds()),
+			Scope:       strings.Join(scopes, " "),
+		})
+	default:
+		writeOAuthError(c, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant type")
+	}
+}
+
+func (h *AuthorizationHandler) exchangeAuthorizationCode(c *gin.Context, client *models.Client, info models.ClientInfo) {
+	code, err := h.clientRepo.ConsumeAuthorizationCode(c.PostForm("code"))
+	if err != nil {
+		writeTokenError(c, err)
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != c.PostForm("redirect_uri") ||
+		!verifyCodeChallenge(c.PostForm("code_verifier"), code.CodeChallenge) {
+		writeTokenError(c, models.ErrInvalidGrant)
+		return
+	}
+
+	result, err := h.authRepo.IssueClientTokens(code.UserID, code.Scope, info)
+	if err != nil {
+		writeTokenError(c, err)
+		return
+	}
+
+	response := userTokenResponse(client, result)
+	if scopes := strings.Fields(code.Scope); slices.Contains(scopes, "openid") {
+		idToken, err := utils.GenerateIDToken(result.User, client.ID, code.Nonce, scopes)
+		if err != nil {
+			writeOAuthError(c, http.StatusInternalServerError, "server_error", "failed to generate ID token")
+			return
+		}
+		response.IDToken = idToken
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UserInfo returns the claims of the user behind an access token. A token
+// issued to an OAuth client needs the openid scope and only sees the claims
+// its scopes cover, as in the ID token.
+func (h *AuthorizationHandler) UserInfo(c *gin.Context) {
+	claims := jwtauth.ClaimsFrom(c)
+	delegated := claims.ClientID != ""
+	if delegated && !claims.HasScope("openid") {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		writeOAuthError(c, http.StatusForbidden, "insufficient_scope", "the openid scope is required")
+		return
+	}
+
+	user, err := h.authRepo.GetUser(currentUserID(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	info := gin.H{"sub": user.ID.String()}
+	if !delegated || claims.HasScope("profile") {
+		info["name"] = user.Name
+	}
+	if !delegated || claims.HasScope("email") {
+		info["email"] = user.Email
+		info["email_verified"] = user.EmailVerifiedAt != nil
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func userTokenResponse(client *models.Client, result *models.AuthenticationResult) tokenResponse {
+	response := tokenResponse{
+		AccessToken: result.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(config.Current().Tokens.Access.Seconds()),
+		Scope:       result.RefreshToken.Scope,
+	}
+	// The session is stored either way; clients without the refresh grant
+	// just never see the token.
+	if client.AllowsGrant(models.GrantRefreshToken) {
+		response.RefreshToken = result.RefreshToken.Token
+	}
+	return response
+}
+
+// clientCredentials reads client authentication from the Basic header
+// (client_secret_basic) or the form (client_secret_post, or none for public
+// clients).
+func clientCredentials(c *gin.Context) (string, string, bool) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		id, errID := url.QueryUnescape(id)
+		secret, errSecret := url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			return "", "", true
+		}
+		return id, secret, true
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret"), false
+}
+
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if verifier == "" || challenge == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// writeTokenError maps repository errors onto the RFC 6749 error responses
+// of the token endpoint.
+func writeTokenError(c *gin.Context, err error) {
+	code := models.ErrInternalServer.Code
+	if appErr, ok := err.(*models.AppError); ok {
+		code = appErr.Code
+	}
+
+	switch code {
+	case models.ErrInvalidClient.Code:
+		writeOAuthError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	case models.ErrInvalidGrant.Code, "invalid_input", "token_not_found", "token_expired", "token_revoked",
+		"token_reuse_detected", "user_not_found":
+		writeOAuthError(c, http.StatusBadRequest, "invalid_grant", "grant is invalid, expired or revoked")
+	default:
+		writeOAuthError(c, http.StatusInternalServerError, "server_error", "internal server error")
+	}
+}
+
+func writeOAuthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+func redirectAuthorizeError(c *gin.Context, redirectURI, state, code string) {
+	c.Redirect(http.StatusFound, authorizeErrorURL(redirectURI, state, code))
+}
+
+func authorizeErrorURL(redirectURI, state, code string) string {
+	params := url.Values{}
+	params.Set("error", code)
+	if state != "" {
+		params.Set("state", state)
+	}
+	return withQuery(redirectURI, params)
+}
+
+// withQuery appends params to a registered redirect URI, keeping any query
+// it already has.
+func withQuery(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
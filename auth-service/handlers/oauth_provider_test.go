@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/pkg/jwtauth"
+	"auth-service/repositories"
+	"auth-service/utils"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClientRepo knows one public client, one pending authorization code and
+// the user's consent to that client.
+type fakeClientRepo struct {
+	repositories.ClientRepositoryInterface
+	client  *models.Client
+	code    *models.AuthorizationCode
+	consent *models.Consent
+	created *models.AuthorizationCode
+}
+
+func (f *fakeClientRepo) GetClient(clientID string) (*models.Client, error) {
+	if clientID != f.client.ID {
+		return nil, models.ErrClientNotFound
+	}
+	return f.client, nil
+}
+
+func (f *fakeClientRepo) GetConsent(uuid.UUID, string) (*models.Consent, error) {
+	if f.consent == nil {
+		return nil, models.ErrConsentNotFound
+	}
+	return f.consent, nil
+}
+
+func (f *fakeClientRepo) GrantConsent(_ uuid.UUID, clientID string, scopes []string) (*models.Consent, error) {
+	f.consent = &models.Consent{ClientID: clientID, Scopes: scopes}
+	return f.consent, nil
+}
+
+func (f *fakeClientRepo) CreateAuthorizationCode(code *models.AuthorizationCode) (string, error) {
+	f.created = code
+	return "issued-code", nil
+}
+
+func (f *fakeClientRepo) AuthenticateClient(clientID, _ string) (*models.Client, error) {
+	if clientID != f.client.ID {
+		return nil, models.ErrInvalidClient
+	}
+	return f.client, nil
+}
+
+func (f *fakeClientRepo) ConsumeAuthorizationCode(string) (*models.AuthorizationCode, error) {
+	if f.code == nil {
+		return nil, models.ErrInvalidGrant
+	}
+	code := f.code
+	f.code = nil
+	return code, nil
+}
+
+// fakeTokenRepo records how client sessions are issued and refreshed.
+type fakeTokenRepo struct {
+	repositories.AuthRepositoryInterface
+	issuedScope  string
+	issuedClient string
+	refreshedBy  string
+}
+
+func (f *fakeTokenRepo) IssueClientTokens(userID uuid.UUID, scope string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	f.issuedScope, f.issuedClient = scope, client.ClientID
+	return &models.AuthenticationResult{
+		User:         &models.User{ID: userID, Email: "user@example.com"},
+		AccessToken:  "delegated-token",
+		RefreshToken: &models.RefreshToken{Token: "client-refresh", Scope: scope, ClientID: client.ClientID, ExpiresAt: time.Now().Add(time.Hour)},
+	}, nil
+}
+
+func (f *fakeTokenRepo) RefreshToken(_ string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	f.refreshedBy = client.ClientID
+	return &models.AuthenticationResult{
+		AccessToken:  "refreshed-token",
+		RefreshToken: &models.RefreshToken{Token: "rotated", Scope: "openid", ExpiresAt: time.Now().Add(time.Hour)},
+	}, nil
+}
+
+func (f *fakeTokenRepo) SessionUser(token string) (*models.User, error) {
+	if token == "" {
+		return nil, models.ErrTokenNotFound
+	}
+	return &models.User{ID: uuid.New(), Email: "user@example.com"}, nil
+}
+
+func (f *fakeTokenRepo) GetUser(userID uuid.UUID) (*models.User, error) {
+	return &models.User{ID: userID, Name: "User", Email: "user@example.com"}, nil
+}
+
+// useKeySet loads utils.DefaultKeySet with a fresh active key, for tests that
+// go as far as signing ID tokens.
+func useKeySet(t *testing.T) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.DataEncryptionKey = "test-data-encryption-key-0123456789"
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(nil) })
+
+	key, err := utils.GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.ActivatesAt = time.Now().Add(-time.Minute)
+
+	previous := utils.DefaultKeySet
+	utils.DefaultKeySet = &utils.KeySet{}
+	if err := utils.DefaultKeySet.Load([]models.SigningKey{*key}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { utils.DefaultKeySet = previous })
+}
+
+func postToken(h *AuthorizationHandler, form url.Values) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/token", h.Token)
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenAuthorizationCodeIssuesClientTokens(t *testing.T) {
+	useKeySet(t)
+	challenge := sha256.Sum256([]byte("verifier"))
+	client := &models.Client{
+		ID:           "partner",
+		RedirectURIs: models.StringList{"https://partner.example.com/callback"},
+		GrantTypes:   models.StringList{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		Scopes:       models.StringList{"openid", "email"},
+	}
+	clients := &fakeClientRepo{client: client, code: &models.AuthorizationCode{
+		ClientID:      "partner",
+		UserID:        uuid.New(),
+		RedirectURI:   "https://partner.example.com/callback",
+		Scope:         "openid email",
+		Nonce:         "nonce",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(challenge[:]),
+	}}
+	tokens := &fakeTokenRepo{}
+
+	w := postToken(NewAuthorizationHandler(tokens, clients), url.Values{
+		"grant_type":    {models.GrantAuthorizationCode},
+		"client_id":     {"partner"},
+		"code":          {"code"},
+		"redirect_uri":  {"https://partner.example.com/callback"},
+		"code_verifier": {"verifier"},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if tokens.issuedScope != "openid email" || tokens.issuedClient != "partner" {
+		t.Errorf("issued scope %q to %q, want the consented scope for the client", tokens.issuedScope, tokens.issuedClient)
+	}
+	var response tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.AccessToken != "delegated-token" || response.Scope != "openid email" || response.RefreshToken != "client-refresh" || response.IDToken == "" {
+		t.Errorf("response = %+v", response)
+	}
+}
+
+func TestTokenRefreshGrantNamesClient(t *testing.T) {
+	client := &models.Client{ID: "partner", GrantTypes: models.StringList{models.GrantRefreshToken}}
+	tokens := &fakeTokenRepo{}
+
+	w := postToken(NewAuthorizationHandler(tokens, &fakeClientRepo{client: client}), url.Values{
+		"grant_type":    {models.GrantRefreshToken},
+		"client_id":     {"partner"},
+		"refresh_token": {"client-refresh"},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	// The repository only rotates a token issued to the client named here.
+	if tokens.refreshedBy != "partner" {
+		t.Errorf("refreshed on behalf of %q, want the authenticated client", tokens.refreshedBy)
+	}
+	if !strings.Contains(w.Body.String(), `"scope":"openid"`) {
+		t.Errorf("response %s does not report the stored scope", w.Body)
+	}
+}
+
+func TestUserInfoForClientTokens(t *testing.T) {
+	userID := uuid.New()
+	tests := []struct {
+		name       string
+		claims     *jwtauth.Claims
+		wantStatus int
+		wantKeys   []string
+	}{
+		{"first-party token", &jwtauth.Claims{UserID: userID.String()}, http.StatusOK, []string{"sub", "name", "email", "email_verified"}},
+		{"openid only", &jwtauth.Claims{UserID: userID.String(), ClientID: "partner", Scope: "openid"}, http.StatusOK, []string{"sub"}},
+		{"openid email", &jwtauth.Claims{UserID: userID.String(), ClientID: "partner", Scope: "openid email"}, http.StatusOK, []string{"sub", "email", "email_verified"}},
+		{"openid profile", &jwtauth.Claims{UserID: userID.String(), ClientID: "partner", Scope: "openid profile"}, http.StatusOK, []string{"sub", "name"}},
+		{"without openid", &jwtauth.Claims{UserID: userID.String(), ClientID: "partner", Scope: "email"}, http.StatusForbidden, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/userinfo", func(c *gin.Context) {
+				c.Set(jwtauth.ContextUserID, tt.claims.UserID)
+				c.Set(jwtauth.ContextClaims, tt.claims)
+			}, NewAuthorizationHandler(&fakeTokenRepo{}, nil).UserInfo)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/userinfo", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantKeys == nil {
+				return
+			}
+			var info map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+				t.Fatal(err)
+			}
+			if len(info) != len(tt.wantKeys) {
+				t.Errorf("claims = %v, want exactly %v", info, tt.wantKeys)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := info[key]; !ok {
+					t.Errorf("claims = %v, missing %q", info, key)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizeAsksConsent(t *testing.T) {
+	const redirectURI = "https://partner.example.com/callback"
+	tests := []struct {
+		name       string
+		firstParty bool
+		consent    []string
+		prompt     string
+		wantPath   string
+		wantError  string
+	}{
+		{"never approved", false, nil, "", "/consent", ""},
+		{"approved fewer scopes", false, []string{"openid"}, "", "/consent", ""},
+		{"approved", false, []string{"email", "openid"}, "", "/callback", ""},
+		{"prompt=consent", false, []string{"email", "openid"}, "consent", "/consent", ""},
+		{"prompt=none without consent", false, nil, "none", "/callback", "consent_required"},
+		{"first party", true, nil, "", "/callback", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.PublicURL, cfg.FrontendURL = "https://auth.example.com", "https://shop.example.com"
+			config.Set(cfg)
+			t.Cleanup(func() { config.Set(nil) })
+
+			clients := &fakeClientRepo{client: &models.Client{
+				ID:           "partner",
+				RedirectURIs: models.StringList{redirectURI},
+				GrantTypes:   models.StringList{models.GrantAuthorizationCode},
+				Scopes:       models.StringList{"openid", "email"},
+				FirstParty:   tt.firstParty,
+			}}
+			if tt.consent != nil {
+				clients.consent = &models.Consent{ClientID: "partner", Scopes: tt.consent}
+			}
+			r := gin.New()
+			r.GET("/authorize", NewAuthorizationHandler(&fakeTokenRepo{}, clients).Authorize)
+
+			query := url.Values{
+				"client_id":             {"partner"},
+				"redirect_uri":          {redirectURI},
+				"response_type":         {"code"},
+				"scope":                 {"openid email"},
+				"state":                 {"xyz"},
+				"code_challenge":        {"challenge"},
+				"code_challenge_method": {"S256"},
+			}
+			if tt.prompt != "" {
+				query.Set("prompt", tt.prompt)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)
+			req.AddCookie(&http.Cookie{Name: "refreshToken", Value: "session"})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			if w.Code != http.StatusFound || err != nil {
+				t.Fatalf("status %d, location %q", w.Code, w.Header().Get("Location"))
+			}
+			if location.Path != tt.wantPath || location.Query().Get("error") != tt.wantError {
+				t.Fatalf("redirected to %s, want %s with error %q", location, tt.wantPath, tt.wantError)
+			}
+
+			issued := clients.created != nil
+			if wantCode := tt.wantPath == "/callback" && tt.wantError == ""; issued != wantCode {
+				t.Errorf("code issued = %v, want %v", issued, wantCode)
+			}
+			if tt.wantPath != "/consent" {
+				return
+			}
+
+			// Approving continues the same request, without asking again.
+			returnTo, err := url.Parse(location.Query().Get("return_to"))
+			if err != nil || returnTo.Host != "auth.example.com" || returnTo.Query().Get("prompt") != "" ||
+				returnTo.Query().Get("code_challenge") != "challenge" {
+				t.Errorf("return_to = %q", location.Query().Get("return_to"))
+			}
+			if got := location.Query().Get("deny_to"); got != redirectURI+"?error=access_denied&state=xyz" {
+				t.Errorf("deny_to = %q", got)
+			}
+			if location.Query().Get("client_id") != "partner" || location.Query().Get("scope") != "openid email" {
+				t.Errorf("consent page asked for %v", location.Query())
+			}
+		})
+	}
+}
+
+func TestGrantConsent(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"allowed scopes", `{"scope":"openid email"}`, http.StatusOK},
+		{"scope the client may not request", `{"scope":"openid admin"}`, http.StatusBadRequest},
+		{"no scope", `{"scope":" "}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := &fakeClientRepo{client: &models.Client{ID: "partner", Name: "Partner", Scopes: models.StringList{"openid", "email"}}}
+			r := gin.New()
+			r.PUT("/me/consents/:client", NewAuthorizationHandler(&fakeTokenRepo{}, clients).GrantConsent)
+
+			req := httptest.NewRequest(http.MethodPut, "/me/consents/partner", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if granted := clients.consent != nil; granted != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("consent stored = %v", granted)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+type PermissionHandler struct {
+	permissionRepo repositories.PermissionRepositoryInterface
+}
+
+func NewPermissionHandler(permissionRepo repositories.PermissionRepositoryInterface) *PermissionHandler {
+	return &PermissionHandler{permissionRepo: permissionRepo}
+}
+
+func (h *PermissionHandler) ListPermissions(c *gin.Context) {
+	permissions, err := h.permissionRepo.ListPermissions()
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, permissions)
+}
+
+func (h *PermissionHandler) CreatePermission(c *gin.Context) {
+	var input struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	permission, err := h.permissionRepo.CreatePermission(input.Name, input.Description)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, permission)
+}
+
+func (h *PermissionHandler) DeletePermission(c *gin.Context) {
+	permissionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.permissionRepo.DeletePermission(permissionID); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *PermissionHandler) GetRolePermissions(c *gin.Context) {
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	permissions, err := h.permissionRepo.GetRolePermissions(roleID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, permissions)
+}
+
+func (h *PermissionHandler) AttachPermission(c *gin.Context) {
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	var input struct {
+		Permission string `json:"permission" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.permissionRepo.AttachPermission(roleID, input.Permission); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *PermissionHandler) DetachPermission(c *gin.Context) {
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.permissionRepo.DetachPermission(roleID, c.Param("permission")); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+type RoleHandler struct {
+	roleRepo repositories.RoleRepositoryInterface
+}
+
+func NewRoleHandler(roleRepo repositories.RoleRepositoryInterface) *RoleHandler {
+	return &RoleHandler{roleRepo: roleRepo}
+}
+
+func (h *RoleHandler) ListRoles(c *gin.Context) {
+	roles, err := h.roleRepo.ListRoles()
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+func (h *RoleHandler) GetRole(c *gin.Context) {
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	role, err := h.roleRepo.GetRole(roleID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var input struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	role, err := h.roleRepo.CreateRole(input.Name, input.Description)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	role, err := h.roleRepo.UpdateRole(roleID, input.Name, input.Description)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.roleRepo.DeleteRole(roleID); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *RoleHandler) GetUserRoles(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	roles, err := h.roleRepo.GetUserRoles(userID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+func (h *RoleHandler) GrantRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.roleRepo.GrantRole(userID, input.Role); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *RoleHandler) RevokeRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.roleRepo.RevokeRole(userID, c.Param("role")); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+type ServiceAccountHandler struct {
+	serviceAccountRepo repositories.ServiceAccountRepositoryInterface
+}
+
+func NewServiceAccountHandler(serviceAccountRepo repositories.ServiceAccountRepositoryInterface) *ServiceAccountHandler {
+	return &ServiceAccountHandler{serviceAccountRepo: serviceAccountRepo}
+}
+
+// ExchangeAPIKey is called by back-end services to turn their API key into a
+// short-lived access token for calls to other services.
+func (h *ServiceAccountHandler) ExchangeAPIKey(c *gin.Context) {
+	var input struct {
+		APIKey string   `json:"apiKey" binding:"required"`
+		Scopes []string `json:"scopes"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	token, err := h.serviceAccountRepo.ExchangeAPIKey(input.APIKey, input.Scopes, c.ClientIP())
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, token)
+}
+
+func (h *ServiceAccountHandler) ListServiceAccounts(c *gin.Context) {
+	accounts, err := h.serviceAccountRepo.ListServiceAccounts()
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+func (h *ServiceAccountHandler) GetServiceAccount(c *gin.Context) {
+	accountID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	account, err := h.serviceAccountRepo.GetServiceAccount(accountID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+func (h *ServiceAccountHandler) CreateServiceAccount(c *gin.Context) {
+	var input struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	account, err := h.serviceAccountRepo.CreateServiceAccount(input.Name, input.Description)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, account)
+}
+
+func (h *ServiceAccountHandler) DeleteServiceAccount(c *gin.Context) {
+	accountID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.serviceAccountRepo.DeleteServiceAccount(accountID); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ServiceAccountHandler) ListAPIKeys(c *gin.Context) {
+	accountID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	keys, err := h.serviceAccountRepo.ListAPIKeys(accountID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func (h *ServiceAccountHandler) CreateAPIKey(c *gin.Context) {
+	accountID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	var input struct {
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	key, err := h.serviceAccountRepo.CreateAPIKey(accountID, input.Scopes, input.ExpiresAt)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+func (h *ServiceAccountHandler) RotateAPIKey(c *gin.Context) {
+	accountID, errAccount := uuid.Parse(c.Param("id"))
+	keyID, errKey := uuid.Parse(c.Param("key"))
+	if errAccount != nil || errKey != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	key, err := h.serviceAccountRepo.RotateAPIKey(accountID, keyID)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+func (h *ServiceAccountHandler) RevokeAPIKey(c *gin.Context) {
+	accountID, errAccount := uuid.Parse(c.Param("id"))
+	keyID, errKey := uuid.Parse(c.Param("key"))
+	if errAccount != nil || errKey != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.serviceAccountRepo.RevokeAPIKey(accountID, keyID); err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/pkg/webauthn"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+func (h *AuthHandler) BeginPasskeyRegistration(c *gin.Context) {
+	options, err := h.authRepo.BeginPasskeyRegistration(currentUserID(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// FinishPasskeyRegistration stores a new passkey. Besides the credential the
+// body carries the password, a code or a passkey assertion from
+// BeginPasskeyReauthentication.
+func (h *AuthHandler) FinishPasskeyRegistration(c *gin.Context) {
+	var input struct {
+		models.Reauthentication
+		Name       string                         `json:"name"`
+		Credential *webauthn.RegistrationResponse `json:"credential" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	passkey, err := h.authRepo.FinishPasskeyRegistration(currentUserID(c), input.Name, input.Credential, input.Reauthentication, clientInfo(c))
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, passkey)
+}
+
+// BeginPasskeyReauthentication starts a passkey assertion that confirms a
+// change to the user's passkeys in place of the password.
+func (h *AuthHandler) BeginPasskeyReauthentication(c *gin.Context) {
+	options, err := h.authRepo.BeginPasskeyReauthentication(currentUserID(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// BeginPasskeyLogin starts a passkey sign-in. Passing the mfaToken from Login
+// uses the passkey as a second factor instead of the only one.
+func (h *AuthHandler) BeginPasskeyLogin(c *gin.Context) {
+	var input struct {
+		MFAToken string `json:"mfaToken"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	options, err := h.authRepo.BeginPasskeyLogin(input.MFAToken)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+func (h *AuthHandler) FinishPasskeyLogin(c *gin.Context) {
+	var input struct {
+		MFAToken   string                      `json:"mfaToken"`
+		Credential *webauthn.AssertionResponse `json:"credential" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	result, err := h.authRepo.FinishPasskeyLogin(input.MFAToken, input.Credential, clientInfo(c))
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	writeLoginResult(c, result)
+}
+
+func (h *AuthHandler) ListPasskeys(c *gin.Context) {
+	passkeys, err := h.authRepo.ListPasskeys(currentUserID(c))
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, passkeys)
+}
+
+func (h *AuthHandler) RenamePasskey(c *gin.Context) {
+	passkeyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	passkey, err := h.authRepo.RenamePasskey(currentUserID(c), passkeyID, input.Name)
+	if err != nil {
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, passkey)
+}
+
+func (h *AuthHandler) DeletePasskey(c *gin.Context) {
+	passkeyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	var proof models.Reauthentication
+	if err := c.ShouldBindJSON(&proof); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrInvalidInput)
+		return
+	}
+
+	if err := h.authRepo.DeletePasskey(currentUserID(c), passkeyID, proof, clientInfo(c)); err != nil {
+		setRetryAfter(c, err)
+		c.JSON(getStatusCode(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
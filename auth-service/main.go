@@ -1,35 +1,242 @@
 package main
 
 import (
+	"auth-service/config"
 	"auth-service/handlers"
+	"auth-service/models"
+	"auth-service/pkg/jwtauth"
+	"auth-service/pkg/ratelimit"
 	"auth-service/repositories"
 	"auth-service/utils"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
-	utils.InitEnv()
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "auth-service:", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the HTTP API until SIGINT or SIGTERM, then shuts down in order:
+// readiness fails and in-flight requests drain, background jobs stop, and
+// finally the database pool is closed.
+func serve() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	db, err := utils.NewGormDB()
 	if err != nil {
-		panic("failed to connect database")
+		return err
 	}
+	defer func() {
+		if err := utils.CloseGormDB(db); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}()
 
-	if err := utils.AutoMigrate(db); err != nil {
-		panic("failed to migrate database")
+	if err := utils.Migrate(ctx, db); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
 	}
 
+	keyRepo := repositories.NewKeyRepository(db)
+	signingAlg := utils.SigningAlgorithm()
+	if err := utils.DefaultKeySet.Refresh(keyRepo, signingAlg); err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	defer func() {
+		stopJobs()
+		jobs.Wait()
+	}()
+
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		utils.DefaultKeySet.RunRotation(jobsCtx, keyRepo, signingAlg)
+	}()
+
 	authRepo := repositories.NewAuthRepository(db)
+	roleRepo := repositories.NewRoleRepository(db)
+	permissionRepo := repositories.NewPermissionRepository(db)
+	clientRepo := repositories.NewClientRepository(db)
+	serviceAccountRepo := repositories.NewServiceAccountRepository(db)
 
-	authHandler := handlers.NewAuthHandler(authRepo)
+	authHandler := handlers.NewAuthHandler(authRepo, utils.NewMailSender())
+	roleHandler := handlers.NewRoleHandler(roleRepo)
+	permissionHandler := handlers.NewPermissionHandler(permissionRepo)
+	keysHandler := handlers.NewKeysHandler(utils.DefaultKeySet)
+	oauthHandler := handlers.NewOAuthHandler(authRepo, utils.OIDCProviders())
+	clientHandler := handlers.NewClientHandler(clientRepo)
+	authorizationHandler := handlers.NewAuthorizationHandler(authRepo, clientRepo)
+	serviceAccountHandler := handlers.NewServiceAccountHandler(serviceAccountRepo)
+	healthHandler := handlers.NewHealthHandler(
+		handlers.DatabaseCheck(db),
+		handlers.MigrationsCheck(),
+		handlers.SigningKeyCheck(utils.DefaultKeySet),
+		handlers.ShutdownCheck(ctx),
+	)
+
+	rateLimits, err := ratelimit.ParseRoutes(utils.RateLimits())
+	if err != nil {
+		return fmt.Errorf("invalid rate limit configuration: %w", err)
+	}
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), rateLimits)
 
 	r := gin.Default()
+	r.Use(limiter.Middleware())
+	r.GET("/health", healthHandler.Ready)
+	r.GET("/health/live", healthHandler.Live)
+	r.GET("/health/ready", healthHandler.Ready)
 	r.POST("/register", authHandler.Register)
 	r.POST("/login", authHandler.Login)
+	r.POST("/login/mfa", authHandler.LoginMFA)
+	r.POST("/login/magic", authHandler.RequestMagicLink)
+	r.POST("/login/magic/verify", authHandler.VerifyMagicLink)
+	r.GET("/login/oauth/:provider", oauthHandler.Start)
+	r.GET("/login/oauth/:provider/callback", oauthHandler.Callback)
+	r.POST("/login/webauthn/begin", authHandler.BeginPasskeyLogin)
+	r.POST("/login/webauthn/finish", authHandler.FinishPasskeyLogin)
 	r.POST("/refresh", authHandler.RefreshToken)
+	r.POST("/logout", authHandler.Logout)
+	r.POST("/logout/all", authHandler.LogoutAll)
+	r.POST("/email/verify", authHandler.VerifyEmail)
+	r.POST("/email/verify/resend", authHandler.ResendVerification)
+	r.POST("/password/forgot", authHandler.ForgotPassword)
+	r.POST("/password/reset", authHandler.ResetPassword)
+	r.GET("/sessions", authHandler.ListSessions)
+	r.DELETE("/sessions/:id", authHandler.RevokeSession)
+	r.GET("/authorize", authorizationHandler.Authorize)
+	r.POST("/token", authorizationHandler.Token)
+	r.POST("/service/token", serviceAccountHandler.ExchangeAPIKey)
+	r.GET("/.well-known/jwks.json", keysHandler.JWKS)
+	r.GET("/.well-known/openid-configuration", authorizationHandler.Discovery)
+
+	requireAuth := jwtauth.Middleware(jwtauth.Config{
+		Keyfunc:  utils.DefaultKeySet.Keyfunc,
+		Issuer:   utils.JWTIssuer(),
+		Audience: utils.JWTAudience(),
+	})
+
+	// /userinfo also serves tokens issued to OAuth clients on a user's behalf.
+	userInfo := r.Group("/userinfo", jwtauth.Middleware(jwtauth.Config{
+		Keyfunc:            utils.DefaultKeySet.Keyfunc,
+		Issuer:             utils.JWTIssuer(),
+		Audience:           utils.JWTAudience(),
+		AcceptClientTokens: true,
+	}), jwtauth.RequireUser())
+	userInfo.GET("", authorizationHandler.UserInfo)
+	userInfo.POST("", authorizationHandler.UserInfo)
+
+	user := r.Group("/", requireAuth, jwtauth.RequireUser())
+	user.GET("/me", authHandler.Me)
+	user.POST("/me/password", authHandler.ChangePassword)
+	user.POST("/me/mfa/totp/enroll", authHandler.EnrollTOTP)
+	user.POST("/me/mfa/totp/confirm", authHandler.ConfirmTOTP)
+	user.POST("/me/mfa/totp/disable", authHandler.DisableTOTP)
+	user.POST("/me/webauthn/register/begin", authHandler.BeginPasskeyRegistration)
+	user.POST("/me/webauthn/register/finish", authHandler.FinishPasskeyRegistration)
+	user.POST("/me/webauthn/reauthenticate/begin", authHandler.BeginPasskeyReauthentication)
+	user.GET("/me/webauthn/credentials", authHandler.ListPasskeys)
+	user.GET("/me/identities", oauthHandler.ListIdentities)
+	user.GET("/me/consents/:client", authorizationHandler.GetConsent)
+	user.PUT("/me/consents/:client", authorizationHandler.GrantConsent)
+	user.DELETE("/me/consents/:client", authorizationHandler.RevokeConsent)
+	user.PATCH("/me/webauthn/credentials/:id", authHandler.RenamePasskey)
+	user.DELETE("/me/webauthn/credentials/:id", authHandler.DeletePasskey)
+
+	admin := user.Group("/admin", jwtauth.RequireRole(models.RoleAdmin))
+	admin.GET("/roles", roleHandler.ListRoles)
+	admin.POST("/roles", roleHandler.CreateRole)
+	admin.GET("/roles/:id", roleHandler.GetRole)
+	admin.PATCH("/roles/:id", roleHandler.UpdateRole)
+	admin.DELETE("/roles/:id", roleHandler.DeleteRole)
+	admin.GET("/roles/:id/permissions", permissionHandler.GetRolePermissions)
+	admin.POST("/roles/:id/permissions", permissionHandler.AttachPermission)
+	admin.DELETE("/roles/:id/permissions/:permission", permissionHandler.DetachPermission)
+	admin.GET("/permissions", permissionHandler.ListPermissions)
+	admin.POST("/permissions", permissionHandler.CreatePermission)
+	admin.DELETE("/permissions/:id", permissionHandler.DeletePermission)
+	admin.GET("/clients", clientHandler.ListClients)
+	admin.POST("/clients", clientHandler.CreateClient)
+	admin.GET("/clients/:id", clientHandler.GetClient)
+	admin.DELETE("/clients/:id", clientHandler.DeleteClient)
+	admin.GET("/service-accounts", serviceAccountHandler.ListServiceAccounts)
+	admin.POST("/service-accounts", serviceAccountHandler.CreateServiceAccount)
+	admin.GET("/service-accounts/:id", serviceAccountHandler.GetServiceAccount)
+	admin.DELETE("/service-accounts/:id", serviceAccountHandler.DeleteServiceAccount)
+	admin.GET("/service-accounts/:id/keys", serviceAccountHandler.ListAPIKeys)
+	admin.POST("/service-accounts/:id/keys", serviceAccountHandler.CreateAPIKey)
+	admin.POST("/service-accounts/:id/keys/:key/rotate", serviceAccountHandler.RotateAPIKey)
+	admin.DELETE("/service-accounts/:id/keys/:key", serviceAccountHandler.RevokeAPIKey)
+	admin.POST("/users/:id/unlock", authHandler.UnlockAccount)
+	admin.GET("/users/:id/roles", roleHandler.GetUserRoles)
+	admin.POST("/users/:id/roles", roleHandler.GrantRole)
+	admin.DELETE("/users/:id/roles/:role", roleHandler.RevokeRole)
 
-	errServer := r.Run(":8080")
-	if errServer != nil {
-		panic("failed to run server")
+	cfg := config.Current()
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
 	}
+
+	// A second signal now kills the process instead of waiting for the drain.
+	context.AfterFunc(ctx, stop)
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("listening on %s", server.Addr)
+	return runServer(ctx, server, listener, cfg.Server)
+}
+
+// runServer serves on listener until ctx is done. It then keeps serving for
+// DrainDelay while readiness fails, and gives in-flight requests
+// ShutdownTimeout to finish before their connections are closed.
+func runServer(ctx context.Context, server *http.Server, listener net.Listener, settings config.ServerConfig) error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down: draining for %s", settings.DrainDelay)
+	time.Sleep(settings.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("requests still running after %s: %v", settings.ShutdownTimeout, err)
+		server.Close()
+	}
+	if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	log.Printf("server stopped")
+	return nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"auth-service/config"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startServer runs h through runServer on a local port and returns its URL
+// and the channel runServer's result arrives on.
+func startServer(t *testing.T, ctx context.Context, h http.Handler, settings config.ServerConfig) (string, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(ctx, &http.Server{Handler: h}, listener, settings)
+	}()
+	return "http://" + listener.Addr().String(), done
+}
+
+func get(url string) (int, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func waitStopped(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+		return nil
+	}
+}
+
+func TestRunServerFinishesInFlightRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	url, done := startServer(t, ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}), config.ServerConfig{ShutdownTimeout: 5 * time.Second})
+
+	status := make(chan int, 1)
+	go func() {
+		code, _ := get(url)
+		status <- code
+	}()
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("in-flight request got %d, want %d", code, http.StatusOK)
+	}
+	if err := waitStopped(t, done); err != nil {
+		t.Errorf("runServer = %v", err)
+	}
+}
+
+func TestRunServerKeepsServingWhileDraining(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	url, done := startServer(t, ctx, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		config.ServerConfig{DrainDelay: 300 * time.Millisecond, ShutdownTimeout: time.Second})
+	cancel()
+
+	// Load balancers keep routing here until they see readiness fail.
+	if code, err := get(url); err != nil || code != http.StatusOK {
+		t.Errorf("request during drain = %d, %v", code, err)
+	}
+	if err := waitStopped(t, done); err != nil {
+		t.Errorf("runServer = %v", err)
+	}
+	if _, err := get(url); err == nil {
+		t.Error("server still accepts requests after shutdown")
+	}
+}
+
+func TestRunServerClosesStuckRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	url, done := startServer(t, ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}), config.ServerConfig{ShutdownTimeout: 100 * time.Millisecond})
+
+	requestErr := make(chan error, 1)
+	go func() {
+		_, err := get(url)
+		requestErr <- err
+	}()
+	<-started
+
+	start := time.Now()
+	cancel()
+	if err := waitStopped(t, done); err != nil {
+		t.Errorf("runServer = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s despite a 100ms timeout", elapsed)
+	}
+	if err := <-requestErr; err == nil {
+		t.Error("stuck request completed, want its connection closed")
+	}
+}
+
+func TestRunServerReturnsServeErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	err = runServer(context.Background(), &http.Server{}, listener, config.ServerConfig{})
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("runServer = %v, want the listener's error", err)
+	}
+}
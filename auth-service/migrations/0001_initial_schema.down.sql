@@ -0,0 +1,36 @@
+-- Removes everything the baseline created except the shared extensions.
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_cron') THEN
+        DELETE FROM cron.job WHERE jobname IN ('revoke-expired-tokens', 'cleanup-revoked-tokens');
+    END IF;
+END $$;
+
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS service_accounts;
+DROP TABLE IF EXISTS authorization_codes;
+DROP TABLE IF EXISTS clients;
+DROP TABLE IF EXISTS oauth_states;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS magic_links;
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP TABLE IF EXISTS webauthn_credentials;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
+DROP TABLE IF EXISTS signing_keys;
+DROP TABLE IF EXISTS login_throttles;
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS security_events;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS roles;
+
+DROP FUNCTION IF EXISTS user_has_role(UUID, TEXT);
+DROP FUNCTION IF EXISTS ensure_user_has_default_role();
+DROP FUNCTION IF EXISTS check_token_expiry();
+DROP FUNCTION IF EXISTS revoke_expired_tokens();
+DROP FUNCTION IF EXISTS update_updated_at_column();
@@ -0,0 +1,12 @@
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_cron') THEN
+        DELETE FROM cron.job WHERE jobname = 'cleanup-revoked-tokens';
+
+        PERFORM cron.schedule(
+            'cleanup-revoked-tokens',
+            '0 3 * * *',
+            'DELETE FROM refresh_tokens WHERE is_revoked = TRUE'
+        );
+    END IF;
+END $$;
@@ -0,0 +1,21 @@
+-- Revoked refresh tokens are what reuse detection looks up: a rotated token
+-- replayed by a thief must still be found so its whole family gets revoked.
+-- The nightly cleanup used to delete every revoked row, after which a replay
+-- only saw token_not_found. Now a family is kept until its last token has
+-- expired, when none of its tokens can be used any more.
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_cron') THEN
+        DELETE FROM cron.job WHERE jobname = 'cleanup-revoked-tokens';
+
+        PERFORM cron.schedule(
+            'cleanup-revoked-tokens',
+            '0 3 * * *',
+            $cmd$DELETE FROM refresh_tokens t WHERE NOT EXISTS (
+                SELECT 1 FROM refresh_tokens f
+                WHERE f.family_id = t.family_id AND f.expires_at > NOW()
+            )$cmd$
+        );
+    END IF;
+END $$;
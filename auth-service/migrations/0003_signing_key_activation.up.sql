@@ -0,0 +1,8 @@
+-- New signing keys are published before they sign, so that verifiers with a
+-- cached JWKS do not reject fresh tokens. Existing keys were active from the
+-- start.
+
+ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS activates_at TIMESTAMP WITH TIME ZONE;
+UPDATE signing_keys SET activates_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE activates_at IS NULL;
+ALTER TABLE signing_keys ALTER COLUMN activates_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE signing_keys ALTER COLUMN activates_at SET NOT NULL;
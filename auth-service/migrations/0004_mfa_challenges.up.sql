@@ -0,0 +1,12 @@
+-- MFA challenge tokens are signed, but a signature alone lets a captured
+-- token be exchanged again until it expires. Each challenge now has a stored
+-- nonce that the successful second factor consumes, as magic links and
+-- WebAuthn challenges already do.
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    nonce_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
@@ -0,0 +1,10 @@
+-- Tokens issued to OAuth clients used to be first-party tokens carrying every
+-- role of the user. They are now limited to the scope the user granted, which
+-- the refresh token keeps so that refreshing cannot widen it. Client sessions
+-- started before this point never recorded a scope. They are deleted rather
+-- than revoked, so a client still holding one gets invalid_grant instead of
+-- setting off reuse detection, and the user signs in to the client again.
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+
+DELETE FROM refresh_tokens WHERE client_id <> '';
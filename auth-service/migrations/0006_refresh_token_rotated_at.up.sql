@@ -0,0 +1,14 @@
+-- Reuse detection used to treat every revoked refresh token presented again as
+-- stolen, including tokens revoked on purpose by logout, session revocation or
+-- a password change. Only a token that was rotated, and so has a successor,
+-- is evidence of theft when replayed. Rotation now records when it happened.
+-- Revoked tokens that already have a later token in their family were rotated.
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE refresh_tokens t
+SET rotated_at = (
+    SELECT MIN(n.created_at) FROM refresh_tokens n
+    WHERE n.family_id = t.family_id AND n.created_at > t.created_at
+)
+WHERE t.is_revoked AND t.rotated_at IS NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS client_consents;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS first_party;
@@ -0,0 +1,15 @@
+-- Partner applications used to receive an authorization code for any signed
+-- in user without asking them. Now a user approves the scopes each client
+-- may use, once, and the approval is kept here. Clients run by the shop
+-- itself are marked first_party and skip the question; every existing client
+-- starts as a partner, so its users are asked on their next sign-in.
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS first_party BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS client_consents (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id TEXT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    scopes JSONB NOT NULL DEFAULT '[]',
+    granted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, client_id)
+);
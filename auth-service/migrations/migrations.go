@@ -0,0 +1,9 @@
+// Package migrations embeds the versioned SQL migrations of the auth-service
+// schema. Add a new numbered up/down pair for every schema change; applied
+// files must never be edited, as their checksums are verified at startup.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var Files embed.FS
@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"auth-service/pkg/migrate"
+	"testing"
+)
+
+// released are the checksums of migrations that have shipped. Databases
+// refuse to start when an applied file changes, so these must never be
+// edited; add the checksum of each new migration once it is released. They
+// were recomputed once, when checksums came to cover the down file as well;
+// databases still holding the old up-only checksums are upgraded by the next
+// migrate run.
+var released = map[int64]string{
+	1: "7cfe4f9716a407e1bca068dd899822545f3ff5fd493f6ba27cae874650f1c10d",
+	2: "5509ffe1d392039785676bab60c1f0889aef55cfb042909792c1f644c224831a",
+	3: "dcf7e790e4ce3bb0bf8c22557c7195b065f05d4279dcea38782947230233d7aa",
+	4: "7906d1f83fccc9b7362e144de6828bcbe9c8f4ea6a50f2b69e128444c82d25ae",
+	5: "18d991a724986a2c9b89fc99767d1e7fa13936604837755643c5b1b841cff916",
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	list, err := migrate.Load(Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range list {
+		if migration.Version != int64(i+1) {
+			t.Errorf("migration %s follows version %d; versions must be consecutive", migration, i)
+		}
+		if want, ok := released[migration.Version]; ok && migration.Checksum != want {
+			t.Errorf("released migration %s was edited; add a new migration instead", migration)
+		}
+	}
+	if len(list) < len(released) {
+		t.Errorf("loaded %d migrations, but %d were released", len(list), len(released))
+	}
+}
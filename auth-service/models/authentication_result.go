@@ -5,11 +5,17 @@ type AuthenticationResult struct {
 	AccessToken       string
 	AccessTokenExpiry int64
 	RefreshToken      *RefreshToken
+	MFAChallenge      *MFAChallenge
 }
 
 type AuthenticationResponse struct {
-	User            *User  `json:"user,omitempty"`
-	AccessToken     string `json:"accessToken"`
-	AccessTokenExp  int64  `json:"accessTokenExp"`
-	RefreshTokenExp int64  `json:"refreshTokenExp"`
+	User                      *User    `json:"user,omitempty"`
+	AccessToken               string   `json:"accessToken,omitempty"`
+	AccessTokenExp            int64    `json:"accessTokenExp,omitempty"`
+	RefreshTokenExp           int64    `json:"refreshTokenExp,omitempty"`
+	EmailVerificationRequired bool     `json:"emailVerificationRequired,omitempty"`
+	MFARequired               bool     `json:"mfaRequired,omitempty"`
+	MFAToken                  string   `json:"mfaToken,omitempty"`
+	MFATokenExp               int64    `json:"mfaTokenExp,omitempty"`
+	MFAMethods                []string `json:"mfaMethods,omitempty"`
 }
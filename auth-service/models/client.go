@@ -0,0 +1,96 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// OAuth 2.0 grant types a client can be allowed to use.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Client is an application registered to obtain tokens through the OAuth 2.0
+// endpoints. Clients without a secret are public (SPAs, mobile apps) and must
+// use PKCE.
+type Client struct {
+	ID           string     `json:"clientId"`
+	Name         string     `json:"name"`
+	SecretHash   string     `json:"-"`
+	RedirectURIs StringList `json:"redirectUris"`
+	GrantTypes   StringList `json:"grantTypes"`
+	Scopes       StringList `json:"scopes"`
+	// FirstParty clients are run by the shop itself, so users are not asked
+	// to approve the scopes they request.
+	FirstParty bool      `json:"firstParty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// ClientWithSecret is returned once, when a confidential client is created.
+type ClientWithSecret struct {
+	*Client
+	Secret string `json:"clientSecret,omitempty"`
+}
+
+func (c *Client) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+func (c *Client) AllowsGrant(grant string) bool {
+	return contains(c.GrantTypes, grant)
+}
+
+// AllowsRedirect requires an exact match, as prefix matching enables open
+// redirects.
+func (c *Client) AllowsRedirect(uri string) bool {
+	return uri != "" && contains(c.RedirectURIs, uri)
+}
+
+func (c *Client) AllowsScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Consent records the scopes a user approved for a client.
+type Consent struct {
+	ClientID   string     `json:"clientId"`
+	ClientName string     `json:"clientName"`
+	Scopes     StringList `json:"scopes"`
+	GrantedAt  *time.Time `json:"grantedAt,omitempty"`
+}
+
+// Covers reports whether every one of scopes was approved.
+func (c *Consent) Covers(scopes []string) bool {
+	for _, scope := range scopes {
+		if !contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+type AuthorizationCode struct {
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
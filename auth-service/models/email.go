@@ -0,0 +1,7 @@
+package models
+
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
@@ -1,10 +1,15 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"time"
+)
 
 type AppError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int64  `json:"retryAfter,omitempty"`
 }
 
 func (e *AppError) Error() string {
@@ -18,12 +23,63 @@ func New(code, message string) *AppError {
 	}
 }
 
+// NewAccountLocked reports a temporary lock, with the number of seconds the
+// client should wait before trying again.
+func NewAccountLocked(retryAfter time.Duration) *AppError {
+	return &AppError{
+		Code:       "account_locked",
+		Message:    "too many failed login attempts, try again later",
+		RetryAfter: int64(math.Ceil(retryAfter.Seconds())),
+	}
+}
+
 var (
-	ErrUserExists         = New("user_already_exists", "user already exists")
-	ErrInvalidCredentials = New("invalid_credentials", "invalid credentials")
-	ErrTokenExpired       = New("token_expired", "token expired")
-	ErrTokenRevoked       = New("token_revoked", "token revoked")
-	ErrTokenNotFound      = New("token_not_found", "token not found")
-	ErrInvalidInput       = New("invalid_input", "invalid input data")
-	ErrInternalServer     = New("internal_server_error", "internal server error")
+	ErrUserExists            = New("user_already_exists", "user already exists")
+	ErrUserNotFound          = New("user_not_found", "user not found")
+	ErrInvalidCredentials    = New("invalid_credentials", "invalid credentials")
+	ErrWrongPassword         = New("wrong_password", "current password is incorrect")
+	ErrTokenExpired          = New("token_expired", "token expired")
+	ErrTokenRevoked          = New("token_revoked", "token revoked")
+	ErrTokenReused           = New("token_reuse_detected", "token reuse detected, session revoked")
+	ErrTokenNotFound         = New("token_not_found", "token not found")
+	ErrSessionNotFound       = New("session_not_found", "session not found")
+	ErrResetTokenInvalid     = New("reset_token_invalid", "reset token is invalid or expired")
+	ErrVerificationInvalid   = New("verification_token_invalid", "verification token is invalid or expired")
+	ErrEmailNotVerified      = New("email_not_verified", "email address is not verified")
+	ErrWeakPassword          = New("weak_password", "password does not meet the policy")
+	ErrUnauthorized          = New("unauthorized", "valid access token required")
+	ErrRoleNotFound          = New("role_not_found", "role not found")
+	ErrRoleExists            = New("role_already_exists", "role already exists")
+	ErrRoleProtected         = New("role_protected", "built-in roles cannot be renamed or deleted")
+	ErrRoleNotAssigned       = New("role_not_assigned", "user does not have this role")
+	ErrLastAdmin             = New("last_admin", "the last administrator cannot lose the ADMIN role")
+	ErrPermissionNotFound    = New("permission_not_found", "permission not found")
+	ErrPermissionExists      = New("permission_already_exists", "permission already exists")
+	ErrMFAInvalidCode        = New("mfa_invalid_code", "invalid or already used authentication code")
+	ErrMFAChallengeInvalid   = New("mfa_challenge_invalid", "MFA challenge is invalid or expired")
+	ErrMFANotEnrolled        = New("mfa_not_enrolled", "two-factor authentication is not set up")
+	ErrMFAAlreadyEnabled     = New("mfa_already_enabled", "two-factor authentication is already enabled")
+	ErrMagicLinkInvalid      = New("magic_link_invalid", "sign-in link is invalid, expired or was opened in another browser")
+	ErrIdentityConflict      = New("identity_conflict", "an account with this email already exists, sign in to it first")
+	ErrIdentityEmailMissing  = New("identity_email_missing", "the identity provider did not share an email address")
+	ErrOAuthStateInvalid     = New("oauth_state_invalid", "sign-in request is invalid or expired")
+	ErrProviderNotFound      = New("provider_not_found", "identity provider not found")
+	ErrProviderFailed        = New("provider_error", "identity provider sign-in failed")
+	ErrClientNotFound        = New("client_not_found", "client not found")
+	ErrInvalidClient         = New("invalid_client", "client authentication failed")
+	ErrInvalidGrant          = New("invalid_grant", "authorization grant is invalid or expired")
+	ErrConsentNotFound       = New("consent_not_found", "no access was granted to this client")
+	ErrServiceNotFound       = New("service_account_not_found", "service account not found")
+	ErrServiceExists         = New("service_account_already_exists", "service account already exists")
+	ErrAPIKeyNotFound        = New("api_key_not_found", "API key not found")
+	ErrAPIKeyInvalid         = New("api_key_invalid", "API key is invalid, expired or revoked")
+	ErrScopeNotAllowed       = New("scope_not_allowed", "requested scope is not granted to this key")
+	ErrPasskeyInvalid        = New("passkey_invalid", "passkey verification failed")
+	ErrPasskeyNotFound       = New("passkey_not_found", "passkey not found")
+	ErrPasskeyExists         = New("passkey_already_registered", "passkey is already registered")
+	ErrReauthRequired        = New("reauthentication_required", "confirm with your password, an authentication code or a passkey")
+	ErrPermissionNotAssigned = New("permission_not_assigned", "role does not have this permission")
+	ErrTooManyPermissions    = New("too_many_permissions", "user has more permissions than fit in an access token")
+	ErrInvalidInput          = New("invalid_input", "invalid input data")
+	ErrInternalServer        = New("internal_server_error", "internal server error")
 )
@@ -0,0 +1,16 @@
+package models
+
+const (
+	HealthStatusUp   = "up"
+	HealthStatusDown = "down"
+)
+
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                     `json:"status"`
+	Checks map[string]ComponentHealth `json:"checks,omitempty"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ExternalIdentity is a user as asserted by an external identity provider.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type UserIdentity struct {
+	ID          uuid.UUID  `json:"id"`
+	Provider    string     `json:"provider"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
+
+// OAuthState is kept between redirecting to a provider and its callback.
+type OAuthState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
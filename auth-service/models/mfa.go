@@ -0,0 +1,33 @@
+package models
+
+import (
+	"auth-service/pkg/webauthn"
+	"time"
+)
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauthUri"`
+}
+
+type MFAChallenge struct {
+	Token     string
+	ExpiresAt time.Time
+	Methods   []string
+}
+
+// Second factor methods offered in an MFA challenge.
+const (
+	MFAMethodTOTP     = "totp"
+	MFAMethodWebAuthn = "webauthn"
+)
+
+// Reauthentication proves that the account holder, not just a bearer of their
+// access token, asks to change how the account signs in. Any one of the
+// password, a TOTP or recovery code, or an assertion from an existing passkey
+// is enough.
+type Reauthentication struct {
+	Password string                      `json:"password"`
+	Code     string                      `json:"code"`
+	Passkey  *webauthn.AssertionResponse `json:"passkey"`
+}
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+type PasswordReset struct {
+	User      *User
+	Token     string
+	ExpiresAt time.Time
+}
@@ -6,9 +6,18 @@ import (
 )
 
 type RefreshToken struct {
-	Token     uuid.UUID `json:"token"`
+	ID        uuid.UUID `json:"id"`
+	Token     string    `json:"-"`
+	TokenHash string    `json:"-"`
 	UserID    uuid.UUID `json:"userId"`
+	FamilyID  uuid.UUID `json:"familyId"`
+	UserAgent string    `json:"userAgent"`
+	IPAddress string    `json:"ipAddress"`
+	ClientID  string    `json:"clientId,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
 	ExpiresAt time.Time `json:"expiresAt"`
 	IsRevoked bool      `json:"isRevoked"`
+	// RotatedAt is set when the token was exchanged for its successor.
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
 }
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	EventRefreshTokenReuse = "refresh_token_reuse"
+	EventPasswordReset     = "password_reset"
+	EventEmailVerified     = "email_verified"
+	EventPasswordChanged   = "password_changed"
+	EventAccountLocked     = "account_locked"
+	EventAccountUnlocked   = "account_unlocked"
+	EventMFAEnabled        = "mfa_enabled"
+	EventMFADisabled       = "mfa_disabled"
+	EventRecoveryCodeUsed  = "mfa_recovery_code_used"
+	EventPasskeyAdded      = "passkey_registered"
+	EventPasskeyRemoved    = "passkey_removed"
+	EventIdentityLinked    = "identity_linked"
+	EventPasswordSet       = "password_set"
+	EventSessionsRevoked   = "sessions_revoked"
+)
+
+type SecurityEvent struct {
+	ID        uuid.UUID      `json:"id"`
+	UserID    uuid.UUID      `json:"userId"`
+	EventType string         `json:"eventType"`
+	IPAddress string         `json:"ipAddress"`
+	UserAgent string         `json:"userAgent"`
+	Details   map[string]any `json:"details"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ServiceAccount is a non-human caller, such as the order or payment service,
+// that authenticates with API keys instead of a password.
+type ServiceAccount struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type APIKey struct {
+	ID               uuid.UUID  `json:"id"`
+	ServiceAccountID uuid.UUID  `json:"serviceAccountId"`
+	Prefix           string     `json:"prefix"`
+	Scopes           StringList `json:"scopes"`
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	LastUsedAt       *time.Time `json:"lastUsedAt"`
+	LastUsedIP       *string    `json:"lastUsedIp"`
+	RevokedAt        *time.Time `json:"revokedAt"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+// APIKeyWithSecret is returned once, when a key is created or rotated; only
+// its hash is stored.
+type APIKeyWithSecret struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// ServiceToken is the access token an API key is exchanged for.
+type ServiceToken struct {
+	AccessToken    string   `json:"accessToken"`
+	AccessTokenExp int64    `json:"accessTokenExp"`
+	Scopes         []string `json:"scopes"`
+}
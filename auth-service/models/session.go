@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+	// ClientID is the OAuth client a session was issued to, empty for the
+	// first-party cookie flow.
+	ClientID string
+}
+
+// Session is one sign-in. Its ID is the refresh token family, which stays the
+// same across refreshes.
+type Session struct {
+	ID        uuid.UUID `json:"id"`
+	UserAgent string    `json:"userAgent"`
+	IPAddress string    `json:"ipAddress"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Current   bool      `json:"current"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// SigningKey is a JWT signing key. It is published from CreatedAt but only
+// signs from ActivatesAt, so verifiers have it cached before tokens use it.
+type SigningKey struct {
+	ID          string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	PrivateKey  string     `json:"-"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ActivatesAt time.Time  `json:"activatesAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
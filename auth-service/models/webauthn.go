@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+type WebAuthnCredential struct {
+	ID           uuid.UUID  `json:"id"`
+	Name         string     `json:"name"`
+	CredentialID string     `json:"credentialId"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    int64      `json:"-"`
+	Transports   StringList `json:"transports"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+}
+
+// StringList stores a list of strings in a JSONB column.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *StringList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	}
+	return errors.New("unsupported StringList source")
+}
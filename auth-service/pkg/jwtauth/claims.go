@@ -0,0 +1,56 @@
+// Package jwtauth verifies access tokens issued by the auth-service. It is
+// meant to be imported by the other shopper services as well.
+package jwtauth
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+)
+
+// SubTypeService marks tokens issued to a service account rather than a user.
+const SubTypeService = "service"
+
+type Claims struct {
+	UserID        string   `json:"userId"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+	ClientID      string   `json:"client_id,omitempty"`
+	Scope         string   `json:"scope,omitempty"`
+	SubType       string   `json:"sub_type,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsService reports whether the token was issued to a service account.
+func (c *Claims) IsService() bool {
+	return c.SubType == SubTypeService
+}
+
+// HasScope reports whether the space-separated scope claim contains scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// RemoteKeySet verifies tokens against the keys published at a JWKS URL. Keys
+// are cached and refetched when they go stale or an unknown kid shows up.
+type RemoteKeySet struct {
+	URL        string
+	Client     *http.Client
+	TTL        time.Duration
+	MinRefresh time.Duration
+
+	// refreshMu serializes fetches so a burst of tokens with a new kid
+	// causes one request; mu only guards the cache and is never held while
+	// the endpoint is called.
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+}
+
+type publicKey struct {
+	alg string
+	key interface{}
+}
+
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:        url,
+		Client:     &http.Client{Timeout: 5 * time.Second},
+		TTL:        5 * time.Minute,
+		MinRefresh: 10 * time.Second,
+	}
+}
+
+func (ks *RemoteKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok, due := ks.lookup(kid)
+	if due {
+		if err := ks.refresh(kid); err != nil && !ok {
+			return nil, err
+		}
+		key, ok, _ = ks.lookup(kid)
+	}
+
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	// Third-party key sets may omit "alg"; the parser's method allowlist
+	// still applies then.
+	if key.alg != "" && token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+// lookup returns the cached key for kid and whether the set should be
+// refetched, because the key is missing or the cache is stale, without
+// exceeding one fetch per MinRefresh.
+func (ks *RemoteKeySet) lookup(kid string) (publicKey, bool, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	age := time.Since(ks.fetchedAt)
+	return key, ok, (!ok || age > ks.TTL) && age > ks.MinRefresh
+}
+
+func (ks *RemoteKeySet) refresh(kid string) error {
+	ks.refreshMu.Lock()
+	defer ks.refreshMu.Unlock()
+
+	// Another caller may have fetched while this one waited.
+	if _, _, due := ks.lookup(kid); !due {
+		return nil
+	}
+
+	keys, err := ks.fetch()
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	// Failed fetches count too, so an unreachable endpoint is not hammered.
+	ks.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	return nil
+}
+
+func (ks *RemoteKeySet) fetch() (map[string]publicKey, error) {
+	resp, err := ks.Client.Get(ks.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned %s", resp.Status)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = publicKey{alg: jwk.Alg, key: key}
+	}
+	return keys, nil
+}
+
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
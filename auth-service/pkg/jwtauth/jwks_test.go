@@ -0,0 +1,246 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testKey struct {
+	kid     string
+	private *ecdsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, private: private}
+}
+
+func (k testKey) jwk() JWK {
+	return JWK{
+		Kty: "EC",
+		Kid: k.kid,
+		Use: "sig",
+		Alg: "ES256",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(k.private.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(k.private.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func (k testKey) sign(t *testing.T, claims *Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// jwksServer publishes whatever keys are set and counts the requests.
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []JWK
+	status   int
+	requests atomic.Int32
+	// block, when set, holds every request until it is closed.
+	block chan struct{}
+}
+
+func newJWKSServer(t *testing.T, keys ...testKey) *jwksServer {
+	s := &jwksServer{status: http.StatusOK}
+	s.publish(keys...)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		block, status, set := s.block, s.status, JWKS{Keys: s.keys}
+		s.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) publish(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	for _, key := range keys {
+		s.keys = append(s.keys, key.jwk())
+	}
+}
+
+func parse(ks *RemoteKeySet, token string) error {
+	_, err := jwt.NewParser(jwt.WithValidMethods(SigningMethods)).ParseWithClaims(token, &Claims{}, ks.Keyfunc)
+	return err
+}
+
+func validClaims() *Claims {
+	return &Claims{
+		UserID: "user-id",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func TestRemoteKeySetFetchesUnknownKid(t *testing.T) {
+	oldKey, newKey := newTestKey(t, "old"), newTestKey(t, "new")
+	server := newJWKSServer(t, oldKey)
+	ks := NewRemoteKeySet(server.URL)
+	ks.MinRefresh = 0
+
+	if err := parse(ks, oldKey.sign(t, validClaims())); err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(ks, oldKey.sign(t, validClaims())); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Fatalf("%d requests for a cached key, want 1", n)
+	}
+
+	server.publish(oldKey, newKey)
+	if err := parse(ks, newKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("token signed with a newly published key: %v", err)
+	}
+	if n := server.requests.Load(); n != 2 {
+		t.Fatalf("%d requests, want a refetch for the unknown kid", n)
+	}
+}
+
+func TestRemoteKeySetRateLimitsRefetch(t *testing.T) {
+	key, unknown := newTestKey(t, "key"), newTestKey(t, "unknown")
+	server := newJWKSServer(t, key)
+	ks := NewRemoteKeySet(server.URL)
+	ks.MinRefresh = time.Hour
+
+	for i := 0; i < 5; i++ {
+		if err := parse(ks, unknown.sign(t, validClaims())); err == nil {
+			t.Fatal("token with an unknown kid verified")
+		}
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Fatalf("%d requests for repeated unknown kids, want 1", n)
+	}
+}
+
+func TestRemoteKeySetSingleFetchForBurst(t *testing.T) {
+	key := newTestKey(t, "key")
+	server := newJWKSServer(t, key)
+	ks := NewRemoteKeySet(server.URL)
+	ks.MinRefresh = 0
+
+	token := key.sign(t, validClaims())
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- parse(ks, token)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Fatalf("%d requests for a burst of the same new kid, want 1", n)
+	}
+}
+
+func TestRemoteKeySetCachedKeysDoNotWaitForFetch(t *testing.T) {
+	key, unknown := newTestKey(t, "key"), newTestKey(t, "unknown")
+	server := newJWKSServer(t, key)
+	ks := NewRemoteKeySet(server.URL)
+	ks.MinRefresh = 0
+	if err := parse(ks, key.sign(t, validClaims())); err != nil {
+		t.Fatal(err)
+	}
+
+	block := make(chan struct{})
+	server.mu.Lock()
+	server.block = block
+	server.mu.Unlock()
+	defer close(block)
+
+	go parse(ks, unknown.sign(t, validClaims()))
+	for server.requests.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- parse(ks, key.sign(t, validClaims())) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cached key lookup blocked behind a slow JWKS fetch")
+	}
+}
+
+func TestRemoteKeySetKeepsKeysWhenFetchFails(t *testing.T) {
+	key := newTestKey(t, "key")
+	server := newJWKSServer(t, key)
+	ks := NewRemoteKeySet(server.URL)
+	ks.TTL = 0
+	ks.MinRefresh = 0
+	if err := parse(ks, key.sign(t, validClaims())); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	server.status = http.StatusInternalServerError
+	server.mu.Unlock()
+	if err := parse(ks, key.sign(t, validClaims())); err != nil {
+		t.Fatalf("stale key rejected while the endpoint is down: %v", err)
+	}
+	if n := server.requests.Load(); n != 2 {
+		t.Fatalf("%d requests, want a refetch of the stale set", n)
+	}
+}
+
+func TestRemoteKeySetRejectsAlgorithmMismatch(t *testing.T) {
+	key := newTestKey(t, "key")
+	jwk := key.jwk()
+	jwk.Alg = "EdDSA"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{jwk}})
+	}))
+	defer server.Close()
+
+	if err := parse(NewRemoteKeySet(server.URL), key.sign(t, validClaims())); err == nil {
+		t.Fatal("ES256 token verified with a key published for EdDSA")
+	}
+}
@@ -0,0 +1,169 @@
+package jwtauth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"strings"
+)
+
+const (
+	ContextUserID = "jwtauth.userID"
+	ContextClaims = "jwtauth.claims"
+)
+
+var SigningMethods = []string{"RS256", "ES256", "EdDSA"}
+
+type Config struct {
+	Keyfunc  jwt.Keyfunc
+	Issuer   string
+	Audience string
+	// AcceptClientTokens also admits tokens issued to an OAuth client on a
+	// user's behalf, whose audience is the client rather than Audience.
+	AcceptClientTokens bool
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var (
+	errUnauthorized = errorResponse{Code: "unauthorized", Message: "valid access token required"}
+	errForbidden    = errorResponse{Code: "forbidden", Message: "insufficient privileges"}
+)
+
+// Middleware rejects requests without a valid bearer access token. The token
+// must carry a known signature, be unexpired and, when configured, match the
+// issuer and audience. The user id, empty for service and client tokens, and
+// the claims are stored in the context.
+func Middleware(cfg Config) gin.HandlerFunc {
+	parser := jwt.NewParser(jwt.WithValidMethods(SigningMethods))
+
+	return func(c *gin.Context) {
+		tokenString, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+
+		claims, err := Parse(parser, tokenString, cfg)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+
+		c.Set(ContextUserID, claims.UserID)
+		c.Set(ContextClaims, claims)
+		c.Next()
+	}
+}
+
+// RequireUser rejects service and client tokens, which carry no user id, for
+// routes that act on the signed-in user. It must run after Middleware.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if UserID(c) == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole lets the request through when the caller has at least one of
+// the given roles. It must run after Middleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ClaimsFrom(c)
+		if claims == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, errForbidden)
+	}
+}
+
+// RequirePermission lets the request through only when the caller holds every
+// one of the given permissions. It must run after Middleware.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ClaimsFrom(c)
+		if claims == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+
+		for _, permission := range permissions {
+			if !claims.HasPermission(permission) {
+				c.AbortWithStatusJSON(http.StatusForbidden, errForbidden)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope lets the request through only when the token was granted every
+// one of the given scopes, as service and client tokens are. It must run after
+// Middleware.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ClaimsFrom(c)
+		if claims == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				c.AbortWithStatusJSON(http.StatusForbidden, errForbidden)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func Parse(parser *jwt.Parser, tokenString string, cfg Config) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := parser.ParseWithClaims(tokenString, claims, cfg.Keyfunc); err != nil {
+		return nil, err
+	}
+
+	if cfg.Issuer != "" && !claims.VerifyIssuer(cfg.Issuer, true) {
+		return nil, errors.New("invalid issuer")
+	}
+	if cfg.Audience != "" && !claims.VerifyAudience(cfg.Audience, true) &&
+		!(cfg.AcceptClientTokens && claims.ClientID != "" && claims.VerifyAudience(claims.ClientID, true)) {
+		return nil, errors.New("invalid audience")
+	}
+	// Service and client tokens have a subject but no user id.
+	if claims.Subject == "" {
+		return nil, errors.New("missing subject")
+	}
+
+	return claims, nil
+}
+
+func UserID(c *gin.Context) string {
+	return c.GetString(ContextUserID)
+}
+
+func ClaimsFrom(c *gin.Context) *Claims {
+	claims, _ := c.Get(ContextClaims)
+	typed, _ := claims.(*Claims)
+	return typed
+}
@@ -0,0 +1,206 @@
+package jwtauth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// serve runs the handlers behind a stub that stores claims the way
+// Middleware does, and returns the response status.
+func serve(claims *Claims, handlers ...gin.HandlerFunc) int {
+	r := gin.New()
+	stub := func(c *gin.Context) {
+		if claims != nil {
+			c.Set(ContextUserID, claims.UserID)
+			c.Set(ContextClaims, claims)
+		}
+	}
+	r.GET("/", append(append([]gin.HandlerFunc{stub}, handlers...), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})...)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w.Code
+}
+
+func TestRequireUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{"user token", &Claims{UserID: "user-id"}, http.StatusNoContent},
+		{"service token", &Claims{SubType: SubTypeService, Scope: "orders:read"}, http.StatusUnauthorized},
+		{"client token", &Claims{ClientID: "client", Scope: "orders:read"}, http.StatusUnauthorized},
+		{"no token", nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(tt.claims, RequireUser()); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	key := newTestKey(t, "key")
+	server := newJWKSServer(t, key)
+	cfg := Config{Keyfunc: NewRemoteKeySet(server.URL).Keyfunc, Issuer: "auth-service", Audience: "shopper"}
+
+	claims := func(change func(*Claims)) *Claims {
+		c := validClaims()
+		c.Issuer = "auth-service"
+		c.Audience = jwt.ClaimStrings{"shopper"}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"valid token", "Bearer " + key.sign(t, claims(nil)), http.StatusNoContent},
+		{"missing header", "", http.StatusUnauthorized},
+		{"not a bearer token", "Basic " + key.sign(t, claims(nil)), http.StatusUnauthorized},
+		{"expired", "Bearer " + key.sign(t, claims(func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		})), http.StatusUnauthorized},
+		{"other issuer", "Bearer " + key.sign(t, claims(func(c *Claims) { c.Issuer = "evil" })), http.StatusUnauthorized},
+		{"other audience", "Bearer " + key.sign(t, claims(func(c *Claims) { c.Audience = jwt.ClaimStrings{"client"} })), http.StatusUnauthorized},
+		{"no subject", "Bearer " + key.sign(t, claims(func(c *Claims) { c.Subject = "" })), http.StatusUnauthorized},
+		{"unsigned", "Bearer " + unsigned(t, claims(nil)), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			var got *Claims
+			r.GET("/", Middleware(cfg), func(c *gin.Context) {
+				got = ClaimsFrom(c)
+				c.Status(http.StatusNoContent)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusNoContent && (got == nil || got.UserID != "user-id") {
+				t.Errorf("claims in context = %+v", got)
+			}
+		})
+	}
+}
+
+func TestParseClientTokens(t *testing.T) {
+	key := newTestKey(t, "key")
+	server := newJWKSServer(t, key)
+	parser := jwt.NewParser(jwt.WithValidMethods(SigningMethods))
+
+	delegated := func(audience, clientID string) string {
+		c := validClaims()
+		c.Issuer = "auth-service"
+		c.Audience = jwt.ClaimStrings{audience}
+		c.ClientID = clientID
+		return key.sign(t, c)
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		acceptClients bool
+		wantErr       bool
+	}{
+		{"first-party route", delegated("partner", "partner"), false, true},
+		{"client route", delegated("partner", "partner"), true, false},
+		{"audience of another client", delegated("other", "partner"), true, true},
+		{"no client id", delegated("partner", ""), true, true},
+		{"first-party token on client route", delegated("shopper", ""), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Keyfunc: NewRemoteKeySet(server.URL).Keyfunc, Issuer: "auth-service", Audience: "shopper", AcceptClientTokens: tt.acceptClients}
+			if _, err := Parse(parser, tt.token, cfg); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func unsigned(t *testing.T, claims *Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{"has the role", &Claims{UserID: "id", Roles: []string{"USER", "ADMIN"}}, http.StatusNoContent},
+		{"has another listed role", &Claims{UserID: "id", Roles: []string{"SUPPORT"}}, http.StatusNoContent},
+		{"lacks the roles", &Claims{UserID: "id", Roles: []string{"USER"}}, http.StatusForbidden},
+		{"role names are case sensitive", &Claims{UserID: "id", Roles: []string{"admin"}}, http.StatusForbidden},
+		{"no roles", &Claims{UserID: "id"}, http.StatusForbidden},
+		{"not authenticated", nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(tt.claims, RequireRole("ADMIN", "SUPPORT")); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		want        int
+	}{
+		{"holds all", []string{"orders:read", "orders:write", "billing:read"}, http.StatusNoContent},
+		{"holds one", []string{"orders:read"}, http.StatusForbidden},
+		{"prefix is not a match", []string{"orders"}, http.StatusForbidden},
+		{"no wildcard matching", []string{"orders:*"}, http.StatusForbidden},
+		{"none", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &Claims{UserID: "id", Permissions: tt.permissions}
+			if got := serve(claims, RequirePermission("orders:read", "orders:write")); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := serve(nil, RequirePermission("orders:read")); got != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
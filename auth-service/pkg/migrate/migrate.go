@@ -0,0 +1,365 @@
+// Package migrate applies numbered SQL migrations to PostgreSQL. Migrations
+// are pairs of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Applied versions are recorded together with a
+// checksum of both files in the schema_migrations table, so a migration
+// edited after it ran is reported instead of leaving databases silently
+// diverged, or reverted by a script other than the one that was reviewed.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLockID is the PostgreSQL advisory lock key that serializes
+// migrations when several replicas start at once.
+const DefaultLockID int64 = 0x6d696772617465
+
+var (
+	ErrChecksumMismatch = errors.New("migration was modified after it was applied")
+	ErrIrreversible     = errors.New("migration has no down script")
+	ErrUnknownMigration = errors.New("applied migration is missing from this build")
+)
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+
+	// upChecksum is the checksum of the up file alone, which is what
+	// databases migrated by earlier releases recorded.
+	upChecksum string
+
+	// AfterUp, when set, runs in the migration's transaction after Up, for
+	// steps that cannot be written in SQL alone.
+	AfterUp func(tx *gorm.DB) error
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+	// Modified is set when the migration files no longer match the checksum
+	// recorded when it was applied.
+	Modified bool `json:"modified"`
+	// Unknown is set for versions recorded in the database that this build
+	// has no file for, e.g. after rolling back a deployment.
+	Unknown bool `json:"unknown"`
+}
+
+type appliedMigration struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Load reads the migrations in the root of fsys, ordered by version. Every
+// version needs both an up and a down file; an empty down file marks a
+// migration that cannot be reverted.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	hasUp := map[int64]bool{}
+	hasDown := map[int64]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+			hasUp[version] = true
+		} else {
+			migration.Down = string(content)
+			hasDown[version] = true
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if !hasUp[migration.Version] {
+			return nil, fmt.Errorf("migration %s has no up file", migration)
+		}
+		if !hasDown[migration.Version] {
+			return nil, fmt.Errorf("migration %s has no down file", migration)
+		}
+		migration.Checksum = checksum([]byte(migration.Up), []byte(migration.Down))
+		migration.upChecksum = upChecksum([]byte(migration.Up))
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+type Migrator struct {
+	DB         *gorm.DB
+	Migrations []Migration
+	LockID     int64
+}
+
+func New(db *gorm.DB, migrations []Migration) *Migrator {
+	return &Migrator{DB: db, Migrations: migrations, LockID: DefaultLockID}
+}
+
+// Up applies all pending migrations in version order, each in its own
+// transaction, and returns the ones it applied. Nothing is applied when an
+// already applied migration was modified.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.verify(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(conn, migration); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the given number of most recently applied migrations and
+// returns the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.verify(conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			migration, ok := m.find(versions[i])
+			if !ok {
+				return fmt.Errorf("%w: %04d_%s", ErrUnknownMigration, versions[i], applied[versions[i]].Name)
+			}
+			if err := m.revert(conn, migration); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known or applied migration without changing anything.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			status := Status{Version: migration.Version, Name: migration.Name}
+			if row, ok := applied[migration.Version]; ok {
+				appliedAt := row.AppliedAt
+				status.AppliedAt = &appliedAt
+				status.Modified = !migration.matches(row.Checksum)
+				delete(applied, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for _, row := range applied {
+			appliedAt := row.AppliedAt
+			statuses = append(statuses, Status{Version: row.Version, Name: row.Name, AppliedAt: &appliedAt, Unknown: true})
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock runs fn on a single connection holding the advisory lock, so
+// replicas starting together apply each migration exactly once.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.DB.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", m.LockID).Error; err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		// Release the lock even when ctx was canceled, since the
+		// connection goes back to the pool still holding it otherwise.
+		defer conn.WithContext(context.WithoutCancel(ctx)).Exec("SELECT pg_advisory_unlock(?)", m.LockID)
+
+		if err := conn.Exec(`
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version BIGINT PRIMARY KEY,
+				name TEXT NOT NULL,
+				checksum TEXT NOT NULL,
+				applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`).Error; err != nil {
+			return fmt.Errorf("failed to create schema_migrations: %w", err)
+		}
+
+		return fn(conn)
+	})
+}
+
+func (m *Migrator) applied(conn *gorm.DB) (map[int64]appliedMigration, error) {
+	var rows []appliedMigration
+	if err := conn.Raw("SELECT version, name, checksum, applied_at FROM schema_migrations").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	applied := make(map[int64]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// verify returns the applied migrations after checking that none of them
+// changed since. Rows still holding the up-only checksum of earlier releases
+// are upgraded to cover the down file as it is now.
+func (m *Migrator) verify(conn *gorm.DB) (map[int64]appliedMigration, error) {
+	applied, err := m.applied(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range m.Migrations {
+		row, ok := applied[migration.Version]
+		if !ok || row.Checksum == migration.Checksum {
+			continue
+		}
+		if !migration.matches(row.Checksum) {
+			return nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, migration)
+		}
+		if err := conn.Exec("UPDATE schema_migrations SET checksum = ? WHERE version = ?",
+			migration.Checksum, migration.Version).Error; err != nil {
+			return nil, fmt.Errorf("failed to update checksum of %s: %w", migration, err)
+		}
+		row.Checksum = migration.Checksum
+		applied[migration.Version] = row
+	}
+	return applied, nil
+}
+
+func (m *Migrator) apply(conn *gorm.DB, migration Migration) error {
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Up).Error; err != nil {
+			return err
+		}
+		if migration.AfterUp != nil {
+			if err := migration.AfterUp(tx); err != nil {
+				return err
+			}
+		}
+		return tx.Exec(
+			"INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, migration.Checksum).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %s failed: %w", migration, err)
+	}
+	return nil
+}
+
+func (m *Migrator) revert(conn *gorm.DB, migration Migration) error {
+	if strings.TrimSpace(migration.Down) == "" {
+		return fmt.Errorf("%w: %s", ErrIrreversible, migration)
+	}
+
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("reverting migration %s failed: %w", migration, err)
+	}
+	return nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// matches reports whether a recorded checksum belongs to this migration,
+// under either the current or the up-only scheme.
+func (m Migration) matches(recorded string) bool {
+	return recorded == m.Checksum || (m.upChecksum != "" && recorded == m.upChecksum)
+}
+
+// checksum covers both scripts. The length of the up script is hashed first
+// so that moving text between the two files changes the sum.
+func checksum(up, down []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", len(up))
+	h.Write(up)
+	h.Write(down)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func upChecksum(up []byte) string {
+	sum := sha256.Sum256(up)
+	return hex.EncodeToString(sum[:])
+}
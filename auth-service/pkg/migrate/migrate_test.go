@@ -0,0 +1,342 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return db, mock
+}
+
+func testMigration(version int64, name, up, down string) Migration {
+	return Migration{
+		Version: version, Name: name, Up: up, Down: down,
+		Checksum:   checksum([]byte(up), []byte(down)),
+		upChecksum: upChecksum([]byte(up)),
+	}
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		testMigration(1, "create_widgets", "CREATE TABLE widgets (id INT)", "DROP TABLE widgets"),
+		testMigration(2, "add_color", "ALTER TABLE widgets ADD color TEXT", "ALTER TABLE widgets DROP color"),
+	}
+}
+
+// expectLocked expects the advisory lock and bookkeeping table around a
+// migrator call, with the given schema_migrations rows.
+func expectLocked(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WithArgs(DefaultLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, name, checksum, applied_at FROM schema_migrations`).WillReturnRows(applied)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(DefaultLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func appliedRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"})
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_color.up.sql":        {Data: []byte("ALTER TABLE widgets ADD color TEXT")},
+		"0002_add_color.down.sql":      {Data: []byte("ALTER TABLE widgets DROP color")},
+		"0001_create_widgets.up.sql":   {Data: []byte("CREATE TABLE widgets (id INT)")},
+		"0001_create_widgets.down.sql": {Data: []byte("DROP TABLE widgets")},
+		"README.md":                    {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(migrations, testMigrations()) {
+		t.Errorf("Load = %+v, want %+v", migrations, testMigrations())
+	}
+	if migrations[0].String() != "0001_create_widgets" {
+		t.Errorf("String() = %q", migrations[0])
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"bad file name": {
+			"0001-create.up.sql": {}, "0001-create.down.sql": {},
+		},
+		"version zero": {
+			"0000_create.up.sql": {}, "0000_create.down.sql": {},
+		},
+		"missing down file": {
+			"0001_create.up.sql": {Data: []byte("SELECT 1")},
+		},
+		"missing up file": {
+			"0001_create.down.sql": {},
+		},
+		"version used twice": {
+			"0001_create.up.sql": {}, "0001_create.down.sql": {},
+			"0001_other.up.sql": {}, "0001_other.down.sql": {},
+		},
+	}
+
+	for name, fsys := range tests {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: loaded without error", name)
+		}
+	}
+}
+
+func TestLoadIrreversibleMigration(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"0001_create.up.sql":   {Data: []byte("SELECT 1")},
+		"0001_create.down.sql": {Data: []byte("")},
+	})
+	if err != nil || len(migrations) != 1 || migrations[0].Down != "" {
+		t.Fatalf("Load = %+v, %v; want one migration with an empty down script", migrations, err)
+	}
+}
+
+func TestUpAppliesPendingMigrations(t *testing.T) {
+	db, mock := newMockDB(t)
+	migrations := testMigrations()
+	hookRan := false
+	migrations[1].AfterUp = func(tx *gorm.DB) error {
+		hookRan = true
+		return tx.Exec("UPDATE widgets SET color = 'red'").Error
+	}
+
+	expectLocked(mock, appliedRows().AddRow(1, "create_widgets", migrations[0].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[1].Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE widgets SET color = 'red'`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations \(version, name, checksum\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs(int64(2), "add_color", migrations[1].Checksum).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	done, err := New(db, migrations).Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != 2 || !hookRan {
+		t.Errorf("applied %v, hook ran %v; want only version 2 with its hook", done, hookRan)
+	}
+}
+
+func TestUpRefusesModifiedMigration(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	expectLocked(mock, appliedRows().AddRow(1, "create_widgets", checksum([]byte("CREATE TABLE widgets ()"), []byte("DROP TABLE widgets")), time.Now()))
+	expectUnlock(mock)
+
+	done, err := New(db, testMigrations()).Up(context.Background())
+	if !errors.Is(err, ErrChecksumMismatch) || len(done) != 0 {
+		t.Fatalf("Up = %v, %v; want %v before applying anything", done, err, ErrChecksumMismatch)
+	}
+}
+
+func TestDownRefusesModifiedDownScript(t *testing.T) {
+	db, mock := newMockDB(t)
+	migrations := testMigrations()
+	edited := testMigrations()
+	edited[1].Down = "DROP TABLE widgets"
+	edited[1].Checksum = checksum([]byte(edited[1].Up), []byte(edited[1].Down))
+
+	expectLocked(mock, appliedRows().
+		AddRow(1, "create_widgets", migrations[0].Checksum, time.Now()).
+		AddRow(2, "add_color", migrations[1].Checksum, time.Now()))
+	expectUnlock(mock)
+
+	if _, err := New(db, edited).Down(context.Background(), 1); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("err = %v, want %v before running the edited script", err, ErrChecksumMismatch)
+	}
+}
+
+func TestUpUpgradesUpOnlyChecksums(t *testing.T) {
+	db, mock := newMockDB(t)
+	migrations := testMigrations()
+
+	// Recorded by a release that checksummed the up file alone.
+	expectLocked(mock, appliedRows().
+		AddRow(1, "create_widgets", upChecksum([]byte(migrations[0].Up)), time.Now()).
+		AddRow(2, "add_color", migrations[1].Checksum, time.Now()))
+	mock.ExpectExec(`UPDATE schema_migrations SET checksum = \$1 WHERE version = \$2`).
+		WithArgs(migrations[0].Checksum, int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUnlock(mock)
+
+	if done, err := New(db, migrations).Up(context.Background()); err != nil || len(done) != 0 {
+		t.Fatalf("Up = %v, %v; want nothing to apply", done, err)
+	}
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	db, mock := newMockDB(t)
+	migrations := testMigrations()
+
+	expectLocked(mock, appliedRows())
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[0].Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[1].Up)).WillReturnError(errors.New("column exists"))
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	done, err := New(db, migrations).Up(context.Background())
+	if err == nil || len(done) != 1 {
+		t.Fatalf("Up = %v, %v; want the first migration applied and the second failed", done, err)
+	}
+}
+
+func TestUpRequiresLock(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WithArgs(int64(42)).WillReturnError(errors.New("canceling statement due to statement timeout"))
+
+	migrator := New(db, testMigrations())
+	migrator.LockID = 42
+	if _, err := migrator.Up(context.Background()); err == nil {
+		t.Fatal("migrated without holding the lock")
+	}
+}
+
+func TestUnlockAfterCancel(t *testing.T) {
+	db, mock := newMockDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	expectLocked(mock, appliedRows())
+	expectUnlock(mock)
+	var unlockCtxErr error
+	db.Callback().Raw().Before("gorm:raw").Register("test:unlock_context", func(tx *gorm.DB) {
+		if strings.Contains(tx.Statement.SQL.String(), "pg_advisory_unlock") {
+			unlockCtxErr = tx.Statement.Context.Err()
+		}
+	})
+
+	// The lock is released even though the caller gave up, e.g. on SIGTERM.
+	err := New(db, testMigrations()).withLock(ctx, func(conn *gorm.DB) error {
+		if _, err := New(db, nil).applied(conn); err != nil {
+			return err
+		}
+		cancel()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+	if unlockCtxErr != nil {
+		t.Errorf("unlocked with a context that is already done: %v", unlockCtxErr)
+	}
+}
+
+func TestDown(t *testing.T) {
+	db, mock := newMockDB(t)
+	migrations := testMigrations()
+
+	expectLocked(mock, appliedRows().
+		AddRow(1, "create_widgets", migrations[0].Checksum, time.Now()).
+		AddRow(2, "add_color", migrations[1].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[1].Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM schema_migrations WHERE version = \$1`).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	done, err := New(db, migrations).Down(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != 2 {
+		t.Errorf("reverted %v, want only the latest migration", done)
+	}
+}
+
+func TestDownRejects(t *testing.T) {
+	migrations := testMigrations()
+	irreversible := testMigrations()
+	irreversible[1].Down = "  \n"
+
+	tests := []struct {
+		name       string
+		migrations []Migration
+		applied    *sqlmock.Rows
+		want       error
+	}{
+		{"irreversible", irreversible, appliedRows().AddRow(2, "add_color", migrations[1].Checksum, time.Now()), ErrIrreversible},
+		{"unknown version", migrations, appliedRows().AddRow(3, "from_newer_build", "checksum", time.Now()), ErrUnknownMigration},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectLocked(mock, tt.applied)
+			expectUnlock(mock)
+
+			if _, err := New(db, tt.migrations).Down(context.Background(), 1); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	db, mock := newMockDB(t)
+	migrations := testMigrations()
+	appliedAt := time.Now()
+
+	expectLocked(mock, appliedRows().
+		AddRow(1, "create_widgets", "edited-since", appliedAt).
+		AddRow(3, "from_newer_build", "checksum", appliedAt))
+	expectUnlock(mock)
+
+	statuses, err := New(db, migrations).Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Status{
+		{Version: 1, Name: "create_widgets", AppliedAt: &appliedAt, Modified: true},
+		{Version: 2, Name: "add_color"},
+		{Version: 3, Name: "from_newer_build", AppliedAt: &appliedAt, Unknown: true},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	for i := range want {
+		got := statuses[i]
+		if got.Version != want[i].Version || got.Name != want[i].Name || got.Modified != want[i].Modified ||
+			got.Unknown != want[i].Unknown || (got.AppliedAt == nil) != (want[i].AppliedAt == nil) {
+			t.Errorf("status %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
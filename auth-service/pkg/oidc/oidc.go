@@ -0,0 +1,335 @@
+// Package oidc is a relying party client for OpenID Connect and plain OAuth2
+// identity providers using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"auth-service/pkg/jwtauth"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds each call to a provider.
+const DefaultTimeout = 10 * time.Second
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNoIdentity     = errors.New("provider returned no subject")
+)
+
+// Provider describes one configured identity provider. When Issuer is set,
+// endpoints missing from the configuration are discovered from
+// <Issuer>/.well-known/openid-configuration and the ID token is required.
+// Without an Issuer the provider is treated as plain OAuth2 and the identity
+// comes from UserInfoURL.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	AuthURL     string
+	TokenURL    string
+	JWKSURL     string
+	UserInfoURL string
+
+	Client *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	keySet     *jwtauth.RemoteKeySet
+}
+
+// Identity is what the provider asserts about the signed-in user.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type idTokenClaims struct {
+	Nonce           string      `json:"nonce"`
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"`
+	Name            string      `json:"name"`
+	AuthorizedParty string      `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: DefaultTimeout}
+}
+
+// IsOIDC reports whether the provider issues ID tokens.
+func (p *Provider) IsOIDC() bool {
+	return p.Issuer != ""
+}
+
+// discover fills in endpoints from the issuer's discovery document once. A
+// failed attempt is retried on the next call.
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered || !p.IsOIDC() {
+		return nil
+	}
+
+	if p.AuthURL == "" || p.TokenURL == "" || p.JWKSURL == "" {
+		var doc discoveryDocument
+		wellKnown := strings.TrimRight(p.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := p.getJSON(ctx, wellKnown, "", &doc); err != nil {
+			return fmt.Errorf("discovery for %s: %w", p.Name, err)
+		}
+		if doc.Issuer != p.Issuer {
+			return fmt.Errorf("discovery for %s: issuer mismatch %q", p.Name, doc.Issuer)
+		}
+
+		p.AuthURL = firstNonEmpty(p.AuthURL, doc.AuthorizationEndpoint)
+		p.TokenURL = firstNonEmpty(p.TokenURL, doc.TokenEndpoint)
+		p.JWKSURL = firstNonEmpty(p.JWKSURL, doc.JWKSURI)
+		p.UserInfoURL = firstNonEmpty(p.UserInfoURL, doc.UserInfoEndpoint)
+	}
+
+	p.keySet = jwtauth.NewRemoteKeySet(p.JWKSURL)
+	p.keySet.Client = p.httpClient()
+	p.discovered = true
+	return nil
+}
+
+// AuthCodeURL returns the provider URL to send the browser to.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.ClientID)
+	values.Set("redirect_uri", p.RedirectURL)
+	values.Set("scope", strings.Join(p.Scopes, " "))
+	values.Set("state", state)
+	values.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	values.Set("code_challenge_method", "S256")
+	if p.IsOIDC() {
+		values.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.AuthURL + separator + values.Encode(), nil
+}
+
+// Authenticate exchanges the authorization code and returns the verified
+// identity of the user.
+func (p *Provider) Authenticate(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	token, err := p.exchange(ctx, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.IsOIDC() {
+		return p.verifyIDToken(token.IDToken, nonce)
+	}
+	return p.userInfo(ctx, token.AccessToken)
+}
+
+func (p *Provider) exchange(ctx context.Context, code, codeVerifier string) (*TokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", resp.Status, token.Error)
+	}
+	return &token, nil
+}
+
+func (p *Provider) verifyIDToken(rawIDToken, nonce string) (*Identity, error) {
+	if rawIDToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims idTokenClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}))
+	if _, err := parser.ParseWithClaims(rawIDToken, &claims, p.keySet.Keyfunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Issuer != p.Issuer || !claims.VerifyAudience(p.ClientID, true) {
+		return nil, ErrInvalidIDToken
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+	if claims.ExpiresAt == nil || nonce == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+	if claims.Subject == "" {
+		return nil, ErrNoIdentity
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: truthy(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// userInfo reads the identity of a plain OAuth2 provider. GitHub-style
+// numeric "id" fields are accepted in place of "sub".
+func (p *Provider) userInfo(ctx context.Context, accessToken string) (*Identity, error) {
+	if p.UserInfoURL == "" {
+		return nil, fmt.Errorf("provider %s has no userinfo endpoint", p.Name)
+	}
+
+	var info map[string]interface{}
+	if err := p.getJSON(ctx, p.UserInfoURL, accessToken, &info); err != nil {
+		return nil, err
+	}
+
+	subject := stringClaim(info["sub"])
+	if subject == "" {
+		subject = stringClaim(info["id"])
+	}
+	if subject == "" {
+		return nil, ErrNoIdentity
+	}
+
+	name := stringClaim(info["name"])
+	if name == "" {
+		name = stringClaim(info["login"])
+	}
+
+	return &Identity{
+		Subject:       subject,
+		Email:         stringClaim(info["email"]),
+		EmailVerified: truthy(info["email_verified"]),
+		Name:          name,
+	}, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// NewCodeVerifier returns a random PKCE code verifier. It doubles as a
+// generator for state and nonce values.
+func NewCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// truthy accepts both boolean and string email_verified claims; some
+// providers send "true".
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
+
+func stringClaim(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return fmt.Sprintf("%.0f", s)
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
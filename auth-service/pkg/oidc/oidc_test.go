@@ -0,0 +1,336 @@
+package oidc
+
+import (
+	"auth-service/pkg/jwtauth"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is an OpenID provider serving discovery, JWKS, token and
+// userinfo endpoints. The token endpoint enforces PKCE against the challenge
+// sent to the authorization endpoint and returns whatever ID token claims the
+// test sets.
+type fakeIssuer struct {
+	*httptest.Server
+	t   *testing.T
+	kid string
+	key *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	challenge string
+	claims    jwt.MapClaims
+	signKid   string
+	userInfo  map[string]interface{}
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{t: t, kid: "issuer-key", key: key, signKid: "issuer-key"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, discoveryDocument{
+			Issuer:                f.URL,
+			AuthorizationEndpoint: f.URL + "/authorize",
+			TokenEndpoint:         f.URL + "/token",
+			JWKSURI:               f.URL + "/jwks",
+			UserInfoEndpoint:      f.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, jwtauth.JWKS{Keys: []jwtauth.JWK{{
+			Kty: "EC",
+			Kid: f.kid,
+			Use: "sig",
+			Alg: "ES256",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer provider-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		writeJSON(w, http.StatusOK, f.userInfo)
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "auth-code" ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != f.challenge {
+		writeJSON(w, http.StatusBadRequest, TokenResponse{Error: "invalid_grant"})
+		return
+	}
+
+	response := TokenResponse{AccessToken: "provider-access-token", TokenType: "Bearer"}
+	if f.claims != nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, f.claims)
+		token.Header["kid"] = f.signKid
+		signed, err := token.SignedString(f.key)
+		if err != nil {
+			f.t.Error(err)
+		}
+		response.IDToken = signed
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// validClaims are the ID token claims for a sign-in with the given nonce.
+func (f *fakeIssuer) validClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            f.URL,
+		"aud":            "shop",
+		"sub":            "provider-user",
+		"nonce":          nonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+		"name":           "User",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func (f *fakeIssuer) provider() *Provider {
+	return &Provider{
+		Name:        "test",
+		Issuer:      f.URL,
+		ClientID:    "shop",
+		RedirectURL: "https://shop.example.com/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+// authorize plays the browser's visit to the authorization endpoint: the
+// issuer remembers the PKCE challenge for the code it hands back.
+func (f *fakeIssuer) authorize(t *testing.T, p *Provider, state, nonce, verifier string) url.Values {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	f.mu.Lock()
+	f.challenge = query.Get("code_challenge")
+	f.mu.Unlock()
+	return query
+}
+
+func (f *fakeIssuer) setClaims(claims jwt.MapClaims) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claims = claims
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	p := issuer.provider()
+
+	query := issuer.authorize(t, p, "state", "nonce", "verifier")
+
+	challenge := sha256.Sum256([]byte("verifier"))
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "shop",
+		"redirect_uri":          "https://shop.example.com/callback",
+		"scope":                 "openid email",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if p.AuthURL != issuer.URL+"/authorize" || p.TokenURL != issuer.URL+"/token" {
+		t.Errorf("endpoints not discovered: %q, %q", p.AuthURL, p.TokenURL)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	p := issuer.provider()
+	issuer.authorize(t, p, "state", "nonce", "verifier")
+	issuer.setClaims(issuer.validClaims("nonce"))
+
+	identity, err := p.Authenticate(context.Background(), "auth-code", "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Subject: "provider-user", Email: "user@example.com", EmailVerified: true, Name: "User"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestAuthenticateRequiresCodeVerifier(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	p := issuer.provider()
+	issuer.authorize(t, p, "state", "nonce", "verifier")
+	issuer.setClaims(issuer.validClaims("nonce"))
+
+	if _, err := p.Authenticate(context.Background(), "auth-code", "stolen-code-without-verifier", "nonce"); err == nil {
+		t.Fatal("code exchanged with the wrong PKCE verifier")
+	}
+}
+
+func TestAuthenticateRejectsIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims, issuer *fakeIssuer)
+		want   error
+	}{
+		{"nonce mismatch", func(c jwt.MapClaims, _ *fakeIssuer) { c["nonce"] = "other-nonce" }, ErrInvalidIDToken},
+		{"missing nonce", func(c jwt.MapClaims, _ *fakeIssuer) { delete(c, "nonce") }, ErrInvalidIDToken},
+		{"other issuer", func(c jwt.MapClaims, _ *fakeIssuer) { c["iss"] = "https://evil.example.com" }, ErrInvalidIDToken},
+		{"other audience", func(c jwt.MapClaims, _ *fakeIssuer) { c["aud"] = "another-client" }, ErrInvalidIDToken},
+		{"shared audience without azp", func(c jwt.MapClaims, _ *fakeIssuer) { c["aud"] = []string{"shop", "another-client"} }, ErrInvalidIDToken},
+		{"expired", func(c jwt.MapClaims, _ *fakeIssuer) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, ErrInvalidIDToken},
+		{"no expiry", func(c jwt.MapClaims, _ *fakeIssuer) { delete(c, "exp") }, ErrInvalidIDToken},
+		{"unknown kid", func(_ jwt.MapClaims, f *fakeIssuer) { f.signKid = "rotated-away" }, ErrInvalidIDToken},
+		{"no subject", func(c jwt.MapClaims, _ *fakeIssuer) { delete(c, "sub") }, ErrNoIdentity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			p := issuer.provider()
+			issuer.authorize(t, p, "state", "nonce", "verifier")
+			claims := issuer.validClaims("nonce")
+			tt.change(claims, issuer)
+			issuer.setClaims(claims)
+
+			if _, err := p.Authenticate(context.Background(), "auth-code", "verifier", "nonce"); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSharedAudienceWithAuthorizedParty(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	p := issuer.provider()
+	issuer.authorize(t, p, "state", "nonce", "verifier")
+	claims := issuer.validClaims("nonce")
+	claims["aud"] = []string{"shop", "another-client"}
+	claims["azp"] = "shop"
+	issuer.setClaims(claims)
+
+	if _, err := p.Authenticate(context.Background(), "auth-code", "verifier", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticateEmailVerified(t *testing.T) {
+	tests := []struct {
+		claim interface{}
+		want  bool
+	}{
+		{true, true},
+		{false, false},
+		{"true", true},
+		{"false", false},
+		{"yes", false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		issuer := newFakeIssuer(t)
+		p := issuer.provider()
+		issuer.authorize(t, p, "state", "nonce", "verifier")
+		claims := issuer.validClaims("nonce")
+		if tt.claim == nil {
+			delete(claims, "email_verified")
+		} else {
+			claims["email_verified"] = tt.claim
+		}
+		issuer.setClaims(claims)
+
+		identity, err := p.Authenticate(context.Background(), "auth-code", "verifier", "nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.EmailVerified != tt.want {
+			t.Errorf("email_verified %#v: verified = %v, want %v", tt.claim, identity.EmailVerified, tt.want)
+		}
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	p := issuer.provider()
+	p.Issuer = issuer.URL + "/"
+
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatal("discovery document for another issuer accepted")
+	}
+	if p.discovered {
+		t.Error("failed discovery was cached")
+	}
+}
+
+func TestPlainOAuth2UserInfo(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	issuer.userInfo = map[string]interface{}{"id": 12345, "login": "octocat", "email": "user@example.com"}
+	p := &Provider{
+		Name:        "github",
+		ClientID:    "shop",
+		AuthURL:     issuer.URL + "/authorize",
+		TokenURL:    issuer.URL + "/token",
+		UserInfoURL: issuer.URL + "/userinfo",
+	}
+
+	query := issuer.authorize(t, p, "state", "nonce", "verifier")
+	if query.Has("nonce") {
+		t.Error("nonce sent to a provider without ID tokens")
+	}
+
+	identity, err := p.Authenticate(context.Background(), "auth-code", "verifier", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Subject: "12345", Email: "user@example.com", Name: "octocat"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
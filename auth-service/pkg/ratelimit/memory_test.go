@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock is advanced by hand so refill can be tested without sleeping.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func take(t *testing.T, store *MemoryStore, key string, limit Limit) Result {
+	t.Helper()
+	result, err := store.Take(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	store, _ := newTestStore()
+	limit := Limit{Requests: 5, Period: time.Minute}
+
+	for i := 0; i < 5; i++ {
+		result := take(t, store, "key", limit)
+		if !result.Allowed {
+			t.Fatalf("request %d of a full bucket denied", i+1)
+		}
+		if result.Remaining != 4-i {
+			t.Errorf("remaining = %d, want %d", result.Remaining, 4-i)
+		}
+	}
+
+	result := take(t, store, "key", limit)
+	if result.Allowed {
+		t.Fatal("request beyond the burst allowed")
+	}
+	// One token refills every 12 seconds.
+	if result.RetryAfter != 12*time.Second {
+		t.Errorf("retry after %v, want 12s", result.RetryAfter)
+	}
+	if result.ResetAfter != time.Minute {
+		t.Errorf("reset after %v, want 1m", result.ResetAfter)
+	}
+
+	if !take(t, store, "other", limit).Allowed {
+		t.Error("separate key shares the exhausted bucket")
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	store, clock := newTestStore()
+	limit := Limit{Requests: 5, Period: time.Minute}
+	for i := 0; i < 5; i++ {
+		take(t, store, "key", limit)
+	}
+
+	clock.Advance(11 * time.Second)
+	if take(t, store, "key", limit).Allowed {
+		t.Fatal("allowed before a whole token refilled")
+	}
+
+	clock.Advance(time.Second)
+	if !take(t, store, "key", limit).Allowed {
+		t.Fatal("denied after a token refilled")
+	}
+	if take(t, store, "key", limit).Allowed {
+		t.Fatal("one refilled token allowed two requests")
+	}
+
+	// Refill is capped at the capacity, however long the bucket was idle.
+	clock.Advance(24 * time.Hour)
+	for i := 0; i < 5; i++ {
+		if !take(t, store, "key", limit).Allowed {
+			t.Fatalf("request %d after a long idle period denied", i+1)
+		}
+	}
+	if take(t, store, "key", limit).Allowed {
+		t.Fatal("idle bucket grew beyond its capacity")
+	}
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	store, clock := newTestStore()
+	limit := Limit{Requests: 5, Period: time.Minute}
+	take(t, store, "idle", limit)
+
+	clock.Advance(sweepInterval)
+	take(t, store, "active", limit)
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("refilled bucket was not swept")
+	}
+	if _, ok := store.buckets["active"]; !ok {
+		t.Error("active bucket was swept")
+	}
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxPeekedBody = 64 << 10
+
+type Limiter struct {
+	store  Store
+	routes map[string][]Rule
+}
+
+type errorResponse struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int64  `json:"retryAfter,omitempty"`
+}
+
+func NewLimiter(store Store, routes map[string][]Rule) *Limiter {
+	return &Limiter{store: store, routes: routes}
+}
+
+// Middleware applies the rules configured for the matched route and lets
+// every other route through. The most restrictive rule is reported in the
+// RateLimit-* headers.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		rules, ok := l.routes[route]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		var email string
+		var tightest *Result
+
+		for _, rule := range rules {
+			var subject string
+			switch rule.Key {
+			case KeyIP:
+				subject = c.ClientIP()
+			case KeyEmail:
+				if email == "" {
+					email = peekEmail(c)
+				}
+				subject = email
+			case KeyRoute:
+				subject = "*"
+			}
+			if subject == "" {
+				continue
+			}
+
+			// The limit is part of the key so that two rules of the same
+			// type, such as a per-minute and a per-hour IP limit, keep
+			// separate buckets.
+			key := fmt.Sprintf("%s:%s:%s:%s", rule.Key, route, rule.Limit, subject)
+			result, err := l.store.Take(c.Request.Context(), key, rule.Limit)
+			if err != nil {
+				log.Printf("rate limiter unavailable, allowing request: %v", err)
+				continue
+			}
+
+			if tightest == nil || !result.Allowed || (tightest.Allowed && result.Remaining < tightest.Remaining) {
+				r := result
+				tightest = &r
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+
+		if tightest == nil {
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		c.Header("RateLimit-Reset", strconv.FormatInt(ceilSeconds(tightest.ResetAfter), 10))
+
+		if !tightest.Allowed {
+			retryAfter := ceilSeconds(tightest.RetryAfter)
+			c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorResponse{
+				Code:       "rate_limited",
+				Message:    "too many requests",
+				RetryAfter: retryAfter,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// peekEmail reads the email field of a JSON body and puts the body back for
+// the handler.
+func peekEmail(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekedBody))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	if err != nil {
+		return ""
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(input.Email))
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestRouter(t *testing.T, spec string) (*gin.Engine, *fakeClock) {
+	t.Helper()
+	routes, err := ParseRoutes(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, clock := newTestStore()
+
+	r := gin.New()
+	r.Use(NewLimiter(store, routes).Middleware())
+	r.POST("/login", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+	r.POST("/open", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r, clock
+}
+
+func post(r *gin.Engine, path, ip, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddlewareLimitsByIP(t *testing.T) {
+	r, _ := newTestRouter(t, "/login=ip:2/1m")
+
+	for i := 0; i < 2; i++ {
+		if w := post(r, "/login", "192.0.2.1", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+
+	w := post(r, "/login", "192.0.2.1", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+
+	if w := post(r, "/login", "192.0.2.2", ""); w.Code != http.StatusOK {
+		t.Errorf("another address was limited: status %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := post(r, "/open", "192.0.2.1", ""); w.Code != http.StatusOK {
+			t.Fatalf("route without rules was limited: status %d", w.Code)
+		}
+	}
+}
+
+func TestMiddlewareKeepsSameTypeRulesApart(t *testing.T) {
+	r, clock := newTestRouter(t, "/login=ip:2/1m,ip:3/1h")
+
+	// The per-minute bucket allows two requests, then refills; the hourly
+	// bucket must still remember all of them.
+	for i := 0; i < 2; i++ {
+		if w := post(r, "/login", "192.0.2.1", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	if w := post(r, "/login", "192.0.2.1", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("per-minute limit not applied: status %d", w.Code)
+	}
+
+	clock.Advance(time.Minute)
+	if w := post(r, "/login", "192.0.2.1", ""); w.Code != http.StatusOK {
+		t.Fatalf("third request in the hour: status %d", w.Code)
+	}
+
+	clock.Advance(time.Minute)
+	w := post(r, "/login", "192.0.2.1", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("hourly limit not applied: status %d", w.Code)
+	}
+	if got := w.Header().Get("RateLimit-Limit"); got != "3" {
+		t.Errorf("RateLimit-Limit = %q, want the hourly rule", got)
+	}
+}
+
+func TestMiddlewareLimitsByEmail(t *testing.T) {
+	r, _ := newTestRouter(t, "/login=email:1/1m")
+	body := `{"email":" User@Example.com ","password":"secret"}`
+
+	w := post(r, "/login", "192.0.2.1", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if w.Body.String() != body {
+		t.Errorf("handler read %q, want the original body", w.Body.String())
+	}
+
+	if w := post(r, "/login", "192.0.2.2", `{"email":"user@example.com"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("same email from another address: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := post(r, "/login", "192.0.2.1", `not json`); w.Code != http.StatusOK {
+		t.Errorf("request without an email was limited: status %d", w.Code)
+	}
+}
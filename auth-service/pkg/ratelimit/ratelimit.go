@@ -0,0 +1,108 @@
+// Package ratelimit provides token-bucket rate limiting for Gin routes with a
+// pluggable storage backend.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type KeyType string
+
+const (
+	KeyIP    KeyType = "ip"
+	KeyEmail KeyType = "email"
+	KeyRoute KeyType = "route"
+)
+
+// Limit allows Requests per Period, refilled continuously. Requests is also
+// the bucket capacity, so a full bucket absorbs a burst of that size.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// String formats the limit as in the route spec, for example "20/1m0s".
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+type Rule struct {
+	Key   KeyType
+	Limit Limit
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// Store keeps the buckets. The in-memory store is enough for one replica; a
+// shared store (for example Redis) must be plugged in when running several.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// ParseRoutes reads per-route rules in the form
+// "/login=ip:20/1m,email:10/1m;/register=ip:10/1h".
+func ParseRoutes(spec string) (map[string][]Rule, error) {
+	routes := make(map[string][]Rule)
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, rules, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit entry %q", entry)
+		}
+
+		for _, raw := range strings.Split(rules, ",") {
+			rule, err := parseRule(strings.TrimSpace(raw))
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate limit for %s: %w", route, err)
+			}
+			routes[strings.TrimSpace(route)] = append(routes[strings.TrimSpace(route)], rule)
+		}
+	}
+
+	return routes, nil
+}
+
+func parseRule(raw string) (Rule, error) {
+	key, limit, found := strings.Cut(raw, ":")
+	if !found {
+		return Rule{}, fmt.Errorf("missing key type in %q", raw)
+	}
+
+	switch KeyType(key) {
+	case KeyIP, KeyEmail, KeyRoute:
+	default:
+		return Rule{}, fmt.Errorf("unknown key type %q", key)
+	}
+
+	count, period, found := strings.Cut(limit, "/")
+	if !found {
+		return Rule{}, fmt.Errorf("missing period in %q", raw)
+	}
+
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests <= 0 {
+		return Rule{}, fmt.Errorf("invalid request count in %q", raw)
+	}
+
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		return Rule{}, fmt.Errorf("invalid period in %q", raw)
+	}
+
+	return Rule{Key: KeyType(key), Limit: Limit{Requests: requests, Period: duration}}, nil
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes(" /login=ip:20/1m, email:10/1m ; /register=ip:10/1h;;/password/reset=ip:10/1m,ip:30/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]Rule{
+		"/login": {
+			{Key: KeyIP, Limit: Limit{Requests: 20, Period: time.Minute}},
+			{Key: KeyEmail, Limit: Limit{Requests: 10, Period: time.Minute}},
+		},
+		"/register": {
+			{Key: KeyIP, Limit: Limit{Requests: 10, Period: time.Hour}},
+		},
+		"/password/reset": {
+			{Key: KeyIP, Limit: Limit{Requests: 10, Period: time.Minute}},
+			{Key: KeyIP, Limit: Limit{Requests: 30, Period: time.Hour}},
+		},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("routes = %+v, want %+v", routes, want)
+	}
+}
+
+func TestParseRoutesRejectsInvalidRules(t *testing.T) {
+	for _, spec := range []string{
+		"/login",
+		"/login=20/1m",
+		"/login=user:20/1m",
+		"/login=ip:20",
+		"/login=ip:0/1m",
+		"/login=ip:-1/1m",
+		"/login=ip:many/1m",
+		"/login=ip:20/soon",
+		"/login=ip:20/0s",
+	} {
+		if _, err := ParseRoutes(spec); err == nil {
+			t.Errorf("ParseRoutes(%q) succeeded", spec)
+		}
+	}
+}
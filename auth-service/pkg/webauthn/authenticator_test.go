@@ -0,0 +1,195 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+// cborPair and cborMap keep map keys in a fixed order when encoding.
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+type cborMap []cborPair
+
+// encodeCBOR encodes the values the software authenticator needs.
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair.key)...)
+			out = append(out, encodeCBOR(pair.value)...)
+		}
+		return out
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	}
+	panic("unsupported CBOR value")
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}
+
+// softAuthenticator behaves like a platform authenticator that holds a
+// single credential.
+type softAuthenticator struct {
+	t            *testing.T
+	alg          int64
+	signer       crypto.Signer
+	credentialID []byte
+	rpID         string
+	origin       string
+	signCount    uint32
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	t.Helper()
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{
+		t:            t,
+		alg:          alg,
+		signer:       signer,
+		credentialID: id,
+		rpID:         "shop.example.com",
+		origin:       "https://shop.example.com",
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	switch key := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return encodeCBOR(cborMap{
+			{coseKty, coseKtyEC2}, {coseAlg, AlgES256}, {-1, coseCrvP256},
+			{-2, key.X.FillBytes(make([]byte, 32))}, {-3, key.Y.FillBytes(make([]byte, 32))},
+		})
+	case ed25519.PublicKey:
+		return encodeCBOR(cborMap{{coseKty, coseKtyOKP}, {coseAlg, AlgEdDSA}, {-1, coseCrvEd25519}, {-2, []byte(key)}})
+	case *rsa.PublicKey:
+		return encodeCBOR(cborMap{
+			{coseKty, coseKtyRSA}, {coseAlg, AlgRS256},
+			{-1, key.N.Bytes()}, {-2, big.NewInt(int64(key.E)).Bytes()},
+		})
+	}
+	a.t.Fatal("unsupported key")
+	return nil
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, err := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+// register answers navigator.credentials.create() with "none" attestation.
+func (a *softAuthenticator) register(challenge string) *RegistrationResponse {
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.coseKey()...)
+
+	attestation := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authData(a.flags|flagAttestedData, attested)},
+	})
+
+	resp := &RegistrationResponse{ID: EncodeBase64URL(a.credentialID), RawID: EncodeBase64URL(a.credentialID), Type: PublicKeyType}
+	resp.Response.ClientDataJSON = EncodeBase64URL(a.clientData(CeremonyCreate, challenge))
+	resp.Response.AttestationObject = EncodeBase64URL(attestation)
+	resp.Response.Transports = []string{"internal"}
+	return resp
+}
+
+// assert answers navigator.credentials.get(), bumping the signature counter.
+func (a *softAuthenticator) assert(challenge string, userHandle []byte) *AssertionResponse {
+	a.signCount++
+	return a.signAssertion(a.authData(a.flags, nil), a.clientData(CeremonyGet, challenge), userHandle)
+}
+
+func (a *softAuthenticator) signAssertion(authData, clientDataJSON, userHandle []byte) *AssertionResponse {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	message := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var signature []byte
+	var err error
+	if _, ok := a.signer.(ed25519.PrivateKey); ok {
+		signature, err = a.signer.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		signature, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	resp := &AssertionResponse{ID: EncodeBase64URL(a.credentialID), RawID: EncodeBase64URL(a.credentialID), Type: PublicKeyType}
+	resp.Response.ClientDataJSON = EncodeBase64URL(clientDataJSON)
+	resp.Response.AuthenticatorData = EncodeBase64URL(authData)
+	resp.Response.Signature = EncodeBase64URL(signature)
+	if userHandle != nil {
+		resp.Response.UserHandle = EncodeBase64URL(userHandle)
+	}
+	return resp
+}
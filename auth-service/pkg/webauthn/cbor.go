@@ -0,0 +1,122 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedCBOR = errors.New("malformed CBOR")
+
+// maxCBORDepth bounds nesting so a crafted attestation cannot exhaust the
+// stack.
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the subset of CBOR used by WebAuthn: integers, byte and
+// text strings, arrays, maps and simple values. It returns the value and the
+// number of bytes consumed, since authenticator data appends a COSE key and
+// extensions back to back.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, int, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, 0, errMalformedCBOR
+	}
+
+	major := data[0] >> 5
+	arg, n, err := cborArgument(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, errMalformedCBOR
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, errMalformedCBOR
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, errMalformedCBOR
+		}
+		end := n + int(arg)
+		if major == 2 {
+			return append([]byte(nil), data[n:end]...), end, nil
+		}
+		return string(data[n:end]), end, nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, 0, errMalformedCBOR
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += used
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, 0, errMalformedCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errMalformedCBOR
+			}
+
+			value, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			m[key] = value
+		}
+		return m, n, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22, 23:
+			return nil, n, nil
+		}
+	}
+
+	// Tags, floats and indefinite lengths never appear in WebAuthn payloads.
+	return nil, 0, errMalformedCBOR
+}
+
+func cborArgument(data []byte) (uint64, int, error) {
+	info := data[0] & 0x1f
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), 2, nil
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:3])), 3, nil
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:5])), 5, nil
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:9]), 9, nil
+	}
+	return 0, 0, errMalformedCBOR
+}
@@ -0,0 +1,101 @@
+package webauthn
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want interface{}
+	}{
+		{[]byte{0x00}, int64(0)},
+		{[]byte{0x17}, int64(23)},
+		{[]byte{0x18, 0x18}, int64(24)},
+		{[]byte{0x19, 0x01, 0x00}, int64(256)},
+		{[]byte{0x20}, int64(-1)},
+		{[]byte{0x38, 0x18}, int64(-25)},
+		{[]byte{0x39, 0x01, 0x00}, int64(-257)},
+		{[]byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{[]byte{0x63, 'f', 'm', 't'}, "fmt"},
+		{[]byte{0x82, 0x01, 0x61, 'a'}, []interface{}{int64(1), "a"}},
+		{[]byte{0xa1, 0x01, 0x02}, map[interface{}]interface{}{int64(1): int64(2)}},
+		{[]byte{0xf4}, false},
+		{[]byte{0xf5}, true},
+		{[]byte{0xf6}, nil},
+	}
+
+	for _, tt := range tests {
+		got, n, err := decodeCBOR(tt.data)
+		if err != nil {
+			t.Errorf("decodeCBOR(%x): %v", tt.data, err)
+			continue
+		}
+		if n != len(tt.data) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeCBOR(%x) = %#v, %d; want %#v, %d", tt.data, got, n, tt.want, len(tt.data))
+		}
+	}
+}
+
+func TestDecodeCBORReportsConsumedLength(t *testing.T) {
+	key := encodeCBOR(cborMap{{1, 2}})
+	_, n, err := decodeCBOR(append(key, 0xa0, 0xff))
+	if err != nil || n != len(key) {
+		t.Fatalf("consumed %d bytes, err %v; want %d", n, err, len(key))
+	}
+}
+
+func TestDecodeCBORRejectsMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":                    {},
+		"truncated argument":       {0x19, 0x01},
+		"byte string past end":     {0x45, 1, 2},
+		"huge byte string":         {0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge array":               {0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge map":                 {0xbb, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"array missing items":      {0x83, 0x01},
+		"map missing value":        {0xa1, 0x01},
+		"byte string map key":      {0xa1, 0x41, 0x00, 0x01},
+		"integer overflow":         {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"negative overflow":        {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"indefinite length":        {0x5f, 0x41, 0x00, 0xff},
+		"tag":                      {0xc0, 0x00},
+		"float":                    {0xf9, 0x00, 0x00},
+		"reserved additional info": {0x1c},
+		"nested too deep":          append(bytes.Repeat([]byte{0x81}, maxCBORDepth+2), 0x00),
+	}
+
+	for name, data := range tests {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: %x decoded without error", name, data)
+		}
+	}
+}
+
+func TestDecodeCBORTruncatedAttestation(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgRS256)
+	resp := authenticator.register("challenge")
+	attestation, _ := DecodeBase64URL(resp.Response.AttestationObject)
+
+	for n := 0; n < len(attestation); n++ {
+		if _, _, err := decodeCBOR(attestation[:n]); err == nil {
+			t.Fatalf("attestation truncated to %d of %d bytes decoded", n, len(attestation))
+		}
+	}
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	f.Add([]byte{0xa1, 0x01, 0x02})
+	f.Add(encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", []byte{1, 2, 3}}}))
+	f.Add(bytes.Repeat([]byte{0x81}, 64))
+	f.Add([]byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, n, err := decodeCBOR(data)
+		if err == nil && (n <= 0 || n > len(data)) {
+			t.Fatalf("consumed %d of %d bytes", n, len(data))
+		}
+	})
+}
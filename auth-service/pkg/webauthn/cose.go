@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers accepted for new credentials.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported credential public key")
+	ErrBadSignature   = errors.New("signature verification failed")
+)
+
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// PublicKey is a credential public key decoded from its COSE form.
+type PublicKey struct {
+	Algorithm int64
+	key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key as stored for a credential.
+func ParsePublicKey(cose []byte) (*PublicKey, error) {
+	value, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	return publicKeyFromCOSE(value)
+}
+
+func publicKeyFromCOSE(value interface{}) (*PublicKey, error) {
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256 && crv == coseCrvP256:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrUnsupportedKey
+		}
+		return &PublicKey{Algorithm: alg, key: key}, nil
+
+	case kty == coseKtyOKP && alg == AlgEdDSA && crv == coseCrvEd25519:
+		x, _ := m[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return &PublicKey{Algorithm: alg, key: ed25519.PublicKey(x)}, nil
+
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		return &PublicKey{Algorithm: alg, key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// Verify checks an assertion signature over the given message.
+func (k *PublicKey) Verify(message, signature []byte) error {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, message, signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
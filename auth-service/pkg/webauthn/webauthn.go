@@ -0,0 +1,429 @@
+// Package webauthn implements the relying party side of WebAuthn registration
+// and assertion ceremonies for passkeys. Attestation statements are not
+// verified: options always request "none" attestation, so only the credential
+// public key is trusted.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidResponse   = errors.New("invalid WebAuthn response")
+	ErrChallengeMismatch = errors.New("challenge mismatch")
+	ErrOriginMismatch    = errors.New("origin not allowed")
+	ErrRPIDMismatch      = errors.New("relying party ID mismatch")
+	ErrUserNotPresent    = errors.New("user presence flag not set")
+	ErrUserNotVerified   = errors.New("user verification required")
+)
+
+const (
+	PublicKeyType = "public-key"
+
+	CeremonyCreate = "webauthn.create"
+	CeremonyGet    = "webauthn.get"
+
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedData       = 0x40
+	authDataMinLength      = 37
+	challengeBytes         = 32
+	defaultCeremonyTimeout = 5 * time.Minute
+)
+
+// RelyingParty holds the identity browsers bind credentials to. Origins lists
+// every web origin allowed to run ceremonies, e.g. "https://shop.example.com".
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	Timeout time.Duration
+}
+
+func (rp *RelyingParty) timeout() time.Duration {
+	if rp.Timeout > 0 {
+		return rp.Timeout
+	}
+	return defaultCeremonyTimeout
+}
+
+// NewChallenge returns a random base64url challenge for a single ceremony.
+func NewChallenge() (string, error) {
+	b := make([]byte, challengeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions builds registration options. Resident keys are preferred
+// so the credential can later sign in without a username.
+func (rp *RelyingParty) CreationOptions(challenge string, user UserEntity, exclude []CredentialDescriptor) CreationOptions {
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RPEntity{ID: rp.ID, Name: rp.Name},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: PublicKeyType, Alg: AlgES256},
+			{Type: PublicKeyType, Alg: AlgEdDSA},
+			{Type: PublicKeyType, Alg: AlgRS256},
+		},
+		Timeout:            rp.timeout().Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: UserVerificationPreferred,
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds assertion options. An empty allow list lets the
+// browser offer any discoverable credential for this relying party.
+func (rp *RelyingParty) RequestOptions(challenge string, allow []CredentialDescriptor, userVerification string) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.timeout().Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// RegistrationResponse is the JSON form of a PublicKeyCredential returned by
+// navigator.credentials.create(), with binary fields base64url encoded.
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of a PublicKeyCredential returned by
+// navigator.credentials.get().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Credential is a newly registered credential ready to be stored.
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte
+	Algorithm  int64
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+}
+
+// Assertion is the verified result of a sign-in ceremony.
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Challenge returns the challenge the client signed, so the caller can load
+// the matching ceremony state before verifying.
+func (r *RegistrationResponse) Challenge() (string, error) {
+	_, data, err := decodeClientData(r.Response.ClientDataJSON)
+	if err != nil {
+		return "", err
+	}
+	return data.Challenge, nil
+}
+
+func (a *AssertionResponse) Challenge() (string, error) {
+	_, data, err := decodeClientData(a.Response.ClientDataJSON)
+	if err != nil {
+		return "", err
+	}
+	return data.Challenge, nil
+}
+
+func (a *AssertionResponse) CredentialID() ([]byte, error) {
+	return DecodeBase64URL(a.RawID)
+}
+
+// VerifyRegistration checks a create() response against the challenge that
+// was issued for it and extracts the credential.
+func (rp *RelyingParty) VerifyRegistration(resp *RegistrationResponse, challenge string, requireUserVerification bool) (*Credential, error) {
+	if resp.Type != PublicKeyType {
+		return nil, ErrInvalidResponse
+	}
+
+	if _, err := rp.verifyClientData(resp.Response.ClientDataJSON, CeremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := DecodeBase64URL(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	value, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	attestation, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+
+	flags, signCount, err := rp.verifyAuthenticatorData(authData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagAttestedData == 0 || len(authData) < authDataMinLength+18 {
+		return nil, ErrInvalidResponse
+	}
+
+	rest := authData[authDataMinLength:]
+	aaguid := rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, ErrInvalidResponse
+	}
+	credentialID := rest[:idLength]
+	rest = rest[idLength:]
+
+	keyValue, keyLength, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	publicKey, err := publicKeyFromCOSE(keyValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if rawID, err := DecodeBase64URL(resp.RawID); err != nil || !bytes.Equal(rawID, credentialID) {
+		return nil, ErrInvalidResponse
+	}
+
+	return &Credential{
+		ID:         append([]byte(nil), credentialID...),
+		PublicKey:  append([]byte(nil), rest[:keyLength]...),
+		Algorithm:  publicKey.Algorithm,
+		SignCount:  signCount,
+		AAGUID:     append([]byte(nil), aaguid...),
+		Transports: resp.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion checks a get() response against the issued challenge and
+// the stored COSE public key of the credential it claims to come from.
+// Callers must still compare SignCount with the stored counter.
+func (rp *RelyingParty) VerifyAssertion(resp *AssertionResponse, challenge string, publicKey []byte, requireUserVerification bool) (*Assertion, error) {
+	if resp.Type != PublicKeyType {
+		return nil, ErrInvalidResponse
+	}
+
+	clientDataJSON, err := rp.verifyClientData(resp.Response.ClientDataJSON, CeremonyGet, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	authData, err := DecodeBase64URL(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	flags, signCount, err := rp.verifyAuthenticatorData(authData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := DecodeBase64URL(resp.Response.Signature)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	message := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if err := key.Verify(message, signature); err != nil {
+		return nil, err
+	}
+
+	credentialID, err := resp.CredentialID()
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+
+	var userHandle []byte
+	if resp.Response.UserHandle != "" {
+		if userHandle, err = DecodeBase64URL(resp.Response.UserHandle); err != nil {
+			return nil, ErrInvalidResponse
+		}
+	}
+
+	return &Assertion{
+		CredentialID: credentialID,
+		UserHandle:   userHandle,
+		SignCount:    signCount,
+		UserVerified: flags&flagUserVerified != 0,
+	}, nil
+}
+
+func (rp *RelyingParty) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	raw, data, err := decodeClientData(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Type != ceremony {
+		return nil, ErrInvalidResponse
+	}
+	if challenge == "" || strings.TrimRight(data.Challenge, "=") != strings.TrimRight(challenge, "=") {
+		return nil, ErrChallengeMismatch
+	}
+	if !rp.allowedOrigin(data.Origin) {
+		return nil, ErrOriginMismatch
+	}
+
+	return raw, nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData []byte, requireUserVerification bool) (byte, uint32, error) {
+	if len(authData) < authDataMinLength {
+		return 0, 0, ErrInvalidResponse
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return 0, 0, ErrRPIDMismatch
+	}
+
+	flags := authData[32]
+	if flags&flagUserPresent == 0 {
+		return 0, 0, ErrUserNotPresent
+	}
+	if requireUserVerification && flags&flagUserVerified == 0 {
+		return 0, 0, ErrUserNotVerified
+	}
+
+	return flags, binary.BigEndian.Uint32(authData[33:37]), nil
+}
+
+func (rp *RelyingParty) allowedOrigin(origin string) bool {
+	for _, allowed := range rp.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeClientData(encoded string) ([]byte, *clientData, error) {
+	raw, err := DecodeBase64URL(encoded)
+	if err != nil {
+		return nil, nil, ErrInvalidResponse
+	}
+
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, nil, ErrInvalidResponse
+	}
+	return raw, &data, nil
+}
+
+// DecodeBase64URL accepts base64url with or without padding, as browsers and
+// client libraries disagree on it.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func EncodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
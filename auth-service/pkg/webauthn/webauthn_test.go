@@ -0,0 +1,261 @@
+package webauthn
+
+import (
+	"bytes"
+	"testing"
+)
+
+var algorithms = []struct {
+	name string
+	alg  int64
+}{
+	{"ES256", AlgES256},
+	{"EdDSA", AlgEdDSA},
+	{"RS256", AlgRS256},
+}
+
+func testRelyingParty() *RelyingParty {
+	return &RelyingParty{ID: "shop.example.com", Name: "Shop", Origins: []string{"https://shop.example.com"}}
+}
+
+func newChallenge(t *testing.T) string {
+	t.Helper()
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func registered(t *testing.T, alg int64) (*softAuthenticator, *Credential) {
+	t.Helper()
+	authenticator := newSoftAuthenticator(t, alg)
+	challenge := newChallenge(t)
+	credential, err := testRelyingParty().VerifyRegistration(authenticator.register(challenge), challenge, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator, credential
+}
+
+func TestRegistrationAndAssertionRoundTrip(t *testing.T) {
+	for _, tt := range algorithms {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, credential := registered(t, tt.alg)
+			if !bytes.Equal(credential.ID, authenticator.credentialID) {
+				t.Errorf("credential id = %x, want %x", credential.ID, authenticator.credentialID)
+			}
+			if credential.Algorithm != tt.alg {
+				t.Errorf("algorithm = %d, want %d", credential.Algorithm, tt.alg)
+			}
+			if _, err := ParsePublicKey(credential.PublicKey); err != nil {
+				t.Fatalf("stored public key does not parse: %v", err)
+			}
+
+			userHandle := []byte("user-handle")
+			for i := uint32(1); i <= 2; i++ {
+				challenge := newChallenge(t)
+				assertion, err := testRelyingParty().VerifyAssertion(authenticator.assert(challenge, userHandle), challenge, credential.PublicKey, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if assertion.SignCount != i || !assertion.UserVerified {
+					t.Errorf("assertion = %+v, want sign count %d and user verified", assertion, i)
+				}
+				if !bytes.Equal(assertion.UserHandle, userHandle) || !bytes.Equal(assertion.CredentialID, credential.ID) {
+					t.Errorf("assertion = %+v", assertion)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	challenge := "expected-challenge"
+	tests := []struct {
+		name   string
+		change func(a *softAuthenticator)
+		resp   func(r *RegistrationResponse)
+		want   error
+	}{
+		{"other challenge", nil, func(r *RegistrationResponse) {
+			r.Response.ClientDataJSON = EncodeBase64URL([]byte(`{"type":"webauthn.create","challenge":"other","origin":"https://shop.example.com"}`))
+		}, ErrChallengeMismatch},
+		{"other origin", func(a *softAuthenticator) { a.origin = "https://evil.example.com" }, nil, ErrOriginMismatch},
+		{"other relying party", func(a *softAuthenticator) { a.rpID = "evil.example.com" }, nil, ErrRPIDMismatch},
+		{"assertion client data", nil, func(r *RegistrationResponse) {
+			r.Response.ClientDataJSON = EncodeBase64URL([]byte(`{"type":"webauthn.get","challenge":"` + challenge + `","origin":"https://shop.example.com"}`))
+		}, ErrInvalidResponse},
+		{"credential type", nil, func(r *RegistrationResponse) { r.Type = "password" }, ErrInvalidResponse},
+		{"user not present", func(a *softAuthenticator) { a.flags = flagUserVerified }, nil, ErrUserNotPresent},
+		{"user not verified", func(a *softAuthenticator) { a.flags = flagUserPresent }, nil, ErrUserNotVerified},
+		{"raw id differs", nil, func(r *RegistrationResponse) { r.RawID = EncodeBase64URL([]byte("another-id")) }, ErrInvalidResponse},
+		{"not base64", nil, func(r *RegistrationResponse) { r.Response.AttestationObject = "***" }, ErrInvalidResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, AlgES256)
+			if tt.change != nil {
+				tt.change(authenticator)
+			}
+			resp := authenticator.register(challenge)
+			if tt.resp != nil {
+				tt.resp(resp)
+			}
+
+			if _, err := testRelyingParty().VerifyRegistration(resp, challenge, true); err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationWithoutUserVerification(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgES256)
+	authenticator.flags = flagUserPresent
+	challenge := newChallenge(t)
+
+	if _, err := testRelyingParty().VerifyRegistration(authenticator.register(challenge), challenge, false); err != nil {
+		t.Fatalf("presence alone rejected when verification is not required: %v", err)
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	for _, alg := range algorithms {
+		t.Run(alg.name, func(t *testing.T) {
+			authenticator, credential := registered(t, alg.alg)
+			_, other := registered(t, alg.alg)
+			challenge := "expected-challenge"
+			rp := testRelyingParty()
+
+			tests := []struct {
+				name      string
+				resp      func() *AssertionResponse
+				publicKey []byte
+				want      error
+			}{
+				{"other challenge", func() *AssertionResponse { return authenticator.assert("other", nil) }, credential.PublicKey, ErrChallengeMismatch},
+				{"other origin", func() *AssertionResponse {
+					authenticator.origin = "https://evil.example.com"
+					defer func() { authenticator.origin = "https://shop.example.com" }()
+					return authenticator.assert(challenge, nil)
+				}, credential.PublicKey, ErrOriginMismatch},
+				{"other relying party", func() *AssertionResponse {
+					authenticator.rpID = "evil.example.com"
+					defer func() { authenticator.rpID = "shop.example.com" }()
+					return authenticator.assert(challenge, nil)
+				}, credential.PublicKey, ErrRPIDMismatch},
+				{"registration client data", func() *AssertionResponse {
+					return authenticator.signAssertion(authenticator.authData(authenticator.flags, nil), authenticator.clientData(CeremonyCreate, challenge), nil)
+				}, credential.PublicKey, ErrInvalidResponse},
+				{"user not present", func() *AssertionResponse {
+					return authenticator.signAssertion(authenticator.authData(flagUserVerified, nil), authenticator.clientData(CeremonyGet, challenge), nil)
+				}, credential.PublicKey, ErrUserNotPresent},
+				{"user not verified", func() *AssertionResponse {
+					return authenticator.signAssertion(authenticator.authData(flagUserPresent, nil), authenticator.clientData(CeremonyGet, challenge), nil)
+				}, credential.PublicKey, ErrUserNotVerified},
+				{"other credential's key", func() *AssertionResponse { return authenticator.assert(challenge, nil) }, other.PublicKey, ErrBadSignature},
+				{"tampered counter", func() *AssertionResponse {
+					resp := authenticator.assert(challenge, nil)
+					authData, _ := DecodeBase64URL(resp.Response.AuthenticatorData)
+					authData[36]++
+					resp.Response.AuthenticatorData = EncodeBase64URL(authData)
+					return resp
+				}, credential.PublicKey, ErrBadSignature},
+				{"credential type", func() *AssertionResponse {
+					resp := authenticator.assert(challenge, nil)
+					resp.Type = "password"
+					return resp
+				}, credential.PublicKey, ErrInvalidResponse},
+				{"truncated authenticator data", func() *AssertionResponse {
+					resp := authenticator.assert(challenge, nil)
+					resp.Response.AuthenticatorData = EncodeBase64URL(make([]byte, authDataMinLength-1))
+					return resp
+				}, credential.PublicKey, ErrInvalidResponse},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					if _, err := rp.VerifyAssertion(tt.resp(), challenge, tt.publicKey, true); err != tt.want {
+						t.Fatalf("err = %v, want %v", err, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionAsSecondFactor(t *testing.T) {
+	authenticator, credential := registered(t, AlgES256)
+	authenticator.flags = flagUserPresent
+	challenge := newChallenge(t)
+
+	assertion, err := testRelyingParty().VerifyAssertion(authenticator.assert(challenge, nil), challenge, credential.PublicKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.UserVerified || assertion.UserHandle != nil {
+		t.Errorf("assertion = %+v, want neither user verification nor a user handle", assertion)
+	}
+}
+
+func TestVerifyRegistrationSurvivesTruncation(t *testing.T) {
+	for _, alg := range algorithms {
+		authenticator := newSoftAuthenticator(t, alg.alg)
+		challenge := newChallenge(t)
+		resp := authenticator.register(challenge)
+		attestation, _ := DecodeBase64URL(resp.Response.AttestationObject)
+
+		for n := 0; n < len(attestation); n++ {
+			resp.Response.AttestationObject = EncodeBase64URL(attestation[:n])
+			if _, err := testRelyingParty().VerifyRegistration(resp, challenge, true); err == nil {
+				t.Fatalf("%s: attestation truncated to %d of %d bytes accepted", alg.name, n, len(attestation))
+			}
+		}
+	}
+}
+
+func TestParsePublicKeyRejects(t *testing.T) {
+	offCurve := bytes.Repeat([]byte{1}, 32)
+	tests := map[string][]byte{
+		"empty":                nil,
+		"not a map":            encodeCBOR("key"),
+		"unknown algorithm":    encodeCBOR(cborMap{{coseKty, coseKtyEC2}, {coseAlg, -35}, {-1, coseCrvP256}, {-2, offCurve}, {-3, offCurve}}),
+		"point not on curve":   encodeCBOR(cborMap{{coseKty, coseKtyEC2}, {coseAlg, AlgES256}, {-1, coseCrvP256}, {-2, offCurve}, {-3, offCurve}}),
+		"short coordinates":    encodeCBOR(cborMap{{coseKty, coseKtyEC2}, {coseAlg, AlgES256}, {-1, coseCrvP256}, {-2, []byte{1}}, {-3, []byte{1}}}),
+		"short Ed25519 key":    encodeCBOR(cborMap{{coseKty, coseKtyOKP}, {coseAlg, AlgEdDSA}, {-1, coseCrvEd25519}, {-2, []byte{1, 2, 3}}}),
+		"1024-bit RSA":         encodeCBOR(cborMap{{coseKty, coseKtyRSA}, {coseAlg, AlgRS256}, {-1, bytes.Repeat([]byte{0xff}, 128)}, {-2, []byte{1, 0, 1}}}),
+		"RSA without exponent": encodeCBOR(cborMap{{coseKty, coseKtyRSA}, {coseAlg, AlgRS256}, {-1, bytes.Repeat([]byte{0xff}, 256)}}),
+	}
+
+	for name, cose := range tests {
+		if _, err := ParsePublicKey(cose); err == nil {
+			t.Errorf("%s: key accepted", name)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	rp := testRelyingParty()
+	creation := rp.CreationOptions("challenge", UserEntity{ID: "id", Name: "user"}, nil)
+	if creation.Attestation != "none" || creation.ExcludeCredentials == nil || len(creation.PubKeyCredParams) != 3 {
+		t.Errorf("creation options = %+v", creation)
+	}
+
+	request := rp.RequestOptions("challenge", nil, UserVerificationRequired)
+	if request.RPID != rp.ID || request.AllowCredentials == nil || request.Timeout != defaultCeremonyTimeout.Milliseconds() {
+		t.Errorf("request options = %+v", request)
+	}
+}
+
+func TestChallengeAcceptsPadding(t *testing.T) {
+	authenticator, credential := registered(t, AlgES256)
+	challenge := newChallenge(t)
+
+	// Some browsers pad the challenge they echo back in clientDataJSON.
+	resp := authenticator.assert(challenge+"=", nil)
+	if _, err := testRelyingParty().VerifyAssertion(resp, challenge, credential.PublicKey, true); err != nil {
+		t.Fatalf("padded challenge rejected: %v", err)
+	}
+}
@@ -1,39 +1,73 @@
 package repositories
 
 import (
+	"auth-service/config"
 	"auth-service/models"
+	"auth-service/pkg/webauthn"
 	"auth-service/utils"
 	"database/sql"
 	"errors"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"log"
 	"strings"
 	"time"
 )
 
 type AuthRepository struct {
-	DB *gorm.DB
+	DB           *gorm.DB
+	Lockout      utils.LockoutPolicy
+	RelyingParty *webauthn.RelyingParty
 }
 
 var _ AuthRepositoryInterface = (*AuthRepository)(nil)
 
 func NewAuthRepository(db *gorm.DB) *AuthRepository {
-	return &AuthRepository{DB: db}
+	return &AuthRepository{
+		DB:           db,
+		Lockout:      utils.LockoutPolicyFromConfig(),
+		RelyingParty: utils.WebAuthnRelyingParty(),
+	}
+}
+
+func (r *AuthRepository) Register(name, email, password string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	user, err := r.CreateUser(name, email, password, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if utils.RequireEmailVerification() {
+		return &models.AuthenticationResult{User: user}, nil
+	}
+
+	return r.issueTokens(user, uuid.Nil, client)
 }
 
-func (r *AuthRepository) Register(name, email, password string) (*models.AuthenticationResult, error) {
+// CreateUser adds a user without signing them in. Operators creating
+// accounts can vouch for the email address with emailVerified.
+func (r *AuthRepository) CreateUser(name, email, password string, emailVerified bool) (*models.User, error) {
+	if err := utils.ValidatePassword(password); err != nil {
+		return nil, err
+	}
+
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, models.New("hashing_failed", "failed to hash password")
 	}
 
+	var verifiedAt *time.Time
+	if emailVerified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+
 	var user models.User
 	err = r.DB.Raw(`
-		INSERT INTO users (name, email, password_hash) 
-		VALUES (?, ?, ?) 
-		RETURNING id, name, email, created_at, updated_at`,
-		name, email, string(passwordHash)).Scan(&user).Error
+		INSERT INTO users (name, email, password_hash, email_verified_at) 
+		VALUES (?, ?, ?, ?) 
+		RETURNING id, name, email, email_verified_at, created_at, updated_at`,
+		name, email, string(passwordHash), verifiedAt).Scan(&user).Error
 
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") {
@@ -42,28 +76,17 @@ func (r *AuthRepository) Register(name, email, password string) (*models.Authent
 		return nil, models.New("database_error", "failed to create user")
 	}
 
-	accessToken, accessExp, err := utils.GenerateAccessToken(&user)
-	if err != nil {
-		return nil, models.New("token_generate_failed", "failed to generate access token")
-	}
+	return &user, nil
+}
 
-	refreshToken, err := r.generateAndStoreRefreshToken(user.ID)
-	if err != nil {
+func (r *AuthRepository) Login(email, password string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	if err := r.checkLoginThrottle(email, client.IPAddress); err != nil {
 		return nil, err
 	}
 
-	return &models.AuthenticationResult{
-		User:              &user,
-		AccessToken:       accessToken,
-		AccessTokenExpiry: accessExp,
-		RefreshToken:      refreshToken,
-	}, nil
-}
-
-func (r *AuthRepository) Login(email, password string) (*models.AuthenticationResult, error) {
 	var user models.User
 	err := r.DB.Raw(`
-		SELECT id, name, email, password_hash, created_at, updated_at
+		SELECT id, name, email, password_hash, email_verified_at, created_at, updated_at
 		FROM users WHERE email = ?`, email).Scan(&user).Error
 
 	if err != nil {
@@ -74,93 +97,615 @@ func (r *AuthRepository) Login(email, password string) (*models.AuthenticationRe
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		r.recordFailedLogin(email, client)
 		return nil, models.ErrInvalidCredentials
 	}
 
-	accessToken, accessExp, err := utils.GenerateAccessToken(&user)
+	methods, err := r.secondFactors(user.ID)
 	if err != nil {
-		return nil, models.New("token_generate_failed", "failed to generate access token")
+		return nil, err
+	}
+
+	// With a second factor enabled the failure counter is only cleared once
+	// that factor succeeds, otherwise a known password would allow unlimited
+	// code guesses.
+	if len(methods) == 0 {
+		r.resetLoginThrottle(email)
+	}
+
+	if user.EmailVerifiedAt == nil && utils.RequireEmailVerification() {
+		return nil, models.ErrEmailNotVerified
+	}
+
+	if len(methods) > 0 {
+		user.PasswordHash = ""
+		return r.startMFAChallenge(&user, methods)
 	}
 
-	refreshToken, err := r.generateAndStoreRefreshToken(user.ID)
+	return r.issueTokens(&user, uuid.Nil, client)
+}
+
+func (r *AuthRepository) RefreshToken(tokenString string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	if tokenString == "" {
+		return nil, models.ErrInvalidInput
+	}
+	tokenHash := utils.HashToken(tokenString)
+
+	refreshToken, err := r.findRefreshToken(tokenHash)
 	if err != nil {
 		return nil, err
 	}
 
-	return &models.AuthenticationResult{
-		User:              &user,
-		AccessToken:       accessToken,
-		AccessTokenExpiry: accessExp,
-		RefreshToken:      refreshToken,
-	}, nil
+	// A token only refreshes for the OAuth client it was issued to; cookie
+	// sessions have no client.
+	if refreshToken.ClientID != client.ClientID {
+		return nil, models.ErrTokenNotFound
+	}
+
+	if time.Now().After(refreshToken.ExpiresAt) {
+		return nil, models.ErrTokenExpired
+	}
+
+	result := r.DB.Exec(`
+		UPDATE refresh_tokens 
+		SET is_revoked = true, rotated_at = NOW() 
+		WHERE token_hash = ? AND is_revoked = false`, tokenHash)
+	if result.Error != nil {
+		return nil, models.New("token_revoke_failed", "failed to revoke token")
+	}
+	if result.RowsAffected == 0 {
+		// Read the token again: a concurrent refresh may have rotated it since.
+		refreshToken, err = r.findRefreshToken(tokenHash)
+		if err != nil {
+			return nil, err
+		}
+		// A token revoked by logout or a password change was not stolen, its
+		// session simply ended.
+		if refreshToken.RotatedAt == nil {
+			return nil, models.ErrTokenNotFound
+		}
+		return nil, r.handleRefreshTokenReuse(refreshToken, client)
+	}
+
+	var user models.User
+	if err := r.DB.Raw("SELECT id, name, email, email_verified_at FROM users WHERE id = ?", refreshToken.UserID).Scan(&user).Error; err != nil {
+		return nil, models.ErrInvalidCredentials
+	}
+
+	if refreshToken.ClientID != "" {
+		return r.issueClientTokens(&user, refreshToken.FamilyID, refreshToken.Scope, client)
+	}
+	return r.issueTokens(&user, refreshToken.FamilyID, client)
+}
+
+func (r *AuthRepository) Logout(tokenString string) error {
+	if tokenString == "" {
+		return models.ErrInvalidInput
+	}
+	tokenHash := utils.HashToken(tokenString)
+
+	return r.revokeRefreshToken(tokenHash)
 }
 
-func (r *AuthRepository) RefreshToken(tokenString string) (*models.AuthenticationResult, error) {
-	token, err := uuid.Parse(tokenString)
+func (r *AuthRepository) LogoutAll(tokenString string) error {
+	if tokenString == "" {
+		return models.ErrInvalidInput
+	}
+	tokenHash := utils.HashToken(tokenString)
+
+	refreshToken, err := r.validateRefreshToken(tokenHash)
 	if err != nil {
+		return err
+	}
+
+	return r.revokeUserRefreshTokens(refreshToken.UserID)
+}
+
+func (r *AuthRepository) ListSessions(tokenString string) ([]models.Session, error) {
+	if tokenString == "" {
 		return nil, models.ErrInvalidInput
 	}
+	tokenHash := utils.HashToken(tokenString)
 
-	refreshToken, err := r.validateRefreshToken(token)
+	current, err := r.validateRefreshToken(tokenHash)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := r.revokeRefreshToken(token); err != nil {
-		return nil, err
+	// Every refresh replaces the token row, so a session is a token family,
+	// shown through its latest usable token.
+	sessions := []models.Session{}
+	if err := r.DB.Raw(`
+		SELECT id, user_agent, ip_address, created_at, expires_at
+		FROM (
+			SELECT DISTINCT ON (family_id) family_id AS id, user_agent, ip_address, created_at, expires_at
+			FROM refresh_tokens
+			WHERE user_id = ? AND is_revoked = false AND expires_at > NOW()
+			ORDER BY family_id, created_at DESC
+		) latest
+		ORDER BY created_at DESC`, current.UserID).Scan(&sessions).Error; err != nil {
+		return nil, models.New("database_error", "failed to list sessions")
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current.FamilyID
+	}
+
+	return sessions, nil
+}
+
+func (r *AuthRepository) RevokeSession(tokenString string, sessionID uuid.UUID) error {
+	if tokenString == "" {
+		return models.ErrInvalidInput
+	}
+	tokenHash := utils.HashToken(tokenString)
+
+	current, err := r.validateRefreshToken(tokenHash)
+	if err != nil {
+		return err
+	}
+
+	result := r.DB.Exec(`
+		UPDATE refresh_tokens 
+		SET is_revoked = true 
+		WHERE family_id = ? AND user_id = ? AND is_revoked = false`, sessionID, current.UserID)
+	if result.Error != nil {
+		return models.New("token_revoke_failed", "failed to revoke session")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrSessionNotFound
 	}
 
+	return nil
+}
+
+// RequestPasswordReset issues a single-use reset token. It returns nil without
+// an error when no account matches the email, so callers can respond the same
+// way in both cases.
+func (r *AuthRepository) RequestPasswordReset(email string) (*models.PasswordReset, error) {
 	var user models.User
-	if err := r.DB.Raw("SELECT id, name, email FROM users WHERE id = ?", refreshToken.UserID).Scan(&user).Error; err != nil {
-		return nil, models.ErrInvalidCredentials
+	result := r.DB.Raw("SELECT id, name, email FROM users WHERE email = ?", email).Scan(&user)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find user")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate reset token")
+	}
+
+	expiresAt := utils.TokenExpiryTime(config.Current().Tokens.PasswordReset)
+	if err := r.DB.Exec(`
+		INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) 
+		VALUES (?, ?, ?)`,
+		utils.HashToken(token), user.ID, expiresAt).Error; err != nil {
+		return nil, models.New("token_storage_failed", "failed to store reset token")
+	}
+
+	return &models.PasswordReset{
+		User:      &user,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (r *AuthRepository) ResetPassword(tokenString, newPassword string, client models.ClientInfo) error {
+	if tokenString == "" {
+		return models.ErrResetTokenInvalid
 	}
 
-	accessToken, accessExp, err := utils.GenerateAccessToken(&user)
+	if err := utils.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return models.New("hashing_failed", "failed to hash password")
+	}
+
+	var reset struct {
+		UserID uuid.UUID
+	}
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Raw(`
+			UPDATE password_reset_tokens 
+			SET used_at = NOW() 
+			WHERE token_hash = ? AND used_at IS NULL AND expires_at > NOW()
+			RETURNING user_id`, utils.HashToken(tokenString)).Scan(&reset)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrResetTokenInvalid
+		}
+
+		if err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(passwordHash), reset.UserID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec(`
+			UPDATE password_reset_tokens 
+			SET used_at = NOW() 
+			WHERE user_id = ? AND used_at IS NULL`, reset.UserID).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+			UPDATE refresh_tokens 
+			SET is_revoked = true 
+			WHERE user_id = ? AND is_revoked = false`, reset.UserID).Error
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrResetTokenInvalid) {
+			return models.ErrResetTokenInvalid
+		}
+		return models.New("password_reset_failed", "failed to reset password")
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    reset.UserID,
+		EventType: models.EventPasswordReset,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+
+	return nil
+}
+
+// ChangePassword sets a new password for a logged-in user and revokes every
+// other session. The session holding currentToken, if any, stays valid.
+func (r *AuthRepository) ChangePassword(userID uuid.UUID, currentPassword, newPassword, currentToken string, client models.ClientInfo) error {
+	var user models.User
+	result := r.DB.Raw("SELECT id, password_hash FROM users WHERE id = ?", userID).Scan(&user)
+	if result.Error != nil {
+		return models.New("database_error", "failed to find user")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrUnauthorized
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
+		return models.ErrWrongPassword
+	}
+
+	if err := utils.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return models.New("hashing_failed", "failed to hash password")
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(passwordHash), userID).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+			UPDATE refresh_tokens 
+			SET is_revoked = true 
+			WHERE user_id = ? AND is_revoked = false AND token_hash <> ?`,
+			userID, utils.HashToken(currentToken)).Error
+	})
+	if err != nil {
+		return models.New("password_change_failed", "failed to change password")
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventPasswordChanged,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+
+	return nil
+}
+
+// SetPassword replaces a user's password without knowing the current one, as
+// done by operators, and revokes all of the user's sessions.
+func (r *AuthRepository) SetPassword(userID uuid.UUID, newPassword string, client models.ClientInfo) error {
+	if err := utils.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return models.New("hashing_failed", "failed to hash password")
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(passwordHash), userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrUserNotFound
+		}
+
+		return tx.Exec(`
+			UPDATE refresh_tokens 
+			SET is_revoked = true 
+			WHERE user_id = ? AND is_revoked = false`, userID).Error
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return models.ErrUserNotFound
+		}
+		return models.New("password_change_failed", "failed to set password")
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventPasswordSet,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+
+	return nil
+}
+
+// RevokeUserSessions signs a user out everywhere.
+func (r *AuthRepository) RevokeUserSessions(userID uuid.UUID, client models.ClientInfo) error {
+	if _, err := r.GetUser(userID); err != nil {
+		return err
+	}
+
+	if err := r.revokeUserRefreshTokens(userID); err != nil {
+		return err
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventSessionsRevoked,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+	return nil
+}
+
+// UnlockAccount clears the failed-login counter of the user's account. IP
+// counters are not tied to an account and one address may be shared by the
+// user and an attacker alike, so an address is only cleared when the operator
+// names it in ip.
+func (r *AuthRepository) UnlockAccount(userID uuid.UUID, ip string, client models.ClientInfo) error {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	r.resetLoginThrottle(user.Email)
+	details := map[string]any{}
+	if ip != "" {
+		r.resetIPThrottle(ip)
+		details["ip"] = ip
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventAccountUnlocked,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Details:   details,
+	})
+	return nil
+}
+
+func (r *AuthRepository) GetUser(userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	result := r.DB.Raw(`
+		SELECT id, name, email, email_verified_at, created_at, updated_at
+		FROM users WHERE id = ?`, userID).Scan(&user)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find user")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *AuthRepository) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	result := r.DB.Raw(`
+		SELECT id, name, email, email_verified_at, created_at, updated_at
+		FROM users WHERE email = ?`, email).Scan(&user)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find user")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (r *AuthRepository) GetUserRoles(userID uuid.UUID) ([]string, error) {
+	roles := []string{}
+	if err := r.DB.Raw(`
+		SELECT r.name
+		FROM user_roles ur
+		JOIN roles r ON ur.role_id = r.id
+		WHERE ur.user_id = ?
+		ORDER BY r.name`, userID).Scan(&roles).Error; err != nil {
+		return nil, models.New("database_error", "failed to load user roles")
+	}
+	return roles, nil
+}
+
+func (r *AuthRepository) VerifyEmail(tokenString string, client models.ClientInfo) (*models.User, error) {
+	claims, err := utils.VerifyClaims(utils.EmailVerificationPurpose, tokenString)
+	if err != nil {
+		return nil, models.ErrVerificationInvalid
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, models.ErrVerificationInvalid
+	}
+
+	var user models.User
+	result := r.DB.Raw(`
+		UPDATE users 
+		SET email_verified_at = COALESCE(email_verified_at, NOW()) 
+		WHERE id = ? AND email = ?
+		RETURNING id, name, email, email_verified_at, created_at, updated_at`,
+		userID, claims.Email).Scan(&user)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to verify email")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrVerificationInvalid
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    user.ID,
+		EventType: models.EventEmailVerified,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+
+	return &user, nil
+}
+
+// SessionUser returns the user behind a first-party refresh cookie without
+// rotating it, for flows such as /authorize that only need to know who is
+// signed in.
+func (r *AuthRepository) SessionUser(tokenString string) (*models.User, error) {
+	if tokenString == "" {
+		return nil, models.ErrTokenNotFound
+	}
+
+	refreshToken, err := r.validateRefreshToken(utils.HashToken(tokenString))
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken.ClientID != "" {
+		return nil, models.ErrTokenNotFound
+	}
+
+	return r.GetUser(refreshToken.UserID)
+}
+
+// IssueClientTokens starts a session for the OAuth client in client.ClientID
+// acting on behalf of a user, limited to the granted scope.
+func (r *AuthRepository) IssueClientTokens(userID uuid.UUID, scope string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	if client.ClientID == "" {
+		return nil, models.ErrInvalidClient
+	}
+
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return r.issueClientTokens(user, uuid.Nil, scope, client)
+}
+
+// issueTokens creates an access token carrying the user's roles and
+// permissions and a new refresh token in the given family. A nil familyID starts a new family.
+func (r *AuthRepository) issueTokens(user *models.User, familyID uuid.UUID, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	roles, err := r.GetUserRoles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := resolveUserPermissions(r.DB, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, accessExp, err := utils.GenerateAccessToken(user, roles, permissions)
+	if errors.Is(err, utils.ErrTooManyPermissions) {
+		log.Printf("user %s has %d permissions, more than the %d an access token can carry", user.ID, len(permissions), utils.MaxTokenPermissions)
+		return nil, models.ErrTooManyPermissions
+	}
 	if err != nil {
 		return nil, models.New("token_generate_failed", "failed to generate access token")
 	}
 
-	newRefreshToken, err := r.generateAndStoreRefreshToken(user.ID)
+	refreshToken, err := r.generateAndStoreRefreshToken(user.ID, familyID, "", client)
 	if err != nil {
 		return nil, err
 	}
 
 	return &models.AuthenticationResult{
-		User:              &user,
+		User:              user,
 		AccessToken:       accessToken,
 		AccessTokenExpiry: accessExp,
-		RefreshToken:      newRefreshToken,
+		RefreshToken:      refreshToken,
 	}, nil
 }
 
-func (r *AuthRepository) generateAndStoreRefreshToken(userID uuid.UUID) (*models.RefreshToken, error) {
-	token, err := utils.GenerateRefreshToken(userID)
+// issueClientTokens creates a delegated access token for an OAuth client and
+// a refresh token bound to that client and scope, so refreshing can neither
+// move the session to another client nor widen what it may do.
+func (r *AuthRepository) issueClientTokens(user *models.User, familyID uuid.UUID, scope string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	accessToken, accessExp, err := utils.GenerateDelegatedAccessToken(user, client.ClientID, strings.Fields(scope))
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate access token")
+	}
+
+	refreshToken, err := r.generateAndStoreRefreshToken(user.ID, familyID, scope, client)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := r.DB.Exec(`
-		INSERT INTO refresh_tokens (token, user_id, expires_at) 
-		VALUES (?, ?, ?)`,
-		token.Token, token.UserID, token.ExpiresAt).Error; err != nil {
+	return &models.AuthenticationResult{
+		User:              user,
+		AccessToken:       accessToken,
+		AccessTokenExpiry: accessExp,
+		RefreshToken:      refreshToken,
+	}, nil
+}
+
+func (r *AuthRepository) generateAndStoreRefreshToken(userID, familyID uuid.UUID, scope string, client models.ClientInfo) (*models.RefreshToken, error) {
+	token, err := utils.GenerateRefreshToken(userID)
+	if err != nil {
+		return nil, err
+	}
+	if familyID == uuid.Nil {
+		familyID = uuid.New()
+	}
+	token.FamilyID = familyID
+	token.UserAgent = client.UserAgent
+	token.IPAddress = client.IPAddress
+
+	var stored models.RefreshToken
+	if err := r.DB.Raw(`
+		INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at, user_agent, ip_address, client_id, scope) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, token_hash, user_id, family_id, user_agent, ip_address, client_id, scope, created_at, expires_at, is_revoked`,
+		token.TokenHash, token.UserID, token.FamilyID, token.ExpiresAt, token.UserAgent, token.IPAddress, client.ClientID, scope).Scan(&stored).Error; err != nil {
 		return nil, models.New("token_storage_failed", "failed to store refresh token")
 	}
+	stored.Token = token.Token
 
-	return token, nil
+	return &stored, nil
 }
 
-func (r *AuthRepository) validateRefreshToken(token uuid.UUID) (*models.RefreshToken, error) {
+func (r *AuthRepository) findRefreshToken(tokenHash string) (*models.RefreshToken, error) {
 	var refreshToken models.RefreshToken
-	err := r.DB.Raw(`
-		SELECT token, user_id, created_at, expires_at, is_revoked
-		FROM refresh_tokens WHERE token = ?`, token).Scan(&refreshToken).Error
+	result := r.DB.Raw(`
+		SELECT id, token_hash, user_id, family_id, user_agent, ip_address, client_id, scope, created_at, expires_at, is_revoked, rotated_at
+		FROM refresh_tokens WHERE token_hash = ?`, tokenHash).Scan(&refreshToken)
 
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, models.ErrTokenNotFound
-		}
+	if result.Error != nil {
 		return nil, models.New("database_error", "failed to validate token")
 	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrTokenNotFound
+	}
+
+	return &refreshToken, nil
+}
+
+func (r *AuthRepository) validateRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	refreshToken, err := r.findRefreshToken(tokenHash)
+	if err != nil {
+		return nil, err
+	}
 
 	if refreshToken.IsRevoked {
 		return nil, models.ErrTokenRevoked
@@ -170,15 +715,50 @@ func (r *AuthRepository) validateRefreshToken(token uuid.UUID) (*models.RefreshT
 		return nil, models.ErrTokenExpired
 	}
 
-	return &refreshToken, nil
+	return refreshToken, nil
 }
 
-func (r *AuthRepository) revokeRefreshToken(token uuid.UUID) error {
+func (r *AuthRepository) revokeRefreshToken(tokenHash string) error {
 	if err := r.DB.Exec(`
 		UPDATE refresh_tokens 
 		SET is_revoked = true 
-		WHERE token = ?`, token).Error; err != nil {
+		WHERE token_hash = ?`, tokenHash).Error; err != nil {
 		return models.New("token_revoke_failed", "failed to revoke token")
 	}
 	return nil
 }
+
+func (r *AuthRepository) revokeUserRefreshTokens(userID uuid.UUID) error {
+	if err := r.DB.Exec(`
+		UPDATE refresh_tokens 
+		SET is_revoked = true 
+		WHERE user_id = ? AND is_revoked = false`, userID).Error; err != nil {
+		return models.New("token_revoke_failed", "failed to revoke tokens")
+	}
+	return nil
+}
+
+// handleRefreshTokenReuse is called when an already rotated refresh token is
+// presented again. That token may have been stolen, so the whole family it
+// belongs to is revoked and the incident is recorded.
+func (r *AuthRepository) handleRefreshTokenReuse(token *models.RefreshToken, client models.ClientInfo) error {
+	if err := r.DB.Exec(`
+		UPDATE refresh_tokens 
+		SET is_revoked = true 
+		WHERE family_id = ? AND is_revoked = false`, token.FamilyID).Error; err != nil {
+		return models.New("token_revoke_failed", "failed to revoke token family")
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    token.UserID,
+		EventType: models.EventRefreshTokenReuse,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Details: map[string]any{
+			"familyId": token.FamilyID,
+			"tokenId":  token.ID,
+		},
+	})
+
+	return models.ErrTokenReused
+}
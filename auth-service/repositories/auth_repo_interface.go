@@ -2,10 +2,47 @@ package repositories
 
 import (
 	"auth-service/models"
+	"auth-service/pkg/webauthn"
+	"github.com/google/uuid"
 )
 
 type AuthRepositoryInterface interface {
-	Register(name, email, password string) (*models.AuthenticationResult, error)
-	Login(email, password string) (*models.AuthenticationResult, error)
-	RefreshToken(tokenString string) (*models.AuthenticationResult, error)
+	Register(name, email, password string, client models.ClientInfo) (*models.AuthenticationResult, error)
+	CreateUser(name, email, password string, emailVerified bool) (*models.User, error)
+	Login(email, password string, client models.ClientInfo) (*models.AuthenticationResult, error)
+	RefreshToken(tokenString string, client models.ClientInfo) (*models.AuthenticationResult, error)
+	Logout(tokenString string) error
+	LogoutAll(tokenString string) error
+	ListSessions(tokenString string) ([]models.Session, error)
+	RevokeSession(tokenString string, sessionID uuid.UUID) error
+	RequestPasswordReset(email string) (*models.PasswordReset, error)
+	ResetPassword(tokenString, newPassword string, client models.ClientInfo) error
+	ChangePassword(userID uuid.UUID, currentPassword, newPassword, currentToken string, client models.ClientInfo) error
+	SetPassword(userID uuid.UUID, newPassword string, client models.ClientInfo) error
+	RevokeUserSessions(userID uuid.UUID, client models.ClientInfo) error
+	UnlockAccount(userID uuid.UUID, ip string, client models.ClientInfo) error
+	GetUser(userID uuid.UUID) (*models.User, error)
+	SessionUser(tokenString string) (*models.User, error)
+	IssueClientTokens(userID uuid.UUID, scope string, client models.ClientInfo) (*models.AuthenticationResult, error)
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserRoles(userID uuid.UUID) ([]string, error)
+	VerifyEmail(tokenString string, client models.ClientInfo) (*models.User, error)
+	EnrollTOTP(userID uuid.UUID) (*models.TOTPEnrollment, error)
+	ConfirmTOTP(userID uuid.UUID, code string, client models.ClientInfo) ([]string, error)
+	DisableTOTP(userID uuid.UUID, password, code string, client models.ClientInfo) error
+	LoginMFA(challengeToken, code string, client models.ClientInfo) (*models.AuthenticationResult, error)
+	RequestMagicLink(email, browserNonce string) (*models.MagicLink, error)
+	VerifyMagicLink(tokenString, browserNonce string, client models.ClientInfo) (*models.AuthenticationResult, error)
+	SaveOAuthState(state *models.OAuthState) error
+	ConsumeOAuthState(state, provider string) (*models.OAuthState, error)
+	LoginWithIdentity(identity *models.ExternalIdentity, client models.ClientInfo) (*models.AuthenticationResult, error)
+	ListIdentities(userID uuid.UUID) ([]models.UserIdentity, error)
+	BeginPasskeyRegistration(userID uuid.UUID) (*webauthn.CreationOptions, error)
+	FinishPasskeyRegistration(userID uuid.UUID, name string, response *webauthn.RegistrationResponse, proof models.Reauthentication, client models.ClientInfo) (*models.WebAuthnCredential, error)
+	BeginPasskeyReauthentication(userID uuid.UUID) (*webauthn.RequestOptions, error)
+	BeginPasskeyLogin(mfaToken string) (*webauthn.RequestOptions, error)
+	FinishPasskeyLogin(mfaToken string, response *webauthn.AssertionResponse, client models.ClientInfo) (*models.AuthenticationResult, error)
+	ListPasskeys(userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	RenamePasskey(userID, passkeyID uuid.UUID, name string) (*models.WebAuthnCredential, error)
+	DeletePasskey(userID, passkeyID uuid.UUID, proof models.Reauthentication, client models.ClientInfo) error
 }
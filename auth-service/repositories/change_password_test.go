@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+func TestChangePassword(t *testing.T) {
+	userID := uuid.New()
+	currentHash, err := bcrypt.GenerateFromPassword([]byte("current-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUser := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT id, password_hash FROM users WHERE id = \$1`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "password_hash"}).AddRow(userID, string(currentHash)))
+	}
+
+	t.Run("wrong current password", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		expectUser(mock)
+
+		err := NewAuthRepository(db).ChangePassword(userID, "guess", "new-password", "", models.ClientInfo{})
+		if err != models.ErrWrongPassword {
+			t.Fatalf("err = %v, want %v", err, models.ErrWrongPassword)
+		}
+	})
+
+	t.Run("weak new password", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		expectUser(mock)
+
+		err := NewAuthRepository(db).ChangePassword(userID, "current-password", "short", "", models.ClientInfo{})
+		if err != models.ErrWeakPassword {
+			t.Fatalf("err = %v, want %v", err, models.ErrWeakPassword)
+		}
+	})
+
+	t.Run("keeps the current session", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		expectUser(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE users SET password_hash = \$1 WHERE id = \$2`).
+			WithArgs(sqlmock.AnyArg(), userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE refresh_tokens\s+SET is_revoked = true\s+WHERE user_id = \$1 AND is_revoked = false AND token_hash <> \$2`).
+			WithArgs(userID, utils.HashToken("current-session")).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectExec(`INSERT INTO security_events`).
+			WithArgs(userID, models.EventPasswordChanged, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := NewAuthRepository(db).ChangePassword(userID, "current-password", "new-password", "current-session", models.ClientInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"crypto/hmac"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const clientColumns = "id, name, secret_hash, redirect_uris, grant_types, scopes, first_party, created_at, updated_at"
+
+var supportedGrants = []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials}
+
+type ClientRepository struct {
+	DB *gorm.DB
+}
+
+var _ ClientRepositoryInterface = (*ClientRepository)(nil)
+
+func NewClientRepository(db *gorm.DB) *ClientRepository {
+	return &ClientRepository{DB: db}
+}
+
+func (r *ClientRepository) ListClients() ([]models.Client, error) {
+	clients := []models.Client{}
+	if err := r.DB.Raw("SELECT " + clientColumns + " FROM clients ORDER BY name").Scan(&clients).Error; err != nil {
+		return nil, models.New("database_error", "failed to list clients")
+	}
+	return clients, nil
+}
+
+func (r *ClientRepository) GetClient(clientID string) (*models.Client, error) {
+	var client models.Client
+	result := r.DB.Raw("SELECT "+clientColumns+" FROM clients WHERE id = ?", clientID).Scan(&client)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find client")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrClientNotFound
+	}
+	return &client, nil
+}
+
+// CreateClient registers a client. The secret of a confidential client is
+// only returned here; the database keeps its hash.
+func (r *ClientRepository) CreateClient(name string, confidential, firstParty bool, redirectURIs, grantTypes, scopes []string) (*models.ClientWithSecret, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(grantTypes) == 0 {
+		return nil, models.ErrInvalidInput
+	}
+	for _, grant := range grantTypes {
+		if !slices.Contains(supportedGrants, grant) {
+			return nil, models.ErrInvalidInput
+		}
+		// Without a secret nothing authenticates a client_credentials request.
+		if grant == models.GrantClientCredentials && !confidential {
+			return nil, models.ErrInvalidInput
+		}
+	}
+	for _, uri := range redirectURIs {
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+			return nil, models.ErrInvalidInput
+		}
+	}
+	if slices.Contains(grantTypes, models.GrantAuthorizationCode) && len(redirectURIs) == 0 {
+		return nil, models.ErrInvalidInput
+	}
+
+	var secret, secretHash string
+	if confidential {
+		var err error
+		if secret, err = utils.GenerateOpaqueToken(); err != nil {
+			return nil, models.New("client_create_failed", "failed to generate client secret")
+		}
+		secretHash = utils.HashToken(secret)
+	}
+
+	var client models.Client
+	if err := r.DB.Raw(`
+		INSERT INTO clients (id, name, secret_hash, redirect_uris, grant_types, scopes, first_party)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+clientColumns,
+		uuid.New().String(), name, secretHash, models.StringList(redirectURIs),
+		models.StringList(grantTypes), models.StringList(scopes), firstParty).Scan(&client).Error; err != nil {
+		return nil, models.New("database_error", "failed to create client")
+	}
+
+	return &models.ClientWithSecret{Client: &client, Secret: secret}, nil
+}
+
+func (r *ClientRepository) DeleteClient(clientID string) error {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("DELETE FROM clients WHERE id = ?", clientID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrClientNotFound
+		}
+
+		return tx.Exec(`
+			UPDATE refresh_tokens SET is_revoked = true
+			WHERE client_id = ? AND is_revoked = false`, clientID).Error
+	})
+	if err != nil {
+		if appErr, ok := err.(*models.AppError); ok {
+			return appErr
+		}
+		return models.New("database_error", "failed to delete client")
+	}
+	return nil
+}
+
+// GetConsent returns the scopes the user approved for the client, or
+// models.ErrConsentNotFound when they never did.
+func (r *ClientRepository) GetConsent(userID uuid.UUID, clientID string) (*models.Consent, error) {
+	var consent models.Consent
+	result := r.DB.Raw(`
+		SELECT cc.client_id, c.name AS client_name, cc.scopes, cc.granted_at
+		FROM client_consents cc JOIN clients c ON c.id = cc.client_id
+		WHERE cc.user_id = ? AND cc.client_id = ?`, userID, clientID).Scan(&consent)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to load consent")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrConsentNotFound
+	}
+	return &consent, nil
+}
+
+// GrantConsent adds scopes to what the user approved for the client. Scopes
+// approved earlier are kept.
+func (r *ClientRepository) GrantConsent(userID uuid.UUID, clientID string, scopes []string) (*models.Consent, error) {
+	var consent models.Consent
+	if err := r.DB.Raw(`
+		INSERT INTO client_consents (user_id, client_id, scopes)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET
+			scopes = (
+				SELECT jsonb_agg(DISTINCT scope ORDER BY scope)
+				FROM jsonb_array_elements_text(client_consents.scopes || EXCLUDED.scopes) AS scope
+			),
+			granted_at = NOW()
+		RETURNING client_id, scopes, granted_at`,
+		userID, clientID, models.StringList(scopes)).Scan(&consent).Error; err != nil {
+		return nil, models.New("database_error", "failed to store consent")
+	}
+	return &consent, nil
+}
+
+// RevokeConsent withdraws the user's approval and ends the client's sessions
+// for the user, so its refresh tokens stop working too.
+func (r *ClientRepository) RevokeConsent(userID uuid.UUID, clientID string) error {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("DELETE FROM client_consents WHERE user_id = ? AND client_id = ?", userID, clientID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrConsentNotFound
+		}
+
+		return tx.Exec(`
+			UPDATE refresh_tokens SET is_revoked = true
+			WHERE user_id = ? AND client_id = ? AND is_revoked = false`, userID, clientID).Error
+	})
+	if err != nil {
+		if appErr, ok := err.(*models.AppError); ok {
+			return appErr
+		}
+		return models.New("database_error", "failed to revoke consent")
+	}
+	return nil
+}
+
+// AuthenticateClient checks the credentials presented at the token endpoint.
+// Public clients authenticate with their ID alone and rely on PKCE.
+func (r *ClientRepository) AuthenticateClient(clientID, secret string) (*models.Client, error) {
+	if clientID == "" {
+		return nil, models.ErrInvalidClient
+	}
+
+	client, err := r.GetClient(clientID)
+	if err != nil {
+		if err == models.ErrClientNotFound {
+			return nil, models.ErrInvalidClient
+		}
+		return nil, err
+	}
+
+	if client.Confidential() {
+		if secret == "" || !hmac.Equal([]byte(utils.HashToken(secret)), []byte(client.SecretHash)) {
+			return nil, models.ErrInvalidClient
+		}
+	} else if secret != "" {
+		return nil, models.ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+func (r *ClientRepository) CreateAuthorizationCode(code *models.AuthorizationCode) (string, error) {
+	raw, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", models.New("token_generate_failed", "failed to generate authorization code")
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM authorization_codes WHERE expires_at < NOW()").Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			INSERT INTO authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			utils.HashToken(raw), code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+			code.CodeChallenge, code.ExpiresAt).Error
+	})
+	if err != nil {
+		return "", models.New("token_storage_failed", "failed to store authorization code")
+	}
+
+	return raw, nil
+}
+
+// ConsumeAuthorizationCode marks a code used and returns what it was issued
+// for. A code is accepted at most once.
+func (r *ClientRepository) ConsumeAuthorizationCode(code string) (*models.AuthorizationCode, error) {
+	var stored models.AuthorizationCode
+	result := r.DB.Raw(`
+		UPDATE authorization_codes
+		SET used_at = NOW()
+		WHERE code_hash = ? AND used_at IS NULL AND expires_at > NOW()
+		RETURNING client_id, user_id, redirect_uri, scope, nonce, code_challenge, expires_at`,
+		utils.HashToken(code)).Scan(&stored)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to verify authorization code")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrInvalidGrant
+	}
+	return &stored, nil
+}
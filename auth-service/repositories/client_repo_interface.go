@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/google/uuid"
+)
+
+type ClientRepositoryInterface interface {
+	ListClients() ([]models.Client, error)
+	GetClient(clientID string) (*models.Client, error)
+	CreateClient(name string, confidential, firstParty bool, redirectURIs, grantTypes, scopes []string) (*models.ClientWithSecret, error)
+	DeleteClient(clientID string) error
+	AuthenticateClient(clientID, secret string) (*models.Client, error)
+	GetConsent(userID uuid.UUID, clientID string) (*models.Consent, error)
+	GrantConsent(userID uuid.UUID, clientID string, scopes []string) (*models.Consent, error)
+	RevokeConsent(userID uuid.UUID, clientID string) error
+	CreateAuthorizationCode(code *models.AuthorizationCode) (string, error)
+	ConsumeAuthorizationCode(code string) (*models.AuthorizationCode, error)
+}
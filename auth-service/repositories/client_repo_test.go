@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestCreateClientStoresFirstParty(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`INSERT INTO clients \(id, name, secret_hash, redirect_uris, grant_types, scopes, first_party\)`).
+		WithArgs(sqlmock.AnyArg(), "Storefront app", "", `["https://shop.example.com/callback"]`, `["authorization_code"]`, `["openid"]`, true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "first_party"}).AddRow("client", "Storefront app", true))
+
+	client, err := NewClientRepository(db).CreateClient("Storefront app", false, true,
+		[]string{"https://shop.example.com/callback"}, []string{models.GrantAuthorizationCode}, []string{"openid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.FirstParty {
+		t.Error("client is not first party")
+	}
+}
+
+func TestGetConsentNotGiven(t *testing.T) {
+	db, mock := newMockDB(t)
+	userID := uuid.New()
+
+	mock.ExpectQuery(`FROM client_consents cc JOIN clients c ON c.id = cc.client_id\s+WHERE cc.user_id = \$1 AND cc.client_id = \$2`).
+		WithArgs(userID, "partner").
+		WillReturnRows(sqlmock.NewRows([]string{"client_id", "scopes"}))
+
+	if _, err := NewClientRepository(db).GetConsent(userID, "partner"); err != models.ErrConsentNotFound {
+		t.Fatalf("err = %v, want %v", err, models.ErrConsentNotFound)
+	}
+}
+
+func TestGrantConsentAddsScopes(t *testing.T) {
+	db, mock := newMockDB(t)
+	userID := uuid.New()
+
+	// Earlier approvals are merged in, not replaced.
+	mock.ExpectQuery(`INSERT INTO client_consents .* ON CONFLICT \(user_id, client_id\) DO UPDATE SET\s+scopes = \(.*client_consents.scopes \|\| EXCLUDED.scopes`).
+		WithArgs(userID, "partner", `["email"]`).
+		WillReturnRows(sqlmock.NewRows([]string{"client_id", "scopes", "granted_at"}).AddRow("partner", `["email","openid"]`, time.Now()))
+
+	consent, err := NewClientRepository(db).GrantConsent(userID, "partner", []string{"email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !consent.Covers([]string{"openid", "email"}) || consent.Covers([]string{"profile"}) {
+		t.Errorf("consent covers %v", consent.Scopes)
+	}
+}
+
+func TestRevokeConsent(t *testing.T) {
+	tests := []struct {
+		name    string
+		deleted int64
+		wantErr error
+	}{
+		{"given", 1, nil},
+		{"never given", 0, models.ErrConsentNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			userID := uuid.New()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`DELETE FROM client_consents WHERE user_id = \$1 AND client_id = \$2`).
+				WithArgs(userID, "partner").
+				WillReturnResult(sqlmock.NewResult(0, tt.deleted))
+			if tt.wantErr == nil {
+				// The client's sessions for the user end with the consent.
+				mock.ExpectExec(`UPDATE refresh_tokens SET is_revoked = true\s+WHERE user_id = \$1 AND client_id = \$2`).
+					WithArgs(userID, "partner").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			if err := NewClientRepository(db).RevokeConsent(userID, "partner"); err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
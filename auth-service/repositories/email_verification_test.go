@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	useConfig(t, nil)
+	userID := uuid.New()
+	token, err := utils.GenerateEmailVerificationToken(&models.User{ID: userID, Email: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`UPDATE users\s+SET email_verified_at = COALESCE\(email_verified_at, NOW\(\)\)\s+WHERE id = \$1 AND email = \$2`).
+			WithArgs(userID, "user@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "email_verified_at"}).AddRow(userID, "user@example.com", time.Now()))
+		mock.ExpectExec(`INSERT INTO security_events`).
+			WithArgs(userID, models.EventEmailVerified, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		user, err := NewAuthRepository(db).VerifyEmail(token, models.ClientInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.EmailVerifiedAt == nil {
+			t.Error("email_verified_at not returned")
+		}
+	})
+
+	t.Run("email changed since the link was sent", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`UPDATE users`).
+			WithArgs(userID, "user@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		if _, err := NewAuthRepository(db).VerifyEmail(token, models.ClientInfo{}); err != models.ErrVerificationInvalid {
+			t.Fatalf("err = %v, want %v", err, models.ErrVerificationInvalid)
+		}
+	})
+
+	t.Run("token for another purpose", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		other, err := utils.SignClaims(utils.MagicLinkPurpose, utils.SignedClaims{
+			Subject:   userID.String(),
+			Email:     "user@example.com",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewAuthRepository(db).VerifyEmail(other, models.ClientInfo{}); err != models.ErrVerificationInvalid {
+			t.Fatalf("err = %v, want %v", err, models.ErrVerificationInvalid)
+		}
+	})
+}
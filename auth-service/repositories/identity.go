@@ -0,0 +1,199 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// SaveOAuthState remembers a redirect to an identity provider until its
+// callback. The code verifier is encrypted at rest.
+func (r *AuthRepository) SaveOAuthState(state *models.OAuthState) error {
+	verifier, err := utils.Encrypt([]byte(state.CodeVerifier))
+	if err != nil {
+		return models.New("oauth_state_failed", "failed to store sign-in request")
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM oauth_states WHERE expires_at < NOW()").Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			INSERT INTO oauth_states (state_hash, provider, nonce, code_verifier, expires_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			utils.HashToken(state.State), state.Provider, state.Nonce, verifier, state.ExpiresAt).Error
+	})
+	if err != nil {
+		return models.New("oauth_state_failed", "failed to store sign-in request")
+	}
+	return nil
+}
+
+// ConsumeOAuthState deletes and returns the state of a pending redirect, so
+// each callback URL works once.
+func (r *AuthRepository) ConsumeOAuthState(state, provider string) (*models.OAuthState, error) {
+	var stored struct {
+		Nonce        string
+		CodeVerifier string
+		ExpiresAt    time.Time
+	}
+	result := r.DB.Raw(`
+		DELETE FROM oauth_states
+		WHERE state_hash = ? AND provider = ? AND expires_at > NOW()
+		RETURNING nonce, code_verifier, expires_at`,
+		utils.HashToken(state), provider).Scan(&stored)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to load sign-in request")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrOAuthStateInvalid
+	}
+
+	verifier, err := utils.Decrypt(stored.CodeVerifier)
+	if err != nil {
+		return nil, models.ErrOAuthStateInvalid
+	}
+
+	return &models.OAuthState{
+		State:        state,
+		Provider:     provider,
+		Nonce:        stored.Nonce,
+		CodeVerifier: string(verifier),
+		ExpiresAt:    stored.ExpiresAt,
+	}, nil
+}
+
+// LoginWithIdentity signs in the user linked to an external identity. An
+// unknown identity is linked to the account with the same email only when
+// both the provider and this service have verified that email; otherwise
+// anyone able to register the address at either side could take the account
+// over. Without any matching account a new, password-less user is created.
+func (r *AuthRepository) LoginWithIdentity(identity *models.ExternalIdentity, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	user, err := r.findUserByIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		if user, err = r.linkIdentity(identity, client); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.EmailVerifiedAt == nil && utils.RequireEmailVerification() {
+		return nil, models.ErrEmailNotVerified
+	}
+
+	methods, err := r.secondFactors(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) > 0 {
+		return r.startMFAChallenge(user, methods)
+	}
+
+	return r.issueTokens(user, uuid.Nil, client)
+}
+
+func (r *AuthRepository) ListIdentities(userID uuid.UUID) ([]models.UserIdentity, error) {
+	identities := []models.UserIdentity{}
+	if err := r.DB.Raw(`
+		SELECT id, provider, email, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = ?
+		ORDER BY created_at`, userID).Scan(&identities).Error; err != nil {
+		return nil, models.New("database_error", "failed to list identities")
+	}
+	return identities, nil
+}
+
+func (r *AuthRepository) findUserByIdentity(identity *models.ExternalIdentity) (*models.User, error) {
+	var link struct {
+		UserID uuid.UUID
+	}
+	result := r.DB.Raw(`
+		UPDATE user_identities
+		SET last_login_at = NOW(), email = ?
+		WHERE provider = ? AND subject = ?
+		RETURNING user_id`,
+		identity.Email, identity.Provider, identity.Subject).Scan(&link)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find identity")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return r.GetUser(link.UserID)
+}
+
+func (r *AuthRepository) linkIdentity(identity *models.ExternalIdentity, client models.ClientInfo) (*models.User, error) {
+	if identity.Email == "" {
+		return nil, models.ErrIdentityEmailMissing
+	}
+
+	var user models.User
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Raw(`
+			SELECT id, name, email, email_verified_at, created_at, updated_at
+			FROM users WHERE email = ?
+			FOR UPDATE`, identity.Email).Scan(&user)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected > 0 {
+			if !identity.EmailVerified || user.EmailVerifiedAt == nil {
+				return models.ErrIdentityConflict
+			}
+		} else {
+			var verifiedAt *time.Time
+			if identity.EmailVerified {
+				now := time.Now()
+				verifiedAt = &now
+			}
+
+			if err := tx.Raw(`
+				INSERT INTO users (name, email, password_hash, email_verified_at)
+				VALUES (?, ?, '', ?)
+				RETURNING id, name, email, email_verified_at, created_at, updated_at`,
+				identityName(identity), identity.Email, verifiedAt).Scan(&user).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec(`
+			INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+			VALUES (?, ?, ?, ?, NOW())`,
+			user.ID, identity.Provider, identity.Subject, identity.Email).Error
+	})
+	if err != nil {
+		if appErr, ok := err.(*models.AppError); ok {
+			return nil, appErr
+		}
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, models.ErrIdentityConflict
+		}
+		return nil, models.New("database_error", "failed to link identity")
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    user.ID,
+		EventType: models.EventIdentityLinked,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Details:   map[string]any{"provider": identity.Provider},
+	})
+
+	return &user, nil
+}
+
+func identityName(identity *models.ExternalIdentity) string {
+	if name := strings.TrimSpace(identity.Name); name != "" {
+		return name
+	}
+	name, _, _ := strings.Cut(identity.Email, "@")
+	return name
+}
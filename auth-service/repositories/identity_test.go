@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"database/sql/driver"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestLinkIdentityToExistingAccount(t *testing.T) {
+	tests := []struct {
+		name             string
+		providerVerified bool
+		accountVerified  bool
+		wantLinked       bool
+	}{
+		{"both verified", true, true, true},
+		{"provider did not verify", false, true, false},
+		{"account not verified", true, false, false},
+		{"neither verified", false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			userID := uuid.New()
+			identity := &models.ExternalIdentity{Provider: "google", Subject: "provider-user", Email: "user@example.com", EmailVerified: tt.providerVerified}
+
+			var verifiedAt *time.Time
+			if tt.accountVerified {
+				now := time.Now()
+				verifiedAt = &now
+			}
+			mock.ExpectBegin()
+			mock.ExpectQuery(`FROM users WHERE email = \$1\s+FOR UPDATE`).
+				WithArgs("user@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "email", "email_verified_at"}).AddRow(userID, "user@example.com", verifiedAt))
+			if tt.wantLinked {
+				mock.ExpectExec(`INSERT INTO user_identities \(user_id, provider, subject, email, last_login_at\)`).
+					WithArgs(userID, "google", "provider-user", "user@example.com").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectExec(`INSERT INTO security_events`).
+					WithArgs(userID, models.EventIdentityLinked, sqlmock.AnyArg(), sqlmock.AnyArg(), `{"provider":"google"}`).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			} else {
+				mock.ExpectRollback()
+			}
+
+			user, err := NewAuthRepository(db).linkIdentity(identity, models.ClientInfo{})
+			if tt.wantLinked {
+				if err != nil || user.ID != userID {
+					t.Fatalf("linkIdentity = %v, %v; want the existing account", user, err)
+				}
+			} else if err != models.ErrIdentityConflict {
+				t.Fatalf("err = %v, want %v", err, models.ErrIdentityConflict)
+			}
+		})
+	}
+}
+
+func TestLinkIdentityCreatesAccount(t *testing.T) {
+	for _, verified := range []bool{true, false} {
+		db, mock := newMockDB(t)
+		userID := uuid.New()
+		identity := &models.ExternalIdentity{Provider: "github", Subject: "12345", Email: "new@example.com", EmailVerified: verified}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM users WHERE email = \$1\s+FOR UPDATE`).
+			WithArgs("new@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO users \(name, email, password_hash, email_verified_at\)\s+VALUES \(\$1, \$2, '', \$3\)`).
+			WithArgs("new", "new@example.com", argFunc(func(v driver.Value) bool {
+				_, isTime := v.(time.Time)
+				return isTime == verified
+			})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(userID, "new@example.com"))
+		mock.ExpectExec(`INSERT INTO user_identities`).
+			WithArgs(userID, "github", "12345", "new@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(`INSERT INTO security_events`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		user, err := NewAuthRepository(db).linkIdentity(identity, models.ClientInfo{})
+		if err != nil || user.ID != userID {
+			t.Fatalf("verified=%v: linkIdentity = %v, %v", verified, user, err)
+		}
+	}
+}
+
+func TestLinkIdentityRequiresEmail(t *testing.T) {
+	db, _ := newMockDB(t)
+	identity := &models.ExternalIdentity{Provider: "github", Subject: "12345"}
+
+	if _, err := NewAuthRepository(db).linkIdentity(identity, models.ClientInfo{}); err != models.ErrIdentityEmailMissing {
+		t.Fatalf("err = %v, want %v", err, models.ErrIdentityEmailMissing)
+	}
+}
+
+func TestLinkIdentityAlreadyLinkedElsewhere(t *testing.T) {
+	db, mock := newMockDB(t)
+	userID := uuid.New()
+	identity := &models.ExternalIdentity{Provider: "google", Subject: "provider-user", Email: "user@example.com", EmailVerified: true}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE email = \$1\s+FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email_verified_at"}).AddRow(userID, time.Now()))
+	mock.ExpectExec(`INSERT INTO user_identities`).
+		WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "user_identities_provider_subject_key"`))
+	mock.ExpectRollback()
+
+	if _, err := NewAuthRepository(db).linkIdentity(identity, models.ClientInfo{}); err != models.ErrIdentityConflict {
+		t.Fatalf("err = %v, want %v", err, models.ErrIdentityConflict)
+	}
+}
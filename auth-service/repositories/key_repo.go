@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"gorm.io/gorm"
+	"time"
+)
+
+// signingKeyLock is the advisory lock key that serializes key rotation
+// between replicas.
+const signingKeyLock = 7_245_001
+
+type KeyRepository struct {
+	DB *gorm.DB
+}
+
+var _ KeyRepositoryInterface = (*KeyRepository)(nil)
+var _ utils.KeySource = (*KeyRepository)(nil)
+
+func NewKeyRepository(db *gorm.DB) *KeyRepository {
+	return &KeyRepository{DB: db}
+}
+
+func (r *KeyRepository) ListSigningKeys() ([]models.SigningKey, error) {
+	keys := []models.SigningKey{}
+	if err := r.DB.Raw(`
+		SELECT kid AS id, algorithm, private_key, created_at, activates_at, expires_at
+		FROM signing_keys
+		WHERE expires_at IS NULL OR expires_at > NOW()
+		ORDER BY created_at DESC`).Scan(&keys).Error; err != nil {
+		return nil, models.New("database_error", "failed to load signing keys")
+	}
+	return keys, nil
+}
+
+// RotateSigningKey publishes a new key that starts signing after
+// utils.SigningKeyActivationDelay. The current keys keep signing until then
+// and are published for overlap after that.
+func (r *KeyRepository) RotateSigningKey(algorithm string, overlap time.Duration) (*models.SigningKey, error) {
+	var key *models.SigningKey
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", signingKeyLock).Error; err != nil {
+			return err
+		}
+
+		var err error
+		key, err = insertSigningKey(tx, algorithm, time.Now().Add(utils.SigningKeyActivationDelay), overlap)
+		return err
+	})
+	if err != nil {
+		return nil, models.New("key_rotation_failed", "failed to rotate signing key")
+	}
+	return key, nil
+}
+
+// RotateSigningKeyIfDue creates a new signing key when there is none, when the
+// active one is older than interval, or when the configured algorithm changed.
+// Only the very first key signs at once; a replacement waits for
+// utils.SigningKeyActivationDelay like RotateSigningKey.
+func (r *KeyRepository) RotateSigningKeyIfDue(algorithm string, interval, overlap time.Duration) (bool, error) {
+	rotated := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", signingKeyLock).Error; err != nil {
+			return err
+		}
+
+		var active models.SigningKey
+		result := tx.Raw(`
+			SELECT kid AS id, algorithm, created_at
+			FROM signing_keys
+			WHERE expires_at IS NULL
+			ORDER BY created_at DESC
+			LIMIT 1`).Scan(&active)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected > 0 && active.Algorithm == algorithm && time.Since(active.CreatedAt) < interval {
+			return nil
+		}
+
+		activatesAt := time.Now()
+		if result.RowsAffected > 0 {
+			activatesAt = activatesAt.Add(utils.SigningKeyActivationDelay)
+		}
+		if _, err := insertSigningKey(tx, algorithm, activatesAt, overlap); err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	if err != nil {
+		return false, models.New("key_rotation_failed", "failed to rotate signing key")
+	}
+	return rotated, nil
+}
+
+// insertSigningKey stores a fresh key that signs from activatesAt, and
+// schedules every previous key to stop being published once the overlap
+// window after that has passed.
+func insertSigningKey(tx *gorm.DB, algorithm string, activatesAt time.Time, overlap time.Duration) (*models.SigningKey, error) {
+	key, err := utils.GenerateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Exec(`
+		UPDATE signing_keys 
+		SET expires_at = ? 
+		WHERE expires_at IS NULL`, activatesAt.Add(overlap).UTC()).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Raw(`
+		INSERT INTO signing_keys (kid, algorithm, private_key, activates_at) 
+		VALUES (?, ?, ?, ?)
+		RETURNING kid AS id, algorithm, private_key, created_at, activates_at, expires_at`,
+		key.ID, key.Algorithm, key.PrivateKey, activatesAt.UTC()).Scan(key).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Exec(`DELETE FROM signing_keys WHERE expires_at < NOW()`).Error; err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,12 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"time"
+)
+
+type KeyRepositoryInterface interface {
+	ListSigningKeys() ([]models.SigningKey, error)
+	RotateSigningKey(algorithm string, overlap time.Duration) (*models.SigningKey, error)
+	RotateSigningKeyIfDue(algorithm string, interval, overlap time.Duration) (bool, error)
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"auth-service/utils"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"testing"
+	"time"
+)
+
+// around matches a timestamp argument within a few seconds of want.
+func around(want time.Time) sqlmock.Argument {
+	return argFunc(func(v driver.Value) bool {
+		got, ok := v.(time.Time)
+		return ok && got.Sub(want).Abs() < 5*time.Second
+	})
+}
+
+func expectActiveKey(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM signing_keys\s+WHERE expires_at IS NULL`).WillReturnRows(rows)
+}
+
+func expectInsertKey(mock sqlmock.Sqlmock, activatesAt time.Time, overlap time.Duration) {
+	mock.ExpectExec(`UPDATE signing_keys\s+SET expires_at`).
+		WithArgs(around(activatesAt.Add(overlap))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO signing_keys`).
+		WithArgs(sqlmock.AnyArg(), "ES256", sqlmock.AnyArg(), around(activatesAt)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "algorithm", "activates_at"}).AddRow("kid", "ES256", activatesAt))
+	mock.ExpectExec(`DELETE FROM signing_keys WHERE expires_at < NOW\(\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+func TestRotateSigningKeyIfDue(t *testing.T) {
+	overlap := 24 * time.Hour
+
+	t.Run("first key signs at once", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		expectActiveKey(mock, sqlmock.NewRows([]string{"id", "algorithm", "created_at"}))
+		expectInsertKey(mock, time.Now(), overlap)
+
+		rotated, err := NewKeyRepository(db).RotateSigningKeyIfDue("ES256", time.Hour, overlap)
+		if err != nil || !rotated {
+			t.Fatalf("rotated = %v, err = %v", rotated, err)
+		}
+	})
+
+	t.Run("replacement waits for the activation delay", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		expectActiveKey(mock, sqlmock.NewRows([]string{"id", "algorithm", "created_at"}).
+			AddRow("old", "ES256", time.Now().Add(-2*time.Hour)))
+		expectInsertKey(mock, time.Now().Add(utils.SigningKeyActivationDelay), overlap)
+
+		rotated, err := NewKeyRepository(db).RotateSigningKeyIfDue("ES256", time.Hour, overlap)
+		if err != nil || !rotated {
+			t.Fatalf("rotated = %v, err = %v", rotated, err)
+		}
+	})
+
+	t.Run("recent key is kept", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		expectActiveKey(mock, sqlmock.NewRows([]string{"id", "algorithm", "created_at"}).
+			AddRow("current", "ES256", time.Now().Add(-time.Minute)))
+		mock.ExpectCommit()
+
+		rotated, err := NewKeyRepository(db).RotateSigningKeyIfDue("ES256", time.Hour, overlap)
+		if err != nil || rotated {
+			t.Fatalf("rotated = %v, err = %v", rotated, err)
+		}
+	})
+}
+
+func TestRotateSigningKeyPublishesFirst(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	overlap := 24 * time.Hour
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectInsertKey(mock, time.Now().Add(utils.SigningKeyActivationDelay), overlap)
+
+	key, err := NewKeyRepository(db).RotateSigningKey("ES256", overlap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(key.ActivatesAt) < utils.SigningKeyActivationDelay-time.Minute {
+		t.Errorf("key activates at %s, before the JWKS caches expire", key.ActivatesAt)
+	}
+}
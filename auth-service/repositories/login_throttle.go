@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	throttleScopeAccount = "account"
+	throttleScopeIP      = "ip"
+)
+
+type loginThrottle struct {
+	FailedAttempts int
+	LockedUntil    *time.Time
+}
+
+// checkLoginThrottle fails with an account_locked error while either the
+// account or the source IP is locked.
+func (r *AuthRepository) checkLoginThrottle(email, ip string) error {
+	var lockedUntil struct {
+		LockedUntil *time.Time
+	}
+	if err := r.DB.Raw(`
+		SELECT MAX(locked_until) AS locked_until
+		FROM login_throttles
+		WHERE ((scope = ? AND subject = ?) OR (scope = ? AND subject = ?))
+		AND locked_until > NOW()`,
+		throttleScopeAccount, accountSubject(email), throttleScopeIP, ip).Scan(&lockedUntil).Error; err != nil {
+		return models.New("database_error", "failed to check login attempts")
+	}
+
+	if lockedUntil.LockedUntil != nil {
+		return models.NewAccountLocked(time.Until(*lockedUntil.LockedUntil))
+	}
+	return nil
+}
+
+// recordFailedLogin counts a failed attempt for both the account and the IP
+// and locks whichever crossed its threshold.
+func (r *AuthRepository) recordFailedLogin(email string, client models.ClientInfo) {
+	accountFailures := r.incrementFailures(throttleScopeAccount, accountSubject(email), r.Lockout.Threshold)
+	if client.IPAddress != "" {
+		r.incrementFailures(throttleScopeIP, client.IPAddress, r.Lockout.IPThreshold)
+	}
+
+	if r.Lockout.LockDuration(accountFailures, r.Lockout.Threshold) > 0 {
+		recordSecurityEvent(r.DB, &models.SecurityEvent{
+			EventType: models.EventAccountLocked,
+			IPAddress: client.IPAddress,
+			UserAgent: client.UserAgent,
+			Details: map[string]any{
+				"email":    accountSubject(email),
+				"failures": accountFailures,
+			},
+		})
+	}
+}
+
+func (r *AuthRepository) incrementFailures(scope, subject string, threshold int) int {
+	var throttle loginThrottle
+	if err := r.DB.Raw(`
+		INSERT INTO login_throttles (scope, subject, failed_attempts, last_failed_at)
+		VALUES (?, ?, 1, NOW())
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failed_attempts = CASE
+				WHEN login_throttles.last_failed_at < NOW() - make_interval(secs => ?) THEN 1
+				ELSE login_throttles.failed_attempts + 1
+			END,
+			last_failed_at = NOW()
+		RETURNING failed_attempts, locked_until`,
+		scope, subject, r.Lockout.Window.Seconds()).Scan(&throttle).Error; err != nil {
+		log.Printf("failed to record failed login for %s %s: %v", scope, subject, err)
+		return 0
+	}
+
+	lock := r.Lockout.LockDuration(throttle.FailedAttempts, threshold)
+	if lock == 0 {
+		return throttle.FailedAttempts
+	}
+
+	if err := r.DB.Exec(`
+		UPDATE login_throttles 
+		SET locked_until = NOW() + make_interval(secs => ?) 
+		WHERE scope = ? AND subject = ?`,
+		lock.Seconds(), scope, subject).Error; err != nil {
+		log.Printf("failed to lock %s %s: %v", scope, subject, err)
+	}
+	return throttle.FailedAttempts
+}
+
+// resetLoginThrottle clears the account counters after a successful login.
+// The IP counter is left to expire on its own so that one valid account
+// cannot be used to reset the throttle for a whole address.
+func (r *AuthRepository) resetLoginThrottle(email string) {
+	if err := r.DB.Exec(`
+		DELETE FROM login_throttles 
+		WHERE scope = ? AND subject = ?`,
+		throttleScopeAccount, accountSubject(email)).Error; err != nil {
+		log.Printf("failed to reset login attempts: %v", err)
+	}
+}
+
+// resetIPThrottle clears the counters of a source IP when an operator unlocks
+// it explicitly.
+func (r *AuthRepository) resetIPThrottle(ip string) {
+	if err := r.DB.Exec(`
+		DELETE FROM login_throttles 
+		WHERE scope = ? AND subject = ?`,
+		throttleScopeIP, ip).Error; err != nil {
+		log.Printf("failed to reset login attempts for %s: %v", ip, err)
+	}
+}
+
+func accountSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func lockoutTestRepository(t *testing.T) (*AuthRepository, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	repo := NewAuthRepository(db)
+	repo.Lockout = utils.LockoutPolicy{Threshold: 3, IPThreshold: 10, Window: 15 * time.Minute, BaseLock: time.Minute, MaxLock: time.Hour}
+	return repo, mock
+}
+
+func TestCheckLoginThrottle(t *testing.T) {
+	repo, mock := lockoutTestRepository(t)
+	mock.ExpectQuery(`SELECT MAX\(locked_until\) AS locked_until\s+FROM login_throttles`).
+		WithArgs(throttleScopeAccount, "user@example.com", throttleScopeIP, "203.0.113.7").
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(time.Now().Add(90 * time.Second)))
+
+	err := repo.checkLoginThrottle(" User@Example.com ", "203.0.113.7")
+	appErr, ok := err.(*models.AppError)
+	if !ok || appErr.Code != "account_locked" {
+		t.Fatalf("err = %v, want account_locked", err)
+	}
+	if appErr.RetryAfter < 89 || appErr.RetryAfter > 90 {
+		t.Errorf("retry after %ds, want 90", appErr.RetryAfter)
+	}
+
+	repo, mock = lockoutTestRepository(t)
+	mock.ExpectQuery(`FROM login_throttles`).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(nil))
+	if err := repo.checkLoginThrottle("user@example.com", "203.0.113.7"); err != nil {
+		t.Fatalf("err = %v for an unlocked account", err)
+	}
+}
+
+func TestRecordFailedLoginLocksAtThreshold(t *testing.T) {
+	repo, mock := lockoutTestRepository(t)
+	client := models.ClientInfo{IPAddress: "203.0.113.7"}
+
+	mock.ExpectQuery(`INSERT INTO login_throttles`).
+		WithArgs(throttleScopeAccount, "user@example.com", repo.Lockout.Window.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts", "locked_until"}).AddRow(3, nil))
+	mock.ExpectExec(`UPDATE login_throttles\s+SET locked_until = NOW\(\) \+ make_interval\(secs => \$1\)`).
+		WithArgs(time.Minute.Seconds(), throttleScopeAccount, "user@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO login_throttles`).
+		WithArgs(throttleScopeIP, "203.0.113.7", repo.Lockout.Window.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts", "locked_until"}).AddRow(3, nil))
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(nil, models.EventAccountLocked, "203.0.113.7", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo.recordFailedLogin("user@example.com", client)
+}
+
+func TestUnlockAccount(t *testing.T) {
+	userID := uuid.New()
+	expectUser := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`FROM users WHERE id = \$1`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(userID, "User@Example.com"))
+		mock.ExpectExec(`DELETE FROM login_throttles\s+WHERE scope = \$1 AND subject = \$2`).
+			WithArgs(throttleScopeAccount, "user@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("account only", func(t *testing.T) {
+		repo, mock := lockoutTestRepository(t)
+		expectUser(mock)
+		mock.ExpectExec(`INSERT INTO security_events`).
+			WithArgs(userID, models.EventAccountUnlocked, sqlmock.AnyArg(), sqlmock.AnyArg(), "{}").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := repo.UnlockAccount(userID, "", models.ClientInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("account and address", func(t *testing.T) {
+		repo, mock := lockoutTestRepository(t)
+		expectUser(mock)
+		mock.ExpectExec(`DELETE FROM login_throttles\s+WHERE scope = \$1 AND subject = \$2`).
+			WithArgs(throttleScopeIP, "203.0.113.7").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO security_events`).
+			WithArgs(userID, models.EventAccountUnlocked, sqlmock.AnyArg(), sqlmock.AnyArg(), `{"ip":"203.0.113.7"}`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := repo.UnlockAccount(userID, "203.0.113.7", models.ClientInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
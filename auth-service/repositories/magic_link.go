@@ -0,0 +1,108 @@
+package repositories
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/google/uuid"
+)
+
+// RequestMagicLink issues a sign-in link for the account, or nil when no
+// account uses the email. The link is bound to browserNonce, which the caller
+// keeps in a cookie on the requesting browser.
+func (r *AuthRepository) RequestMagicLink(email, browserNonce string) (*models.MagicLink, error) {
+	var user models.User
+	result := r.DB.Raw("SELECT id, name, email FROM users WHERE email = ?", email).Scan(&user)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find user")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	linkID, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate magic link")
+	}
+
+	expiresAt := utils.TokenExpiryTime(config.Current().Tokens.MagicLink)
+	token, err := utils.SignClaims(utils.MagicLinkPurpose, utils.SignedClaims{
+		Subject:   user.ID.String(),
+		Email:     user.Email,
+		Nonce:     linkID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate magic link")
+	}
+
+	if err := r.DB.Exec(`
+		INSERT INTO magic_links (link_hash, browser_nonce_hash, user_id, expires_at)
+		VALUES (?, ?, ?, ?)`,
+		utils.HashToken(linkID), utils.HashToken(browserNonce), user.ID, expiresAt).Error; err != nil {
+		return nil, models.New("token_storage_failed", "failed to store magic link")
+	}
+
+	return &models.MagicLink{
+		User:      &user,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// VerifyMagicLink consumes a link opened in the browser that requested it.
+// Receiving the link proves ownership of the address, so an unverified email
+// is marked verified. Accounts with a second factor still get an MFA
+// challenge instead of tokens.
+func (r *AuthRepository) VerifyMagicLink(tokenString, browserNonce string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	if browserNonce == "" {
+		return nil, models.ErrMagicLinkInvalid
+	}
+
+	claims, err := utils.VerifyClaims(utils.MagicLinkPurpose, tokenString)
+	if err != nil {
+		return nil, models.ErrMagicLinkInvalid
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, models.ErrMagicLinkInvalid
+	}
+
+	result := r.DB.Exec(`
+		UPDATE magic_links
+		SET used_at = NOW()
+		WHERE link_hash = ? AND browser_nonce_hash = ? AND user_id = ?
+		AND used_at IS NULL AND expires_at > NOW()`,
+		utils.HashToken(claims.Nonce), utils.HashToken(browserNonce), userID)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to verify magic link")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrMagicLinkInvalid
+	}
+
+	var user models.User
+	result = r.DB.Raw(`
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = ? AND email = ?
+		RETURNING id, name, email, email_verified_at, created_at, updated_at`,
+		userID, claims.Email).Scan(&user)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to authenticate user")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrMagicLinkInvalid
+	}
+
+	methods, err := r.secondFactors(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) > 0 {
+		return r.startMFAChallenge(&user, methods)
+	}
+
+	return r.issueTokens(&user, uuid.Nil, client)
+}
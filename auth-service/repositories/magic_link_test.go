@@ -0,0 +1,196 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestRequestMagicLinkUnknownEmail(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`SELECT id, name, email FROM users WHERE email = \$1`).
+		WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	link, err := NewAuthRepository(db).RequestMagicLink("nobody@example.com", "browser-nonce")
+	if link != nil || err != nil {
+		t.Fatalf("RequestMagicLink = %v, %v; want nothing to send", link, err)
+	}
+}
+
+func TestRequestMagicLinkStoresHashes(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	userID := uuid.New()
+
+	var linkHash string
+	mock.ExpectQuery(`SELECT id, name, email FROM users WHERE email = \$1`).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(userID, "User", "user@example.com"))
+	mock.ExpectExec(`INSERT INTO magic_links \(link_hash, browser_nonce_hash, user_id, expires_at\)`).
+		WithArgs(argFunc(func(v driver.Value) bool {
+			linkHash, _ = v.(string)
+			return true
+		}), utils.HashToken("browser-nonce"), userID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	link, err := NewAuthRepository(db).RequestMagicLink("user@example.com", "browser-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := utils.VerifyClaims(utils.MagicLinkPurpose, link.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != userID.String() || linkHash != utils.HashToken(claims.Nonce) {
+		t.Errorf("claims = %+v, stored hash %q", claims, linkHash)
+	}
+	if lifetime := time.Until(link.ExpiresAt); lifetime <= 0 || lifetime > 10*time.Minute {
+		t.Errorf("link lives for %s, want at most the configured ten minutes", lifetime)
+	}
+}
+
+// magicLinkFixture is a link sent to user and requested by the browser that
+// holds nonce.
+type magicLinkFixture struct {
+	user  *models.User
+	token string
+	nonce string
+}
+
+func newMagicLinkFixture(t *testing.T) magicLinkFixture {
+	t.Helper()
+	user := &models.User{ID: uuid.New(), Name: "User", Email: "user@example.com"}
+	token, err := utils.SignClaims(utils.MagicLinkPurpose, utils.SignedClaims{
+		Subject:   user.ID.String(),
+		Email:     user.Email,
+		Nonce:     "link-id",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return magicLinkFixture{user: user, token: token, nonce: "browser-nonce"}
+}
+
+// expectConsume expects the link to be marked used from browserNonce; rows is
+// how many pending links match.
+func (f magicLinkFixture) expectConsume(mock sqlmock.Sqlmock, browserNonce string, rows int64) {
+	mock.ExpectExec(`UPDATE magic_links\s+SET used_at = NOW\(\)\s+WHERE link_hash = \$1 AND browser_nonce_hash = \$2 AND user_id = \$3\s+AND used_at IS NULL AND expires_at > NOW\(\)`).
+		WithArgs(utils.HashToken("link-id"), utils.HashToken(browserNonce), f.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, rows))
+}
+
+func (f magicLinkFixture) expectUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`UPDATE users\s+SET email_verified_at = COALESCE\(email_verified_at, NOW\(\)\)\s+WHERE id = \$1 AND email = \$2`).
+		WithArgs(f.user.ID, f.user.Email).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "email_verified_at"}).
+			AddRow(f.user.ID, f.user.Name, f.user.Email, time.Now()))
+}
+
+func expectSecondFactors(mock sqlmock.Sqlmock, userID uuid.UUID, passkeys int) {
+	mock.ExpectQuery(`FROM user_mfa WHERE user_id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM webauthn_credentials WHERE user_id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(passkeys))
+}
+
+func TestVerifyMagicLinkIssuesTokens(t *testing.T) {
+	useConfig(t, nil)
+	useKeySet(t)
+	db, mock := newMockDB(t)
+	f := newMagicLinkFixture(t)
+
+	f.expectConsume(mock, f.nonce, 1)
+	f.expectUser(mock)
+	expectSecondFactors(mock, f.user.ID, 0)
+	expectIssueTokens(mock, f.user.ID)
+
+	result, err := NewAuthRepository(db).VerifyMagicLink(f.token, f.nonce, models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AccessToken == "" || result.RefreshToken == nil || result.MFAChallenge != nil {
+		t.Errorf("result = %+v, want session tokens", result)
+	}
+	if result.User.EmailVerifiedAt == nil {
+		t.Error("opening the link did not verify the email")
+	}
+}
+
+func TestVerifyMagicLinkStartsMFAChallenge(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	f := newMagicLinkFixture(t)
+
+	f.expectConsume(mock, f.nonce, 1)
+	f.expectUser(mock)
+	expectSecondFactors(mock, f.user.ID, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM mfa_challenges`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO mfa_challenges`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := NewAuthRepository(db).VerifyMagicLink(f.token, f.nonce, models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MFAChallenge == nil || result.AccessToken != "" || result.RefreshToken != nil {
+		t.Errorf("result = %+v, want only an MFA challenge", result)
+	}
+}
+
+func TestVerifyMagicLinkRejects(t *testing.T) {
+	useConfig(t, nil)
+	f := newMagicLinkFixture(t)
+	verification, err := utils.GenerateEmailVerificationToken(f.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		nonce  string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{"no nonce cookie", f.token, "", nil},
+		{"email verification token", verification, f.nonce, nil},
+		{"tampered token", f.token + "x", f.nonce, nil},
+		// A forwarded link is opened without the requesting browser's nonce.
+		{"other browser", f.token, "other-nonce", func(mock sqlmock.Sqlmock) {
+			f.expectConsume(mock, "other-nonce", 0)
+		}},
+		// The link was already used, or expired, so no pending row matches.
+		{"already used", f.token, f.nonce, func(mock sqlmock.Sqlmock) {
+			f.expectConsume(mock, f.nonce, 0)
+		}},
+		{"email changed since", f.token, f.nonce, func(mock sqlmock.Sqlmock) {
+			f.expectConsume(mock, f.nonce, 1)
+			mock.ExpectQuery(`UPDATE users`).
+				WithArgs(f.user.ID, f.user.Email).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			if _, err := NewAuthRepository(db).VerifyMagicLink(tt.token, tt.nonce, models.ClientInfo{}); err != models.ErrMagicLinkInvalid {
+				t.Fatalf("err = %v, want %v", err, models.ErrMagicLinkInvalid)
+			}
+		})
+	}
+}
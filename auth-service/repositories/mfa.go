@@ -0,0 +1,434 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"time"
+)
+
+type userMFA struct {
+	TOTPSecret   string `gorm:"column:totp_secret"`
+	EnabledAt    *time.Time
+	LastUsedStep *int64
+}
+
+// findUserMFA returns the user's TOTP enrollment, or nil when there is none.
+func (r *AuthRepository) findUserMFA(userID uuid.UUID) (*userMFA, error) {
+	var mfa userMFA
+	result := r.DB.Raw(`
+		SELECT totp_secret, enabled_at, last_used_step
+		FROM user_mfa WHERE user_id = ?`, userID).Scan(&mfa)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to load two-factor settings")
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &mfa, nil
+}
+
+func (r *AuthRepository) totpEnabled(userID uuid.UUID) (bool, error) {
+	mfa, err := r.findUserMFA(userID)
+	if err != nil {
+		return false, err
+	}
+	return mfa != nil && mfa.EnabledAt != nil, nil
+}
+
+// secondFactors lists the second factor methods the user can complete a
+// password login with. An empty list means no second factor is required.
+func (r *AuthRepository) secondFactors(userID uuid.UUID) ([]string, error) {
+	var methods []string
+
+	enabled, err := r.totpEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		methods = append(methods, models.MFAMethodTOTP)
+	}
+
+	var passkeys int64
+	if err := r.DB.Raw("SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = ?", userID).Scan(&passkeys).Error; err != nil {
+		return nil, models.New("database_error", "failed to load two-factor settings")
+	}
+	if passkeys > 0 {
+		methods = append(methods, models.MFAMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+// EnrollTOTP generates a fresh secret for the user. The secret only takes
+// effect once ConfirmTOTP proves the authenticator was set up correctly;
+// enrolling again before confirming replaces the pending secret.
+func (r *AuthRepository) EnrollTOTP(userID uuid.UUID) (*models.TOTPEnrollment, error) {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := r.totpEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, models.New("mfa_enroll_failed", "failed to generate secret")
+	}
+
+	encrypted, err := utils.Encrypt([]byte(secret))
+	if err != nil {
+		return nil, models.New("mfa_enroll_failed", "failed to encrypt secret")
+	}
+
+	if err := r.DB.Exec(`
+		INSERT INTO user_mfa (user_id, totp_secret)
+		VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			totp_secret = EXCLUDED.totp_secret,
+			last_used_step = NULL,
+			updated_at = NOW()
+		WHERE user_mfa.enabled_at IS NULL`,
+		userID, encrypted).Error; err != nil {
+		return nil, models.New("mfa_enroll_failed", "failed to store secret")
+	}
+
+	return &models.TOTPEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(secret, user.Email),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves they can
+// generate codes, and returns a fresh set of recovery codes. The codes are
+// only ever shown here; the database keeps their hashes.
+func (r *AuthRepository) ConfirmTOTP(userID uuid.UUID, code string, client models.ClientInfo) ([]string, error) {
+	mfa, err := r.findUserMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil {
+		return nil, models.ErrMFANotEnrolled
+	}
+	if mfa.EnabledAt != nil {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+
+	if err := r.useTOTPCode(userID, mfa, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(utils.RecoveryCodeCount)
+	if err != nil {
+		return nil, models.New("mfa_enroll_failed", "failed to generate recovery codes")
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`
+			UPDATE user_mfa SET enabled_at = NOW(), updated_at = NOW()
+			WHERE user_id = ? AND enabled_at IS NULL`, userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrMFAAlreadyEnabled
+		}
+
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+	if err != nil {
+		if appErr, ok := err.(*models.AppError); ok {
+			return nil, appErr
+		}
+		return nil, models.New("mfa_enroll_failed", "failed to enable two-factor authentication")
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventMFAEnabled,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+
+	return codes, nil
+}
+
+// DisableTOTP removes the second factor. Both the password and a current
+// code (or a recovery code) are required so a stolen access token alone
+// cannot downgrade the account. Wrong answers count towards the login
+// lockout, so the endpoint cannot be used to guess either of them.
+func (r *AuthRepository) DisableTOTP(userID uuid.UUID, password, code string, client models.ClientInfo) error {
+	var user models.User
+	result := r.DB.Raw("SELECT id, email, password_hash FROM users WHERE id = ?", userID).Scan(&user)
+	if result.Error != nil {
+		return models.New("database_error", "failed to find user")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrUnauthorized
+	}
+
+	if err := r.checkLoginThrottle(user.Email, client.IPAddress); err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		r.recordFailedLogin(user.Email, client)
+		return models.ErrWrongPassword
+	}
+
+	mfa, err := r.findUserMFA(userID)
+	if err != nil {
+		return err
+	}
+	if mfa == nil || mfa.EnabledAt == nil {
+		return models.ErrMFANotEnrolled
+	}
+
+	if err := r.verifySecondFactor(userID, mfa, code, client); err != nil {
+		if err == models.ErrMFAInvalidCode {
+			r.recordFailedLogin(user.Email, client)
+		}
+		return err
+	}
+	r.resetLoginThrottle(user.Email)
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM user_mfa WHERE user_id = ?", userID).Error
+	})
+	if err != nil {
+		return models.New("mfa_disable_failed", "failed to disable two-factor authentication")
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventMFADisabled,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+
+	return nil
+}
+
+// LoginMFA completes a login started by Login when the account has two-factor
+// authentication enabled. Wrong codes count towards the same lockout as wrong
+// passwords; a correct one uses up the challenge.
+func (r *AuthRepository) LoginMFA(challengeToken, code string, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	claims, userID, err := r.verifyMFAChallenge(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.checkLoginThrottle(claims.Email, client.IPAddress); err != nil {
+		return nil, err
+	}
+
+	user, err := r.findChallengedUser(userID, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := r.findUserMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil || mfa.EnabledAt == nil {
+		return nil, models.ErrMFAChallengeInvalid
+	}
+
+	if err := r.verifySecondFactor(userID, mfa, code, client); err != nil {
+		if err == models.ErrMFAInvalidCode {
+			r.recordFailedLogin(claims.Email, client)
+		}
+		return nil, err
+	}
+
+	if err := r.consumeMFAChallenge(claims, userID); err != nil {
+		return nil, err
+	}
+	r.resetLoginThrottle(claims.Email)
+
+	return r.issueTokens(user, uuid.Nil, client)
+}
+
+// verifyMFAChallenge returns the user a challenge token from Login was
+// issued for. The challenge must not have been completed yet.
+func (r *AuthRepository) verifyMFAChallenge(challengeToken string) (*utils.SignedClaims, uuid.UUID, error) {
+	claims, err := utils.VerifyClaims(utils.MFAChallengePurpose, challengeToken)
+	if err != nil {
+		return nil, uuid.Nil, models.ErrMFAChallengeInvalid
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, uuid.Nil, models.ErrMFAChallengeInvalid
+	}
+
+	var pending int64
+	if err := r.DB.Raw(`
+		SELECT COUNT(*) FROM mfa_challenges
+		WHERE nonce_hash = ? AND user_id = ? AND expires_at > NOW()`,
+		utils.HashToken(claims.Nonce), userID).Scan(&pending).Error; err != nil {
+		return nil, uuid.Nil, models.New("database_error", "failed to verify MFA challenge")
+	}
+	if pending == 0 {
+		return nil, uuid.Nil, models.ErrMFAChallengeInvalid
+	}
+	return claims, userID, nil
+}
+
+// consumeMFAChallenge deletes the challenge once the second factor has been
+// verified. Deleting makes every challenge single-use, even when two requests
+// race with different codes.
+func (r *AuthRepository) consumeMFAChallenge(claims *utils.SignedClaims, userID uuid.UUID) error {
+	result := r.DB.Exec(`
+		DELETE FROM mfa_challenges
+		WHERE nonce_hash = ? AND user_id = ? AND expires_at > NOW()`,
+		utils.HashToken(claims.Nonce), userID)
+	if result.Error != nil {
+		return models.New("database_error", "failed to verify MFA challenge")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrMFAChallengeInvalid
+	}
+	return nil
+}
+
+// findChallengedUser loads the user an MFA challenge was issued for. The
+// email must still match so a challenge does not survive an email change.
+func (r *AuthRepository) findChallengedUser(userID uuid.UUID, email string) (*models.User, error) {
+	var user models.User
+	result := r.DB.Raw(`
+		SELECT id, name, email, email_verified_at, created_at, updated_at
+		FROM users WHERE id = ? AND email = ?`, userID, email).Scan(&user)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to authenticate user")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrMFAChallengeInvalid
+	}
+	return &user, nil
+}
+
+// startMFAChallenge is returned by Login in place of tokens when the password
+// was correct but a second factor is still required.
+func (r *AuthRepository) startMFAChallenge(user *models.User, methods []string) (*models.AuthenticationResult, error) {
+	nonce, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate MFA challenge")
+	}
+
+	token, expiresAt, err := utils.GenerateMFAChallengeToken(user, nonce)
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate MFA challenge")
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM mfa_challenges WHERE expires_at < NOW()").Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			INSERT INTO mfa_challenges (nonce_hash, user_id, expires_at)
+			VALUES (?, ?, ?)`,
+			utils.HashToken(nonce), user.ID, expiresAt).Error
+	})
+	if err != nil {
+		return nil, models.New("token_storage_failed", "failed to store MFA challenge")
+	}
+
+	return &models.AuthenticationResult{
+		User: user,
+		MFAChallenge: &models.MFAChallenge{
+			Token:     token,
+			ExpiresAt: expiresAt,
+			Methods:   methods,
+		},
+	}, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+func (r *AuthRepository) verifySecondFactor(userID uuid.UUID, mfa *userMFA, code string, client models.ClientInfo) error {
+	err := r.useTOTPCode(userID, mfa, code)
+	if err != models.ErrMFAInvalidCode {
+		return err
+	}
+
+	return r.useRecoveryCode(userID, code, client)
+}
+
+// useTOTPCode validates a code and records its time step. The conditional
+// update makes each step usable once, even across concurrent requests.
+func (r *AuthRepository) useTOTPCode(userID uuid.UUID, mfa *userMFA, code string) error {
+	secret, err := utils.Decrypt(mfa.TOTPSecret)
+	if err != nil {
+		return models.New("mfa_verify_failed", "failed to decrypt secret")
+	}
+
+	step, ok := utils.ValidateTOTP(string(secret), code, time.Now())
+	if !ok {
+		return models.ErrMFAInvalidCode
+	}
+
+	result := r.DB.Exec(`
+		UPDATE user_mfa SET last_used_step = ?, updated_at = NOW()
+		WHERE user_id = ? AND (last_used_step IS NULL OR last_used_step < ?)`,
+		step, userID, step)
+	if result.Error != nil {
+		return models.New("database_error", "failed to verify code")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrMFAInvalidCode
+	}
+	return nil
+}
+
+func (r *AuthRepository) useRecoveryCode(userID uuid.UUID, code string, client models.ClientInfo) error {
+	normalized := utils.NormalizeRecoveryCode(code)
+	if normalized == "" {
+		return models.ErrMFAInvalidCode
+	}
+
+	result := r.DB.Exec(`
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, utils.HashToken(normalized))
+	if result.Error != nil {
+		return models.New("database_error", "failed to verify code")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrMFAInvalidCode
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventRecoveryCodeUsed,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	})
+	return nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID, codes []string) error {
+	if err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = ?", userID).Error; err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		if err := tx.Exec(`
+			INSERT INTO mfa_recovery_codes (user_id, code_hash)
+			VALUES (?, ?)`, userID, utils.HashToken(utils.NormalizeRecoveryCode(code))).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+func TestStartMFAChallengeStoresNonce(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	user := &models.User{ID: uuid.New(), Email: "user@example.com"}
+
+	var storedHash string
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM mfa_challenges WHERE expires_at < NOW\(\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO mfa_challenges \(nonce_hash, user_id, expires_at\)`).
+		WithArgs(argFunc(func(v driver.Value) bool {
+			storedHash, _ = v.(string)
+			return true
+		}), user.ID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := NewAuthRepository(db).startMFAChallenge(user, []string{models.MFAMethodTOTP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := utils.VerifyClaims(utils.MFAChallengePurpose, result.MFAChallenge.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Nonce == "" || storedHash != utils.HashToken(claims.Nonce) {
+		t.Errorf("stored %q, want the hash of the challenge nonce", storedHash)
+	}
+	if result.AccessToken != "" || result.RefreshToken != nil {
+		t.Error("tokens issued before the second factor")
+	}
+}
+
+// mfaLoginFixture is a user with TOTP enabled and a pending challenge.
+type mfaLoginFixture struct {
+	user      *models.User
+	challenge string
+	nonceHash string
+	secret    string
+}
+
+func newMFALoginFixture(t *testing.T) mfaLoginFixture {
+	t.Helper()
+	user := &models.User{ID: uuid.New(), Email: "user@example.com"}
+	nonce := "challenge-nonce"
+	challenge, _, err := utils.GenerateMFAChallengeToken(user, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := utils.Encrypt([]byte("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfaLoginFixture{user: user, challenge: challenge, nonceHash: utils.HashToken(nonce), secret: secret}
+}
+
+func (f mfaLoginFixture) expectPending(mock sqlmock.Sqlmock, pending int) {
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM mfa_challenges\s+WHERE nonce_hash = \$1 AND user_id = \$2 AND expires_at > NOW\(\)`).
+		WithArgs(f.nonceHash, f.user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(pending))
+}
+
+// expectRecoveryCode expects everything up to and including a correct
+// recovery code.
+func (f mfaLoginFixture) expectRecoveryCode(mock sqlmock.Sqlmock) {
+	f.expectPending(mock, 1)
+	mock.ExpectQuery(`FROM login_throttles`).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(nil))
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND email = \$2`).
+		WithArgs(f.user.ID, f.user.Email).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(f.user.ID, f.user.Email))
+	mock.ExpectQuery(`FROM user_mfa WHERE user_id = \$1`).
+		WithArgs(f.user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "enabled_at"}).AddRow(f.secret, time.Now()))
+	mock.ExpectExec(`UPDATE mfa_recovery_codes SET used_at = NOW\(\)`).
+		WithArgs(f.user.ID, utils.HashToken("abcdefgh")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(f.user.ID, models.EventRecoveryCodeUsed, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestLoginMFAChallengeIsSingleUse(t *testing.T) {
+	useConfig(t, nil)
+	useKeySet(t)
+	fixture := newMFALoginFixture(t)
+
+	t.Run("completed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		fixture.expectRecoveryCode(mock)
+		mock.ExpectExec(`DELETE FROM mfa_challenges\s+WHERE nonce_hash = \$1 AND user_id = \$2`).
+			WithArgs(fixture.nonceHash, fixture.user.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM login_throttles`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectIssueTokens(mock, fixture.user.ID)
+
+		result, err := NewAuthRepository(db).LoginMFA(fixture.challenge, "abcd-efgh", models.ClientInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.AccessToken == "" {
+			t.Error("no access token issued")
+		}
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		fixture.expectPending(mock, 0)
+
+		if _, err := NewAuthRepository(db).LoginMFA(fixture.challenge, "abcd-efgh", models.ClientInfo{}); err != models.ErrMFAChallengeInvalid {
+			t.Fatalf("err = %v, want %v", err, models.ErrMFAChallengeInvalid)
+		}
+	})
+
+	t.Run("completed concurrently", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		fixture.expectRecoveryCode(mock)
+		mock.ExpectExec(`DELETE FROM mfa_challenges`).
+			WithArgs(fixture.nonceHash, fixture.user.ID).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		if _, err := NewAuthRepository(db).LoginMFA(fixture.challenge, "abcd-efgh", models.ClientInfo{}); err != models.ErrMFAChallengeInvalid {
+			t.Fatalf("err = %v, want %v", err, models.ErrMFAChallengeInvalid)
+		}
+	})
+}
+
+func TestUseTOTPCodeRejectsReplayedStep(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	userID := uuid.New()
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := utils.Encrypt([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The conditional update matches nothing once the step was recorded.
+	mock.ExpectExec(`UPDATE user_mfa SET last_used_step = \$1, updated_at = NOW\(\)\s+WHERE user_id = \$2 AND \(last_used_step IS NULL OR last_used_step < \$3\)`).
+		WithArgs(sqlmock.AnyArg(), userID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = NewAuthRepository(db).useTOTPCode(userID, &userMFA{TOTPSecret: encrypted}, currentTOTP(t, secret))
+	if err != models.ErrMFAInvalidCode {
+		t.Fatalf("err = %v, want %v", err, models.ErrMFAInvalidCode)
+	}
+}
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	repo := NewAuthRepository(db)
+	userID := uuid.New()
+	hash := utils.HashToken("abcdefgh")
+
+	mock.ExpectExec(`UPDATE mfa_recovery_codes SET used_at = NOW\(\)\s+WHERE user_id = \$1 AND code_hash = \$2 AND used_at IS NULL`).
+		WithArgs(userID, hash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE mfa_recovery_codes`).
+		WithArgs(userID, hash).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := repo.useRecoveryCode(userID, "ABCD-EFGH", models.ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.useRecoveryCode(userID, "abcd efgh", models.ClientInfo{}); err != models.ErrMFAInvalidCode {
+		t.Fatalf("second use err = %v, want %v", err, models.ErrMFAInvalidCode)
+	}
+	if err := repo.useRecoveryCode(userID, " - ", models.ClientInfo{}); err != models.ErrMFAInvalidCode {
+		t.Fatalf("empty code err = %v, want %v", err, models.ErrMFAInvalidCode)
+	}
+}
+
+func TestDisableTOTPFeedsLoginThrottle(t *testing.T) {
+	useConfig(t, nil)
+	userID := uuid.New()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := models.ClientInfo{IPAddress: "203.0.113.7"}
+	expectUser := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT id, email, password_hash FROM users WHERE id = \$1`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash"}).AddRow(userID, "user@example.com", string(hash)))
+	}
+
+	t.Run("locked", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectUser(mock)
+		mock.ExpectQuery(`FROM login_throttles`).
+			WithArgs(throttleScopeAccount, "user@example.com", throttleScopeIP, client.IPAddress).
+			WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(time.Now().Add(time.Minute)))
+
+		err := NewAuthRepository(db).DisableTOTP(userID, "password", "123456", client)
+		if appErr, ok := err.(*models.AppError); !ok || appErr.Code != "account_locked" {
+			t.Fatalf("err = %v, want account_locked", err)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		repo := NewAuthRepository(db)
+		repo.Lockout = utils.LockoutPolicy{Threshold: 5, IPThreshold: 20, Window: time.Minute, BaseLock: time.Minute, MaxLock: time.Hour}
+		expectUser(mock)
+		mock.ExpectQuery(`FROM login_throttles`).
+			WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(nil))
+		mock.ExpectQuery(`INSERT INTO login_throttles`).
+			WithArgs(throttleScopeAccount, "user@example.com", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(1))
+		mock.ExpectQuery(`INSERT INTO login_throttles`).
+			WithArgs(throttleScopeIP, client.IPAddress, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(1))
+
+		if err := repo.DisableTOTP(userID, "guess", "123456", client); err != models.ErrWrongPassword {
+			t.Fatalf("err = %v, want %v", err, models.ErrWrongPassword)
+		}
+	})
+}
+
+// currentTOTP computes the code an authenticator would show now, following
+// RFC 6238 independently of the implementation under test.
+func currentTOTP(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1_000_000)
+}
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).
+		WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	reset, err := NewAuthRepository(db).RequestPasswordReset("nobody@example.com")
+	if reset != nil || err != nil {
+		t.Fatalf("reset = %v, err = %v; want nothing, so unknown emails are not revealed", reset, err)
+	}
+}
+
+func TestRequestPasswordResetStoresHash(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	userID := uuid.New()
+
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(userID, "User", "user@example.com"))
+	var storedHash string
+	mock.ExpectExec(`INSERT INTO password_reset_tokens`).
+		WithArgs(argFunc(func(v driver.Value) bool {
+			storedHash, _ = v.(string)
+			return true
+		}), userID, around(time.Now().Add(30*time.Minute))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	reset, err := NewAuthRepository(db).RequestPasswordReset("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedHash != utils.HashToken(reset.Token) {
+		t.Errorf("stored %q, want the hash of the emailed token", storedHash)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	userID := uuid.New()
+	token := "reset-token"
+
+	t.Run("weak password is rejected before the token is used", func(t *testing.T) {
+		useConfig(t, nil)
+		db, _ := newMockDB(t)
+		if err := NewAuthRepository(db).ResetPassword(token, "short", models.ClientInfo{}); err != models.ErrWeakPassword {
+			t.Fatalf("err = %v, want %v", err, models.ErrWeakPassword)
+		}
+	})
+
+	t.Run("used or expired token", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE password_reset_tokens\s+SET used_at = NOW\(\)\s+WHERE token_hash = \$1 AND used_at IS NULL AND expires_at > NOW\(\)`).
+			WithArgs(utils.HashToken(token)).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+		mock.ExpectRollback()
+
+		if err := NewAuthRepository(db).ResetPassword(token, "new-password", models.ClientInfo{}); err != models.ErrResetTokenInvalid {
+			t.Fatalf("err = %v, want %v", err, models.ErrResetTokenInvalid)
+		}
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		useConfig(t, nil)
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE password_reset_tokens`).
+			WithArgs(utils.HashToken(token)).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(userID))
+		mock.ExpectExec(`UPDATE users SET password_hash = \$1 WHERE id = \$2`).
+			WithArgs(argFunc(func(v driver.Value) bool {
+				hash, _ := v.(string)
+				return bcrypt.CompareHashAndPassword([]byte(hash), []byte("new-password")) == nil
+			}), userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE password_reset_tokens\s+SET used_at = NOW\(\)\s+WHERE user_id = \$1 AND used_at IS NULL`).
+			WithArgs(userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE refresh_tokens\s+SET is_revoked = true\s+WHERE user_id = \$1`).
+			WithArgs(userID).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+		mock.ExpectExec(`INSERT INTO security_events`).
+			WithArgs(userID, models.EventPasswordReset, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := NewAuthRepository(db).ResetPassword(token, "new-password", models.ClientInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
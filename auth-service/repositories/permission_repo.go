@@ -0,0 +1,149 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+)
+
+// permissionNamePattern accepts names such as "orders:read". Permissions and
+// scopes are compared exactly, so wildcards like "orders:*" are rejected
+// rather than stored as a name that never matches anything.
+var permissionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*(:[a-z0-9_-]+)*$`)
+
+const maxPermissionNameLength = 128
+
+type PermissionRepository struct {
+	DB *gorm.DB
+}
+
+var _ PermissionRepositoryInterface = (*PermissionRepository)(nil)
+
+func NewPermissionRepository(db *gorm.DB) *PermissionRepository {
+	return &PermissionRepository{DB: db}
+}
+
+func (r *PermissionRepository) ListPermissions() ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	if err := r.DB.Raw(`
+		SELECT id, name, COALESCE(description, '') AS description, created_at, updated_at
+		FROM permissions ORDER BY name`).Scan(&permissions).Error; err != nil {
+		return nil, models.New("database_error", "failed to list permissions")
+	}
+	return permissions, nil
+}
+
+func (r *PermissionRepository) CreatePermission(name, description string) (*models.Permission, error) {
+	name = normalizePermissionName(name)
+	if len(name) > maxPermissionNameLength || !permissionNamePattern.MatchString(name) {
+		return nil, models.ErrInvalidInput
+	}
+
+	var permission models.Permission
+	err := r.DB.Raw(`
+		INSERT INTO permissions (name, description) 
+		VALUES (?, ?) 
+		RETURNING id, name, COALESCE(description, '') AS description, created_at, updated_at`,
+		name, description).Scan(&permission).Error
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, models.ErrPermissionExists
+		}
+		return nil, models.New("database_error", "failed to create permission")
+	}
+	return &permission, nil
+}
+
+func (r *PermissionRepository) DeletePermission(permissionID uuid.UUID) error {
+	result := r.DB.Exec("DELETE FROM permissions WHERE id = ?", permissionID)
+	if result.Error != nil {
+		return models.New("database_error", "failed to delete permission")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrPermissionNotFound
+	}
+	return nil
+}
+
+func (r *PermissionRepository) GetRolePermissions(roleID uuid.UUID) ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	if err := r.DB.Raw(`
+		SELECT p.id, p.name, COALESCE(p.description, '') AS description, p.created_at, p.updated_at
+		FROM role_permissions rp
+		JOIN permissions p ON rp.permission_id = p.id
+		WHERE rp.role_id = ?
+		ORDER BY p.name`, roleID).Scan(&permissions).Error; err != nil {
+		return nil, models.New("database_error", "failed to load role permissions")
+	}
+	return permissions, nil
+}
+
+func (r *PermissionRepository) AttachPermission(roleID uuid.UUID, permissionName string) error {
+	permissionName = normalizePermissionName(permissionName)
+
+	var counts struct {
+		Roles       int64
+		Permissions int64
+	}
+	if err := r.DB.Raw(`
+		SELECT
+			(SELECT COUNT(*) FROM roles WHERE id = ?) AS roles,
+			(SELECT COUNT(*) FROM permissions WHERE name = ?) AS permissions`,
+		roleID, permissionName).Scan(&counts).Error; err != nil {
+		return models.New("database_error", "failed to attach permission")
+	}
+	if counts.Roles == 0 {
+		return models.ErrRoleNotFound
+	}
+	if counts.Permissions == 0 {
+		return models.ErrPermissionNotFound
+	}
+
+	if err := r.DB.Exec(`
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT ?, id FROM permissions WHERE name = ?
+		ON CONFLICT (role_id, permission_id) DO NOTHING`, roleID, permissionName).Error; err != nil {
+		return models.New("database_error", "failed to attach permission")
+	}
+	return nil
+}
+
+func (r *PermissionRepository) DetachPermission(roleID uuid.UUID, permissionName string) error {
+	result := r.DB.Exec(`
+		DELETE FROM role_permissions
+		WHERE role_id = ? AND permission_id = (SELECT id FROM permissions WHERE name = ?)`,
+		roleID, normalizePermissionName(permissionName))
+	if result.Error != nil {
+		return models.New("database_error", "failed to detach permission")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrPermissionNotAssigned
+	}
+	return nil
+}
+
+func (r *PermissionRepository) ResolveUserPermissions(userID uuid.UUID) ([]string, error) {
+	return resolveUserPermissions(r.DB, userID)
+}
+
+// resolveUserPermissions returns the union of the permissions granted by all
+// of the user's roles.
+func resolveUserPermissions(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	permissions := []string{}
+	if err := db.Raw(`
+		SELECT DISTINCT p.name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ?
+		ORDER BY p.name`, userID).Scan(&permissions).Error; err != nil {
+		return nil, models.New("database_error", "failed to resolve permissions")
+	}
+	return permissions, nil
+}
+
+func normalizePermissionName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
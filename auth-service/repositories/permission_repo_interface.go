@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/google/uuid"
+)
+
+type PermissionRepositoryInterface interface {
+	ListPermissions() ([]models.Permission, error)
+	CreatePermission(name, description string) (*models.Permission, error)
+	DeletePermission(permissionID uuid.UUID) error
+	GetRolePermissions(roleID uuid.UUID) ([]models.Permission, error)
+	AttachPermission(roleID uuid.UUID, permissionName string) error
+	DetachPermission(roleID uuid.UUID, permissionName string) error
+	ResolveUserPermissions(userID uuid.UUID) ([]string, error)
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+)
+
+func TestCreatePermissionValidatesName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"orders:read", true},
+		{"Orders:Read", true},
+		{"billing", true},
+		{"reports:export:csv", true},
+		{"orders:*", false},
+		{"*", false},
+		{"orders:", false},
+		{":read", false},
+		{"1orders:read", false},
+		{"orders read", false},
+		{"orders:" + strings.Repeat("a", maxPermissionNameLength), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			normalized := strings.ToLower(tt.name)
+			if tt.valid {
+				mock.ExpectQuery(`INSERT INTO permissions`).
+					WithArgs(normalized, "").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(uuid.New(), normalized))
+			}
+
+			permission, err := NewPermissionRepository(db).CreatePermission(tt.name, "")
+			if tt.valid && (err != nil || permission.Name != normalized) {
+				t.Fatalf("permission = %+v, err = %v", permission, err)
+			}
+			if !tt.valid && err != models.ErrInvalidInput {
+				t.Fatalf("err = %v, want %v", err, models.ErrInvalidInput)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyRejectsWildcardScope(t *testing.T) {
+	useConfig(t, nil)
+	db, _ := newMockDB(t)
+
+	if _, err := NewServiceAccountRepository(db).CreateAPIKey(uuid.New(), []string{"orders:*"}, nil); err != models.ErrInvalidInput {
+		t.Fatalf("err = %v, want %v", err, models.ErrInvalidInput)
+	}
+}
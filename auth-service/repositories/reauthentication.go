@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/pkg/webauthn"
+	"bytes"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const ceremonyReauthentication = "reauthentication"
+
+// BeginPasskeyReauthentication returns request options for confirming a
+// change to the account with one of the user's passkeys instead of the
+// password.
+func (r *AuthRepository) BeginPasskeyReauthentication(userID uuid.UUID) (*webauthn.RequestOptions, error) {
+	passkeys, err := r.ListPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) == 0 {
+		return nil, models.ErrPasskeyNotFound
+	}
+
+	challenge, err := r.startWebAuthnCeremony(ceremonyReauthentication, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	options := r.RelyingParty.RequestOptions(challenge, credentialDescriptors(passkeys), webauthn.UserVerificationRequired)
+	return &options, nil
+}
+
+// reauthenticate checks proof before a change to how the account signs in,
+// so that a stolen access token alone cannot add or remove a factor. Wrong
+// answers count towards the login lockout like they do for DisableTOTP.
+func (r *AuthRepository) reauthenticate(userID uuid.UUID, proof models.Reauthentication, client models.ClientInfo) error {
+	if proof.Password == "" && proof.Code == "" && proof.Passkey == nil {
+		return models.ErrReauthRequired
+	}
+
+	var user models.User
+	result := r.DB.Raw("SELECT id, email, password_hash FROM users WHERE id = ?", userID).Scan(&user)
+	if result.Error != nil {
+		return models.New("database_error", "failed to find user")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrUnauthorized
+	}
+
+	if err := r.checkLoginThrottle(user.Email, client.IPAddress); err != nil {
+		return err
+	}
+
+	var err error
+	switch {
+	case proof.Password != "":
+		// Accounts created through social login have no password hash, which
+		// never matches.
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(proof.Password)) != nil {
+			err = models.ErrWrongPassword
+		}
+	case proof.Code != "":
+		mfa, findErr := r.findUserMFA(userID)
+		if findErr != nil {
+			return findErr
+		}
+		if mfa == nil || mfa.EnabledAt == nil {
+			return models.ErrMFANotEnrolled
+		}
+		err = r.verifySecondFactor(userID, mfa, proof.Code, client)
+	default:
+		err = r.verifyPasskeyReauthentication(userID, proof.Passkey)
+	}
+
+	switch err {
+	case nil:
+		r.resetLoginThrottle(user.Email)
+	case models.ErrWrongPassword, models.ErrMFAInvalidCode, models.ErrPasskeyInvalid:
+		r.recordFailedLogin(user.Email, client)
+	}
+	return err
+}
+
+// verifyPasskeyReauthentication checks an assertion made for a challenge from
+// BeginPasskeyReauthentication with one of the user's own passkeys. It stands
+// in for the password, so the authenticator must have verified the user.
+func (r *AuthRepository) verifyPasskeyReauthentication(userID uuid.UUID, response *webauthn.AssertionResponse) error {
+	challenge, err := response.Challenge()
+	if err != nil {
+		return models.ErrPasskeyInvalid
+	}
+
+	challengeUserID, err := r.consumeWebAuthnChallenge(challenge, ceremonyReauthentication)
+	if err != nil {
+		return err
+	}
+	if challengeUserID != userID {
+		return models.ErrPasskeyInvalid
+	}
+
+	credentialID, err := response.CredentialID()
+	if err != nil {
+		return models.ErrPasskeyInvalid
+	}
+
+	var passkey models.WebAuthnCredential
+	result := r.DB.Raw("SELECT "+passkeyColumns+" FROM webauthn_credentials WHERE credential_id = ? AND user_id = ?",
+		webauthn.EncodeBase64URL(credentialID), userID).Scan(&passkey)
+	if result.Error != nil {
+		return models.New("database_error", "failed to load passkey")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrPasskeyInvalid
+	}
+
+	assertion, err := r.RelyingParty.VerifyAssertion(response, challenge, passkey.PublicKey, true)
+	if err != nil {
+		return models.ErrPasskeyInvalid
+	}
+	if assertion.UserHandle != nil && !bytes.Equal(assertion.UserHandle, userID[:]) {
+		return models.ErrPasskeyInvalid
+	}
+
+	return r.updatePasskeySignCount(&passkey, assertion.SignCount)
+}
@@ -0,0 +1,153 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/pkg/webauthn"
+	"auth-service/utils"
+	"encoding/base64"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+type reauthFixture struct {
+	userID       uuid.UUID
+	passwordHash string
+	client       models.ClientInfo
+}
+
+func newReauthFixture(t *testing.T) reauthFixture {
+	t.Helper()
+	useConfig(t, nil)
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reauthFixture{userID: uuid.New(), passwordHash: string(hash), client: models.ClientInfo{IPAddress: "203.0.113.7"}}
+}
+
+func (f reauthFixture) repo(t *testing.T) (*AuthRepository, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	repo := NewAuthRepository(db)
+	repo.Lockout = utils.LockoutPolicy{Threshold: 5, IPThreshold: 20, Window: time.Minute, BaseLock: time.Minute, MaxLock: time.Hour}
+	return repo, mock
+}
+
+func (f reauthFixture) expectUser(mock sqlmock.Sqlmock, passwordHash string) {
+	mock.ExpectQuery(`SELECT id, email, password_hash FROM users WHERE id = \$1`).
+		WithArgs(f.userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash"}).AddRow(f.userID, "user@example.com", passwordHash))
+}
+
+func (f reauthFixture) expectThrottle(mock sqlmock.Sqlmock, lockedUntil any) {
+	mock.ExpectQuery(`FROM login_throttles`).
+		WithArgs(throttleScopeAccount, "user@example.com", throttleScopeIP, f.client.IPAddress).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(lockedUntil))
+}
+
+func (f reauthFixture) expectFailure(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`INSERT INTO login_throttles`).
+		WithArgs(throttleScopeAccount, "user@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO login_throttles`).
+		WithArgs(throttleScopeIP, f.client.IPAddress, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_attempts"}).AddRow(1))
+}
+
+func TestDeletePasskeyWithPassword(t *testing.T) {
+	f := newReauthFixture(t)
+	repo, mock := f.repo(t)
+	passkeyID := uuid.New()
+
+	f.expectUser(mock, f.passwordHash)
+	f.expectThrottle(mock, nil)
+	mock.ExpectExec(`DELETE FROM login_throttles`).
+		WithArgs(throttleScopeAccount, "user@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM webauthn_credentials WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(passkeyID, f.userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(f.userID, models.EventPasskeyRemoved, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := repo.DeletePasskey(f.userID, passkeyID, models.Reauthentication{Password: "password"}, f.client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasskeyChangesRequireReauthentication(t *testing.T) {
+	f := newReauthFixture(t)
+	clientData := base64.RawURLEncoding.EncodeToString([]byte(`{"type":"webauthn.get","challenge":"unknown"}`))
+	assertion := &webauthn.AssertionResponse{}
+	assertion.Response.ClientDataJSON = clientData
+
+	tests := []struct {
+		name    string
+		proof   models.Reauthentication
+		expect  func(mock sqlmock.Sqlmock)
+		wantErr error
+	}{
+		{"no proof", models.Reauthentication{}, func(sqlmock.Sqlmock) {}, models.ErrReauthRequired},
+		{"wrong password", models.Reauthentication{Password: "guess"}, func(mock sqlmock.Sqlmock) {
+			f.expectUser(mock, f.passwordHash)
+			f.expectThrottle(mock, nil)
+			f.expectFailure(mock)
+		}, models.ErrWrongPassword},
+		{"password on a social login account", models.Reauthentication{Password: "password"}, func(mock sqlmock.Sqlmock) {
+			f.expectUser(mock, "")
+			f.expectThrottle(mock, nil)
+			f.expectFailure(mock)
+		}, models.ErrWrongPassword},
+		{"code without TOTP", models.Reauthentication{Code: "123456"}, func(mock sqlmock.Sqlmock) {
+			f.expectUser(mock, f.passwordHash)
+			f.expectThrottle(mock, nil)
+			mock.ExpectQuery(`FROM user_mfa WHERE user_id = \$1`).
+				WithArgs(f.userID).
+				WillReturnRows(sqlmock.NewRows([]string{"totp_secret"}))
+		}, models.ErrMFANotEnrolled},
+		{"assertion for an unknown challenge", models.Reauthentication{Passkey: assertion}, func(mock sqlmock.Sqlmock) {
+			f.expectUser(mock, f.passwordHash)
+			f.expectThrottle(mock, nil)
+			mock.ExpectQuery(`DELETE FROM webauthn_challenges`).
+				WithArgs(utils.HashToken("unknown"), ceremonyReauthentication).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+			f.expectFailure(mock)
+		}, models.ErrPasskeyInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing is registered or removed unless the proof holds.
+			t.Run("register", func(t *testing.T) {
+				repo, mock := f.repo(t)
+				tt.expect(mock)
+				if _, err := repo.FinishPasskeyRegistration(f.userID, "key", &webauthn.RegistrationResponse{}, tt.proof, f.client); err != tt.wantErr {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			})
+			t.Run("delete", func(t *testing.T) {
+				repo, mock := f.repo(t)
+				tt.expect(mock)
+				if err := repo.DeletePasskey(f.userID, uuid.New(), tt.proof, f.client); err != tt.wantErr {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			})
+		})
+	}
+}
+
+func TestPasskeyChangesWhileLocked(t *testing.T) {
+	f := newReauthFixture(t)
+	repo, mock := f.repo(t)
+
+	f.expectUser(mock, f.passwordHash)
+	f.expectThrottle(mock, time.Now().Add(time.Minute))
+
+	err := repo.DeletePasskey(f.userID, uuid.New(), models.Reauthentication{Password: "password"}, f.client)
+	if appErr, ok := err.(*models.AppError); !ok || appErr.Code != "account_locked" {
+		t.Fatalf("err = %v, want account_locked", err)
+	}
+}
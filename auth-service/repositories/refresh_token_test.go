@@ -0,0 +1,211 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/pkg/jwtauth"
+	"auth-service/utils"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokensAreStoredHashed(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	repo := NewAuthRepository(db)
+
+	userID := uuid.New()
+	var storedHash string
+	mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+		WithArgs(argFunc(func(v driver.Value) bool {
+			storedHash, _ = v.(string)
+			return len(storedHash) == 64
+		}), userID, sqlmock.AnyArg(), sqlmock.AnyArg(), "agent", "10.0.0.1", "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token_hash", "user_id"}).
+			AddRow(uuid.New(), "stored", userID))
+
+	token, err := repo.generateAndStoreRefreshToken(userID, uuid.Nil, "", models.ClientInfo{UserAgent: "agent", IPAddress: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token == "" || storedHash == token.Token {
+		t.Fatalf("raw token %q was written to the database", token.Token)
+	}
+	if storedHash != utils.HashToken(token.Token) {
+		t.Errorf("stored %q, want the keyed hash of the returned token", storedHash)
+	}
+}
+
+// expectRevokedToken expects the lookups of a refresh token that is already
+// revoked: once before and once after the attempt to rotate it fails.
+func expectRevokedToken(mock sqlmock.Sqlmock, hash string, familyID uuid.UUID, rotatedAt *time.Time) {
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = \$1`).
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token_hash", "user_id", "family_id", "expires_at", "is_revoked", "rotated_at"}).
+			AddRow(uuid.New(), hash, uuid.New(), familyID, time.Now().Add(time.Hour), true, rotatedAt))
+	mock.ExpectExec(`UPDATE refresh_tokens\s+SET is_revoked = true, rotated_at = NOW\(\)\s+WHERE token_hash = \$1 AND is_revoked = false`).
+		WithArgs(hash).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = \$1`).
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token_hash", "user_id", "family_id", "expires_at", "is_revoked", "rotated_at"}).
+			AddRow(uuid.New(), hash, uuid.New(), familyID, time.Now().Add(time.Hour), true, rotatedAt))
+}
+
+func TestRefreshTokenLooksUpByHash(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	repo := NewAuthRepository(db)
+
+	raw := "raw-refresh-token"
+	hash := utils.HashToken(raw)
+	familyID := uuid.New()
+	rotatedAt := time.Now().Add(-time.Minute)
+
+	// The token was already rotated, so its family is revoked as a reuse.
+	expectRevokedToken(mock, hash, familyID, &rotatedAt)
+	mock.ExpectExec(`WHERE family_id = \$1 AND is_revoked = false`).
+		WithArgs(familyID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(sqlmock.AnyArg(), models.EventRefreshTokenReuse, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := repo.RefreshToken(raw, models.ClientInfo{}); err != models.ErrTokenReused {
+		t.Fatalf("err = %v, want %v", err, models.ErrTokenReused)
+	}
+}
+
+func TestRefreshTokenRevokedOnPurpose(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+
+	// Signed out by logout, session revocation or a password change: no
+	// family revocation and no security event.
+	raw := "signed-out-token"
+	expectRevokedToken(mock, utils.HashToken(raw), uuid.New(), nil)
+
+	if _, err := NewAuthRepository(db).RefreshToken(raw, models.ClientInfo{}); err != models.ErrTokenNotFound {
+		t.Fatalf("err = %v, want %v", err, models.ErrTokenNotFound)
+	}
+}
+
+func TestRefreshTokenUnknownHash(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	repo := NewAuthRepository(db)
+
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = \$1`).
+		WithArgs(utils.HashToken("unknown")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	if _, err := repo.RefreshToken("unknown", models.ClientInfo{}); err != models.ErrTokenNotFound {
+		t.Fatalf("err = %v, want %v", err, models.ErrTokenNotFound)
+	}
+}
+
+func expectRefreshToken(mock sqlmock.Sqlmock, raw string, userID uuid.UUID, clientID, scope string) {
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = \$1`).
+		WithArgs(utils.HashToken(raw)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token_hash", "user_id", "family_id", "client_id", "scope", "expires_at", "is_revoked"}).
+			AddRow(uuid.New(), utils.HashToken(raw), userID, uuid.New(), clientID, scope, time.Now().Add(time.Hour), false))
+}
+
+func TestRefreshTokenIsBoundToClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		issuedTo string
+		usedBy   string
+	}{
+		{"client token on /refresh", "partner", ""},
+		{"client token used by another client", "partner", "other"},
+		{"cookie session used by a client", "", "partner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			db, mock := newMockDB(t)
+			expectRefreshToken(mock, "raw", uuid.New(), tt.issuedTo, "openid")
+
+			// Nothing is rotated or revoked, so the rightful holder keeps the session.
+			if _, err := NewAuthRepository(db).RefreshToken("raw", models.ClientInfo{ClientID: tt.usedBy}); err != models.ErrTokenNotFound {
+				t.Fatalf("err = %v, want %v", err, models.ErrTokenNotFound)
+			}
+		})
+	}
+}
+
+func TestRefreshClientTokenKeepsScope(t *testing.T) {
+	useConfig(t, nil)
+	useKeySet(t)
+	db, mock := newMockDB(t)
+	userID := uuid.New()
+
+	expectRefreshToken(mock, "raw", userID, "partner", "openid profile")
+	mock.ExpectExec(`UPDATE refresh_tokens\s+SET is_revoked = true, rotated_at = NOW\(\)\s+WHERE token_hash = \$1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT id, name, email, email_verified_at FROM users WHERE id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(userID, "user@example.com"))
+	// No roles or permissions are loaded for a client.
+	mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+		WithArgs(sqlmock.AnyArg(), userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "partner", "openid profile").
+		WillReturnRows(sqlmock.NewRows([]string{"client_id", "scope"}).AddRow("partner", "openid profile"))
+
+	result, err := NewAuthRepository(db).RefreshToken("raw", models.ClientInfo{ClientID: "partner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := parseDelegatedToken(t, result.AccessToken)
+	if claims.ClientID != "partner" || claims.Scope != "openid profile" || claims.Roles != nil {
+		t.Errorf("claims = %+v, want a partner token limited to the stored scope", claims)
+	}
+	if result.RefreshToken.Scope != "openid profile" {
+		t.Errorf("refresh token scope = %q", result.RefreshToken.Scope)
+	}
+}
+
+func TestIssueClientTokens(t *testing.T) {
+	useConfig(t, nil)
+	useKeySet(t)
+	db, mock := newMockDB(t)
+	repo := NewAuthRepository(db)
+	userID := uuid.New()
+
+	if _, err := repo.IssueClientTokens(userID, "openid", models.ClientInfo{}); err != models.ErrInvalidClient {
+		t.Fatalf("err = %v, want %v without a client", err, models.ErrInvalidClient)
+	}
+
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(userID, "user@example.com"))
+	mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+		WithArgs(sqlmock.AnyArg(), userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "partner", "openid").
+		WillReturnRows(sqlmock.NewRows([]string{"client_id", "scope"}).AddRow("partner", "openid"))
+
+	result, err := repo.IssueClientTokens(userID, "openid", models.ClientInfo{ClientID: "partner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims := parseDelegatedToken(t, result.AccessToken); claims.UserID != userID.String() || claims.Audience[0] != "partner" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func parseDelegatedToken(t *testing.T, token string) *jwtauth.Claims {
+	t.Helper()
+	claims, err := jwtauth.Parse(jwt.NewParser(jwt.WithValidMethods(jwtauth.SigningMethods)), token, jwtauth.Config{
+		Keyfunc:            utils.DefaultKeySet.Keyfunc,
+		Issuer:             utils.JWTIssuer(),
+		Audience:           utils.JWTAudience(),
+		AcceptClientTokens: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
@@ -0,0 +1,91 @@
+package repositories
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/utils"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+// newMockDB returns a gorm handle backed by sqlmock. Expectations match SQL
+// by regular expression and must all be met by the end of the test.
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		sqlDB.Close()
+	})
+	return db, mock
+}
+
+// useConfig installs the default configuration with test secrets for the
+// duration of the test.
+func useConfig(t *testing.T, change func(cfg *config.Config)) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.RefreshTokenSecret = "test-refresh-token-secret-0123456789"
+	cfg.DataEncryptionKey = "test-data-encryption-key-0123456789"
+	if change != nil {
+		change(cfg)
+	}
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(nil) })
+}
+
+// argFunc adapts a predicate to a sqlmock argument matcher.
+type argFunc func(v driver.Value) bool
+
+func (f argFunc) Match(v driver.Value) bool {
+	return f(v)
+}
+
+// useKeySet loads utils.DefaultKeySet with a fresh active key, for tests that
+// go as far as issuing access tokens.
+func useKeySet(t *testing.T) {
+	t.Helper()
+	key, err := utils.GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.ActivatesAt = time.Now().Add(-time.Minute)
+
+	previous := utils.DefaultKeySet
+	utils.DefaultKeySet = &utils.KeySet{}
+	if err := utils.DefaultKeySet.Load([]models.SigningKey{*key}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { utils.DefaultKeySet = previous })
+}
+
+// expectIssueTokens expects the queries that issue a first-party session for
+// userID.
+func expectIssueTokens(mock sqlmock.Sqlmock, userID any) {
+	mock.ExpectQuery(`SELECT r.name\s+FROM user_roles`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(models.RoleUser))
+	mock.ExpectQuery(`SELECT DISTINCT p.name`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"token_hash"}).AddRow("stored"))
+}
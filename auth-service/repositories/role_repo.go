@@ -0,0 +1,223 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{1,63}$`)
+
+type RoleRepository struct {
+	DB *gorm.DB
+}
+
+var _ RoleRepositoryInterface = (*RoleRepository)(nil)
+
+func NewRoleRepository(db *gorm.DB) *RoleRepository {
+	return &RoleRepository{DB: db}
+}
+
+func (r *RoleRepository) ListRoles() ([]models.Role, error) {
+	roles := []models.Role{}
+	if err := r.DB.Raw(`
+		SELECT id, name, COALESCE(description, '') AS description, created_at, updated_at
+		FROM roles ORDER BY name`).Scan(&roles).Error; err != nil {
+		return nil, models.New("database_error", "failed to list roles")
+	}
+	return roles, nil
+}
+
+func (r *RoleRepository) GetRole(roleID uuid.UUID) (*models.Role, error) {
+	var role models.Role
+	result := r.DB.Raw(`
+		SELECT id, name, COALESCE(description, '') AS description, created_at, updated_at
+		FROM roles WHERE id = ?`, roleID).Scan(&role)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find role")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrRoleNotFound
+	}
+	return &role, nil
+}
+
+func (r *RoleRepository) CreateRole(name, description string) (*models.Role, error) {
+	name = normalizeRoleName(name)
+	if !roleNamePattern.MatchString(name) {
+		return nil, models.ErrInvalidInput
+	}
+
+	var role models.Role
+	err := r.DB.Raw(`
+		INSERT INTO roles (name, description) 
+		VALUES (?, ?) 
+		RETURNING id, name, COALESCE(description, '') AS description, created_at, updated_at`,
+		name, description).Scan(&role).Error
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, models.ErrRoleExists
+		}
+		return nil, models.New("database_error", "failed to create role")
+	}
+	return &role, nil
+}
+
+// UpdateRole renames and/or re-describes a role. Built-in roles keep their
+// names because the database and the code look them up by name.
+func (r *RoleRepository) UpdateRole(roleID uuid.UUID, name, description *string) (*models.Role, error) {
+	role, err := r.GetRole(roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != nil {
+		normalized := normalizeRoleName(*name)
+		if !roleNamePattern.MatchString(normalized) {
+			return nil, models.ErrInvalidInput
+		}
+		if normalized != role.Name && models.IsBuiltinRole(role.Name) {
+			return nil, models.ErrRoleProtected
+		}
+		role.Name = normalized
+	}
+	if description != nil {
+		role.Description = *description
+	}
+
+	err = r.DB.Raw(`
+		UPDATE roles SET name = ?, description = ? 
+		WHERE id = ?
+		RETURNING id, name, COALESCE(description, '') AS description, created_at, updated_at`,
+		role.Name, role.Description, roleID).Scan(role).Error
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, models.ErrRoleExists
+		}
+		return nil, models.New("database_error", "failed to update role")
+	}
+	return role, nil
+}
+
+func (r *RoleRepository) DeleteRole(roleID uuid.UUID) error {
+	role, err := r.GetRole(roleID)
+	if err != nil {
+		return err
+	}
+
+	if models.IsBuiltinRole(role.Name) {
+		return models.ErrRoleProtected
+	}
+
+	if err := r.DB.Exec("DELETE FROM roles WHERE id = ?", roleID).Error; err != nil {
+		return models.New("database_error", "failed to delete role")
+	}
+	return nil
+}
+
+func (r *RoleRepository) GetUserRoles(userID uuid.UUID) ([]models.Role, error) {
+	roles := []models.Role{}
+	if err := r.DB.Raw(`
+		SELECT r.id, r.name, COALESCE(r.description, '') AS description, r.created_at, r.updated_at
+		FROM user_roles ur
+		JOIN roles r ON ur.role_id = r.id
+		WHERE ur.user_id = ?
+		ORDER BY r.name`, userID).Scan(&roles).Error; err != nil {
+		return nil, models.New("database_error", "failed to load user roles")
+	}
+	return roles, nil
+}
+
+func (r *RoleRepository) GrantRole(userID uuid.UUID, roleName string) error {
+	result := r.DB.Exec(`
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT u.id, r.id FROM users u, roles r
+		WHERE u.id = ? AND r.name = ?
+		ON CONFLICT (user_id, role_id) DO NOTHING`, userID, normalizeRoleName(roleName))
+	if result.Error != nil {
+		return models.New("database_error", "failed to grant role")
+	}
+	if result.RowsAffected == 0 {
+		return r.explainMissingGrant(userID, roleName)
+	}
+	return nil
+}
+
+// RevokeRole removes a role from a user. The ADMIN role row is locked while
+// revoking it, so concurrent requests cannot remove the last administrator.
+func (r *RoleRepository) RevokeRole(userID uuid.UUID, roleName string) error {
+	roleName = normalizeRoleName(roleName)
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var role models.Role
+		result := tx.Raw("SELECT id, name FROM roles WHERE name = ? FOR UPDATE", roleName).Scan(&role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrRoleNotFound
+		}
+
+		result = tx.Exec("DELETE FROM user_roles WHERE user_id = ? AND role_id = ?", userID, role.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrRoleNotAssigned
+		}
+
+		// Counting after the delete only blocks users who actually held the
+		// role; returning an error rolls the delete back.
+		if role.Name == models.RoleAdmin {
+			var admins int64
+			if err := tx.Raw("SELECT COUNT(*) FROM user_roles WHERE role_id = ?", role.ID).Scan(&admins).Error; err != nil {
+				return err
+			}
+			if admins == 0 {
+				return models.ErrLastAdmin
+			}
+		}
+
+		return nil
+	})
+
+	var appErr *models.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	if err != nil {
+		return models.New("database_error", "failed to revoke role")
+	}
+	return nil
+}
+
+func (r *RoleRepository) explainMissingGrant(userID uuid.UUID, roleName string) error {
+	var counts struct {
+		Users int64
+		Roles int64
+	}
+	if err := r.DB.Raw(`
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE id = ?) AS users,
+			(SELECT COUNT(*) FROM roles WHERE name = ?) AS roles`,
+		userID, normalizeRoleName(roleName)).Scan(&counts).Error; err != nil {
+		return models.New("database_error", "failed to grant role")
+	}
+
+	switch {
+	case counts.Users == 0:
+		return models.ErrUserNotFound
+	case counts.Roles == 0:
+		return models.ErrRoleNotFound
+	default:
+		// The user already has the role; granting is idempotent.
+		return nil
+	}
+}
+
+func normalizeRoleName(name string) string {
+	return strings.ToUpper(strings.TrimSpace(name))
+}
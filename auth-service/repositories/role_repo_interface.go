@@ -0,0 +1,17 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/google/uuid"
+)
+
+type RoleRepositoryInterface interface {
+	ListRoles() ([]models.Role, error)
+	GetRole(roleID uuid.UUID) (*models.Role, error)
+	CreateRole(name, description string) (*models.Role, error)
+	UpdateRole(roleID uuid.UUID, name, description *string) (*models.Role, error)
+	DeleteRole(roleID uuid.UUID) error
+	GetUserRoles(userID uuid.UUID) ([]models.Role, error)
+	GrantRole(userID uuid.UUID, roleName string) error
+	RevokeRole(userID uuid.UUID, roleName string) error
+}
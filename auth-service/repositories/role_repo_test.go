@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestRevokeRole(t *testing.T) {
+	userID, adminRoleID, userRoleID := uuid.New(), uuid.New(), uuid.New()
+
+	expectRole := func(mock sqlmock.Sqlmock, id uuid.UUID, name string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id, name FROM roles WHERE name = \$1 FOR UPDATE`).
+			WithArgs(name).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(id, name))
+	}
+	expectDelete := func(mock sqlmock.Sqlmock, roleID uuid.UUID, deleted int64) {
+		mock.ExpectExec(`DELETE FROM user_roles WHERE user_id = \$1 AND role_id = \$2`).
+			WithArgs(userID, roleID).
+			WillReturnResult(sqlmock.NewResult(0, deleted))
+	}
+	expectAdmins := func(mock sqlmock.Sqlmock, remaining int) {
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_roles WHERE role_id = \$1`).
+			WithArgs(adminRoleID).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(remaining))
+	}
+
+	tests := []struct {
+		name   string
+		role   string
+		expect func(sqlmock.Sqlmock)
+		want   error
+	}{
+		{"admin among several", "admin", func(mock sqlmock.Sqlmock) {
+			expectRole(mock, adminRoleID, models.RoleAdmin)
+			expectDelete(mock, adminRoleID, 1)
+			expectAdmins(mock, 1)
+			mock.ExpectCommit()
+		}, nil},
+		{"last admin", models.RoleAdmin, func(mock sqlmock.Sqlmock) {
+			expectRole(mock, adminRoleID, models.RoleAdmin)
+			expectDelete(mock, adminRoleID, 1)
+			expectAdmins(mock, 0)
+			mock.ExpectRollback()
+		}, models.ErrLastAdmin},
+		{"admin role the user does not hold", models.RoleAdmin, func(mock sqlmock.Sqlmock) {
+			expectRole(mock, adminRoleID, models.RoleAdmin)
+			expectDelete(mock, adminRoleID, 0)
+			mock.ExpectRollback()
+		}, models.ErrRoleNotAssigned},
+		{"other role", models.RoleUser, func(mock sqlmock.Sqlmock) {
+			expectRole(mock, userRoleID, models.RoleUser)
+			expectDelete(mock, userRoleID, 1)
+			mock.ExpectCommit()
+		}, nil},
+		{"unknown role", "ghost", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`FROM roles WHERE name = \$1 FOR UPDATE`).
+				WithArgs("GHOST").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+			mock.ExpectRollback()
+		}, models.ErrRoleNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			if err := NewRoleRepository(db).RevokeRole(userID, tt.role); err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
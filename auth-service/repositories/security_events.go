@@ -0,0 +1,30 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"encoding/json"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+)
+
+// recordSecurityEvent stores an audit entry. Failing to record it must never
+// break the flow that triggered it, so errors are only logged.
+func recordSecurityEvent(db *gorm.DB, event *models.SecurityEvent) {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		details = []byte("{}")
+	}
+
+	var userID any
+	if event.UserID != uuid.Nil {
+		userID = event.UserID
+	}
+
+	if err := db.Exec(`
+		INSERT INTO security_events (user_id, event_type, ip_address, user_agent, details) 
+		VALUES (?, ?, ?, ?, ?)`,
+		userID, event.EventType, event.IPAddress, event.UserAgent, string(details)).Error; err != nil {
+		log.Printf("failed to record security event %s: %v", event.EventType, err)
+	}
+}
@@ -0,0 +1,236 @@
+package repositories
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+	"time"
+)
+
+const apiKeyColumns = "id, service_account_id, prefix, scopes, expires_at, last_used_at, last_used_ip, revoked_at, created_at"
+
+type ServiceAccountRepository struct {
+	DB *gorm.DB
+}
+
+var _ ServiceAccountRepositoryInterface = (*ServiceAccountRepository)(nil)
+
+func NewServiceAccountRepository(db *gorm.DB) *ServiceAccountRepository {
+	return &ServiceAccountRepository{DB: db}
+}
+
+func (r *ServiceAccountRepository) ListServiceAccounts() ([]models.ServiceAccount, error) {
+	accounts := []models.ServiceAccount{}
+	if err := r.DB.Raw(`
+		SELECT id, name, description, created_at, updated_at
+		FROM service_accounts ORDER BY name`).Scan(&accounts).Error; err != nil {
+		return nil, models.New("database_error", "failed to list service accounts")
+	}
+	return accounts, nil
+}
+
+func (r *ServiceAccountRepository) GetServiceAccount(accountID uuid.UUID) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	result := r.DB.Raw(`
+		SELECT id, name, description, created_at, updated_at
+		FROM service_accounts WHERE id = ?`, accountID).Scan(&account)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to find service account")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrServiceNotFound
+	}
+	return &account, nil
+}
+
+func (r *ServiceAccountRepository) CreateServiceAccount(name, description string) (*models.ServiceAccount, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, models.ErrInvalidInput
+	}
+
+	var account models.ServiceAccount
+	err := r.DB.Raw(`
+		INSERT INTO service_accounts (name, description)
+		VALUES (?, ?)
+		RETURNING id, name, description, created_at, updated_at`,
+		name, description).Scan(&account).Error
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, models.ErrServiceExists
+		}
+		return nil, models.New("database_error", "failed to create service account")
+	}
+	return &account, nil
+}
+
+// DeleteServiceAccount removes the account and all of its keys. Tokens already
+// exchanged stay valid until they expire, at most the service token lifetime.
+func (r *ServiceAccountRepository) DeleteServiceAccount(accountID uuid.UUID) error {
+	result := r.DB.Exec("DELETE FROM service_accounts WHERE id = ?", accountID)
+	if result.Error != nil {
+		return models.New("database_error", "failed to delete service account")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrServiceNotFound
+	}
+	return nil
+}
+
+func (r *ServiceAccountRepository) ListAPIKeys(accountID uuid.UUID) ([]models.APIKey, error) {
+	if _, err := r.GetServiceAccount(accountID); err != nil {
+		return nil, err
+	}
+
+	keys := []models.APIKey{}
+	if err := r.DB.Raw(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE service_account_id = ?
+		ORDER BY created_at`, accountID).Scan(&keys).Error; err != nil {
+		return nil, models.New("database_error", "failed to list API keys")
+	}
+	return keys, nil
+}
+
+// CreateAPIKey issues a new key for the account. Without an explicit expiry
+// the key lives for the configured API key lifetime. The raw key is only
+// returned here.
+func (r *ServiceAccountRepository) CreateAPIKey(accountID uuid.UUID, scopes []string, expiresAt *time.Time) (*models.APIKeyWithSecret, error) {
+	for _, scope := range scopes {
+		if len(scope) > maxPermissionNameLength || !permissionNamePattern.MatchString(scope) {
+			return nil, models.ErrInvalidInput
+		}
+	}
+	if expiresAt == nil {
+		expiry := utils.TokenExpiryTime(config.Current().Tokens.APIKey)
+		expiresAt = &expiry
+	} else if !expiresAt.After(time.Now()) {
+		return nil, models.ErrInvalidInput
+	}
+
+	if _, err := r.GetServiceAccount(accountID); err != nil {
+		return nil, err
+	}
+
+	return r.insertAPIKey(r.DB, accountID, scopes, *expiresAt)
+}
+
+// RotateAPIKey replaces a key with a new one carrying the same scopes. The
+// old key keeps working for the configured rotation grace period so callers
+// can switch over without downtime; revoke it to cut it off immediately.
+func (r *ServiceAccountRepository) RotateAPIKey(accountID, keyID uuid.UUID) (*models.APIKeyWithSecret, error) {
+	var rotated *models.APIKeyWithSecret
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var old models.APIKey
+		result := tx.Raw(`
+			UPDATE api_keys
+			SET expires_at = LEAST(expires_at, ?)
+			WHERE id = ? AND service_account_id = ? AND revoked_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > NOW())
+			RETURNING `+apiKeyColumns,
+			utils.TokenExpiryTime(config.Current().Tokens.APIKeyRotationGrace), keyID, accountID).Scan(&old)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrAPIKeyNotFound
+		}
+
+		var err error
+		rotated, err = r.insertAPIKey(tx, accountID, old.Scopes, utils.TokenExpiryTime(config.Current().Tokens.APIKey))
+		return err
+	})
+	if err != nil {
+		if appErr, ok := err.(*models.AppError); ok {
+			return nil, appErr
+		}
+		return nil, models.New("database_error", "failed to rotate API key")
+	}
+	return rotated, nil
+}
+
+func (r *ServiceAccountRepository) RevokeAPIKey(accountID, keyID uuid.UUID) error {
+	result := r.DB.Exec(`
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = ? AND service_account_id = ? AND revoked_at IS NULL`, keyID, accountID)
+	if result.Error != nil {
+		return models.New("database_error", "failed to revoke API key")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// ExchangeAPIKey trades a valid key for a short-lived access token. The token
+// carries the requested scopes, which must all be granted to the key, or all
+// of the key's scopes when none are requested.
+func (r *ServiceAccountRepository) ExchangeAPIKey(key string, scopes []string, ipAddress string) (*models.ServiceToken, error) {
+	if !strings.HasPrefix(key, utils.APIKeyPrefix) {
+		return nil, models.ErrAPIKeyInvalid
+	}
+
+	var apiKey models.APIKey
+	result := r.DB.Raw(`
+		UPDATE api_keys
+		SET last_used_at = NOW(), last_used_ip = ?
+		WHERE key_hash = ? AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING `+apiKeyColumns,
+		ipAddress, utils.HashToken(key)).Scan(&apiKey)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to verify API key")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrAPIKeyInvalid
+	}
+
+	if len(scopes) == 0 {
+		scopes = apiKey.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(apiKey.Scopes, scope) {
+			return nil, models.ErrScopeNotAllowed
+		}
+	}
+
+	account, err := r.GetServiceAccount(apiKey.ServiceAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, accessExp, err := utils.GenerateServiceAccessToken(account, scopes)
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate access token")
+	}
+
+	return &models.ServiceToken{
+		AccessToken:    accessToken,
+		AccessTokenExp: accessExp,
+		Scopes:         scopes,
+	}, nil
+}
+
+func (r *ServiceAccountRepository) insertAPIKey(db *gorm.DB, accountID uuid.UUID, scopes []string, expiresAt time.Time) (*models.APIKeyWithSecret, error) {
+	secret, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate API key")
+	}
+	key := utils.APIKeyPrefix + secret
+
+	var apiKey models.APIKey
+	if err := db.Raw(`
+		INSERT INTO api_keys (service_account_id, prefix, key_hash, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING `+apiKeyColumns,
+		accountID, key[:utils.APIKeyDisplayLength], utils.HashToken(key), models.StringList(scopes), expiresAt).Scan(&apiKey).Error; err != nil {
+		return nil, models.New("database_error", "failed to store API key")
+	}
+
+	return &models.APIKeyWithSecret{APIKey: &apiKey, Key: key}, nil
+}
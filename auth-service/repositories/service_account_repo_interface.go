@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type ServiceAccountRepositoryInterface interface {
+	ListServiceAccounts() ([]models.ServiceAccount, error)
+	GetServiceAccount(accountID uuid.UUID) (*models.ServiceAccount, error)
+	CreateServiceAccount(name, description string) (*models.ServiceAccount, error)
+	DeleteServiceAccount(accountID uuid.UUID) error
+	ListAPIKeys(accountID uuid.UUID) ([]models.APIKey, error)
+	CreateAPIKey(accountID uuid.UUID, scopes []string, expiresAt *time.Time) (*models.APIKeyWithSecret, error)
+	RotateAPIKey(accountID, keyID uuid.UUID) (*models.APIKeyWithSecret, error)
+	RevokeAPIKey(accountID, keyID uuid.UUID) error
+	ExchangeAPIKey(key string, scopes []string, ipAddress string) (*models.ServiceToken, error)
+}
@@ -0,0 +1,199 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/pkg/jwtauth"
+	"auth-service/utils"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func expectServiceAccount(mock sqlmock.Sqlmock, accountID uuid.UUID) {
+	mock.ExpectQuery(`FROM service_accounts WHERE id = \$1`).
+		WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(accountID, "orders"))
+}
+
+func TestCreateAPIKeyStoresHash(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	accountID := uuid.New()
+
+	var prefix, hash string
+	expectServiceAccount(mock, accountID)
+	mock.ExpectQuery(`INSERT INTO api_keys \(service_account_id, prefix, key_hash, scopes, expires_at\)`).
+		WithArgs(accountID, argFunc(func(v driver.Value) bool {
+			prefix, _ = v.(string)
+			return true
+		}), argFunc(func(v driver.Value) bool {
+			hash, _ = v.(string)
+			return true
+		}), `["orders:read"]`, around(time.Now().Add(90*24*time.Hour))).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "service_account_id", "scopes"}).AddRow(uuid.New(), accountID, `["orders:read"]`))
+
+	key, err := NewServiceAccountRepository(db).CreateAPIKey(accountID, []string{"orders:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key.Key, utils.APIKeyPrefix) || prefix != key.Key[:utils.APIKeyDisplayLength] {
+		t.Errorf("key %q stored with prefix %q", key.Key, prefix)
+	}
+	if hash != utils.HashToken(key.Key) || strings.Contains(hash, key.Key[len(utils.APIKeyPrefix):]) {
+		t.Errorf("stored %q, want only the hash of the key", hash)
+	}
+	if !reflect.DeepEqual([]string(key.Scopes), []string{"orders:read"}) {
+		t.Errorf("scopes = %v", key.Scopes)
+	}
+}
+
+func TestCreateAPIKeyRejectsPastExpiry(t *testing.T) {
+	useConfig(t, nil)
+	db, _ := newMockDB(t)
+	past := time.Now().Add(-time.Minute)
+
+	if _, err := NewServiceAccountRepository(db).CreateAPIKey(uuid.New(), []string{"orders:read"}, &past); err != models.ErrInvalidInput {
+		t.Fatalf("err = %v, want %v", err, models.ErrInvalidInput)
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+	accountID, keyID := uuid.New(), uuid.New()
+
+	// The old key is cut down to the grace period and the new key gets its
+	// scopes and a full lifetime.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE api_keys\s+SET expires_at = LEAST\(expires_at, \$1\)\s+WHERE id = \$2 AND service_account_id = \$3 AND revoked_at IS NULL`).
+		WithArgs(around(time.Now().Add(24*time.Hour)), keyID, accountID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "service_account_id", "scopes"}).AddRow(keyID, accountID, `["orders:read","orders:write"]`))
+	mock.ExpectQuery(`INSERT INTO api_keys`).
+		WithArgs(accountID, sqlmock.AnyArg(), sqlmock.AnyArg(), `["orders:read","orders:write"]`, around(time.Now().Add(90*24*time.Hour))).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "service_account_id"}).AddRow(uuid.New(), accountID))
+	mock.ExpectCommit()
+
+	rotated, err := NewServiceAccountRepository(db).RotateAPIKey(accountID, keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID == keyID || !strings.HasPrefix(rotated.Key, utils.APIKeyPrefix) {
+		t.Errorf("rotated = %+v, want a new key", rotated.APIKey)
+	}
+}
+
+func TestRotateAPIKeyNotFound(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE api_keys`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	if _, err := NewServiceAccountRepository(db).RotateAPIKey(uuid.New(), uuid.New()); err != models.ErrAPIKeyNotFound {
+		t.Fatalf("err = %v, want %v", err, models.ErrAPIKeyNotFound)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	for _, rows := range []int64{1, 0} {
+		db, mock := newMockDB(t)
+		accountID, keyID := uuid.New(), uuid.New()
+
+		mock.ExpectExec(`UPDATE api_keys SET revoked_at = NOW\(\)\s+WHERE id = \$1 AND service_account_id = \$2 AND revoked_at IS NULL`).
+			WithArgs(keyID, accountID).
+			WillReturnResult(sqlmock.NewResult(0, rows))
+
+		err := NewServiceAccountRepository(db).RevokeAPIKey(accountID, keyID)
+		if rows == 1 && err != nil || rows == 0 && err != models.ErrAPIKeyNotFound {
+			t.Errorf("revoking with %d matching rows: err = %v", rows, err)
+		}
+	}
+}
+
+func expectAPIKeyUse(mock sqlmock.Sqlmock, key string, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`UPDATE api_keys\s+SET last_used_at = NOW\(\), last_used_ip = \$1\s+WHERE key_hash = \$2 AND revoked_at IS NULL\s+AND \(expires_at IS NULL OR expires_at > NOW\(\)\)`).
+		WithArgs("10.0.0.1", utils.HashToken(key)).
+		WillReturnRows(rows)
+}
+
+func TestExchangeAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		requested  []string
+		wantScopes []string
+	}{
+		{"all scopes of the key", nil, []string{"orders:read", "orders:write"}},
+		{"subset", []string{"orders:read"}, []string{"orders:read"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useKeySet(t)
+			db, mock := newMockDB(t)
+			accountID := uuid.New()
+
+			expectAPIKeyUse(mock, "shpk_key", sqlmock.NewRows([]string{"id", "service_account_id", "scopes"}).
+				AddRow(uuid.New(), accountID, `["orders:read","orders:write"]`))
+			expectServiceAccount(mock, accountID)
+
+			token, err := NewServiceAccountRepository(db).ExchangeAPIKey("shpk_key", tt.requested, "10.0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := jwtauth.Parse(jwt.NewParser(jwt.WithValidMethods(jwtauth.SigningMethods)), token.AccessToken, jwtauth.Config{
+				Keyfunc:  utils.DefaultKeySet.Keyfunc,
+				Issuer:   utils.JWTIssuer(),
+				Audience: utils.JWTAudience(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !claims.IsService() || claims.Subject != accountID.String() || claims.UserID != "" {
+				t.Errorf("claims = %+v, want a service token for the account", claims)
+			}
+			if claims.Scope != strings.Join(tt.wantScopes, " ") || !reflect.DeepEqual(token.Scopes, tt.wantScopes) {
+				t.Errorf("scope = %q, scopes = %v; want %v", claims.Scope, token.Scopes, tt.wantScopes)
+			}
+			if lifetime := time.Until(time.Unix(token.AccessTokenExp, 0)); lifetime > 5*time.Minute {
+				t.Errorf("service token lives %s, want the configured five minutes", lifetime)
+			}
+		})
+	}
+}
+
+func TestExchangeAPIKeyRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		requested []string
+		rows      *sqlmock.Rows
+		want      error
+	}{
+		{"no prefix", "key", nil, nil, models.ErrAPIKeyInvalid},
+		{"unknown, revoked or expired", "shpk_key", nil, sqlmock.NewRows([]string{"id"}), models.ErrAPIKeyInvalid},
+		{"scope not granted", "shpk_key", []string{"payments:refund"},
+			sqlmock.NewRows([]string{"id", "service_account_id", "scopes"}).AddRow(uuid.New(), uuid.New(), `["orders:read"]`),
+			models.ErrScopeNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			db, mock := newMockDB(t)
+			if tt.rows != nil {
+				expectAPIKeyUse(mock, tt.key, tt.rows)
+			}
+
+			if _, err := NewServiceAccountRepository(db).ExchangeAPIKey(tt.key, tt.requested, "10.0.0.1"); err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+// expectCurrentSession expects the lookup of the refresh token presented by
+// the caller, which belongs to familyID.
+func expectCurrentSession(mock sqlmock.Sqlmock, raw string, userID, familyID uuid.UUID) {
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = \$1`).
+		WithArgs(utils.HashToken(raw)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token_hash", "user_id", "family_id", "expires_at", "is_revoked"}).
+			AddRow(uuid.New(), utils.HashToken(raw), userID, familyID, time.Now().Add(time.Hour), false))
+}
+
+func TestListSessionsByFamily(t *testing.T) {
+	useConfig(t, nil)
+	db, mock := newMockDB(t)
+
+	userID, currentFamily, otherFamily := uuid.New(), uuid.New(), uuid.New()
+	expectCurrentSession(mock, "raw", userID, currentFamily)
+	mock.ExpectQuery(`SELECT DISTINCT ON \(family_id\) family_id AS id`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_agent", "created_at"}).
+			AddRow(currentFamily, "laptop", time.Now()).
+			AddRow(otherFamily, "phone", time.Now().Add(-time.Hour)))
+
+	sessions, err := NewAuthRepository(db).ListSessions("raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != currentFamily || !sessions[0].Current || sessions[1].Current {
+		t.Errorf("sessions = %+v, want the current family first and marked", sessions)
+	}
+}
+
+func TestRevokeSessionRevokesFamily(t *testing.T) {
+	tests := []struct {
+		name    string
+		revoked int64
+		wantErr error
+	}{
+		{"active session", 2, nil},
+		{"unknown or other user's session", 0, models.ErrSessionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			db, mock := newMockDB(t)
+
+			userID, familyID := uuid.New(), uuid.New()
+			expectCurrentSession(mock, "raw", userID, uuid.New())
+			mock.ExpectExec(`UPDATE refresh_tokens\s+SET is_revoked = true\s+WHERE family_id = \$1 AND user_id = \$2 AND is_revoked = false`).
+				WithArgs(familyID, userID).
+				WillReturnResult(sqlmock.NewResult(0, tt.revoked))
+
+			if err := NewAuthRepository(db).RevokeSession("raw", familyID); err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
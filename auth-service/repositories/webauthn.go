@@ -0,0 +1,375 @@
+package repositories
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/pkg/webauthn"
+	"auth-service/utils"
+	"bytes"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// Ceremonies a stored WebAuthn challenge can be consumed by.
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonyMFA          = "mfa"
+)
+
+const passkeyColumns = "id, name, credential_id, public_key, sign_count, transports, created_at, last_used_at"
+
+// BeginPasskeyRegistration returns creation options for a new passkey.
+// Credentials the user already has are excluded so an authenticator is not
+// registered twice.
+func (r *AuthRepository) BeginPasskeyRegistration(userID uuid.UUID) (*webauthn.CreationOptions, error) {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := r.ListPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := r.startWebAuthnCeremony(ceremonyRegistration, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	options := r.RelyingParty.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          webauthn.EncodeBase64URL(user.ID[:]),
+		Name:        user.Email,
+		DisplayName: user.Name,
+	}, credentialDescriptors(passkeys))
+	return &options, nil
+}
+
+// FinishPasskeyRegistration stores the new passkey once proof confirms the
+// account holder is present: a registered passkey is a complete sign-in.
+func (r *AuthRepository) FinishPasskeyRegistration(userID uuid.UUID, name string, response *webauthn.RegistrationResponse, proof models.Reauthentication, client models.ClientInfo) (*models.WebAuthnCredential, error) {
+	if err := r.reauthenticate(userID, proof, client); err != nil {
+		return nil, err
+	}
+
+	challenge, err := response.Challenge()
+	if err != nil {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	challengeUserID, err := r.consumeWebAuthnChallenge(challenge, ceremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challengeUserID != userID {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	credential, err := r.RelyingParty.VerifyRegistration(response, challenge, false)
+	if err != nil {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	var stored models.WebAuthnCredential
+	result := r.DB.Raw(`
+		INSERT INTO webauthn_credentials (user_id, credential_id, name, public_key, sign_count, transports, aaguid)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (credential_id) DO NOTHING
+		RETURNING `+passkeyColumns,
+		userID, webauthn.EncodeBase64URL(credential.ID), passkeyName(name), credential.PublicKey,
+		int64(credential.SignCount), models.StringList(credential.Transports), credential.AAGUID).Scan(&stored)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to store passkey")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrPasskeyExists
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventPasskeyAdded,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Details:   map[string]any{"passkey_id": stored.ID},
+	})
+
+	return &stored, nil
+}
+
+// BeginPasskeyLogin returns request options for signing in. Without an MFA
+// challenge the passkey is the only factor and the browser may offer any
+// discoverable credential; with one, only the challenged user's credentials
+// are allowed.
+func (r *AuthRepository) BeginPasskeyLogin(mfaToken string) (*webauthn.RequestOptions, error) {
+	if mfaToken == "" {
+		challenge, err := r.startWebAuthnCeremony(ceremonyLogin, uuid.Nil)
+		if err != nil {
+			return nil, err
+		}
+
+		options := r.RelyingParty.RequestOptions(challenge, nil, webauthn.UserVerificationRequired)
+		return &options, nil
+	}
+
+	_, userID, err := r.verifyMFAChallenge(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := r.ListPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) == 0 {
+		return nil, models.ErrMFANotEnrolled
+	}
+
+	challenge, err := r.startWebAuthnCeremony(ceremonyMFA, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	options := r.RelyingParty.RequestOptions(challenge, credentialDescriptors(passkeys), webauthn.UserVerificationPreferred)
+	return &options, nil
+}
+
+// FinishPasskeyLogin verifies an assertion and issues tokens. As the only
+// factor the authenticator must have verified the user (PIN or biometric);
+// as a second factor presence is enough, since the password was checked.
+func (r *AuthRepository) FinishPasskeyLogin(mfaToken string, response *webauthn.AssertionResponse, client models.ClientInfo) (*models.AuthenticationResult, error) {
+	var claims *utils.SignedClaims
+	var challengedUserID uuid.UUID
+	ceremony := ceremonyLogin
+	if mfaToken != "" {
+		var err error
+		if claims, challengedUserID, err = r.verifyMFAChallenge(mfaToken); err != nil {
+			return nil, err
+		}
+		ceremony = ceremonyMFA
+
+		if err := r.checkLoginThrottle(claims.Email, client.IPAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	challenge, err := response.Challenge()
+	if err != nil {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	challengeUserID, err := r.consumeWebAuthnChallenge(challenge, ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if challengeUserID != challengedUserID {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	credentialID, err := response.CredentialID()
+	if err != nil {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	var passkey struct {
+		models.WebAuthnCredential
+		UserID uuid.UUID
+	}
+	result := r.DB.Raw("SELECT user_id, "+passkeyColumns+" FROM webauthn_credentials WHERE credential_id = ?",
+		webauthn.EncodeBase64URL(credentialID)).Scan(&passkey)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to load passkey")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrPasskeyInvalid
+	}
+	if mfaToken != "" && passkey.UserID != challengedUserID {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	assertion, err := r.RelyingParty.VerifyAssertion(response, challenge, passkey.PublicKey, mfaToken == "")
+	if err != nil {
+		return nil, models.ErrPasskeyInvalid
+	}
+	if assertion.UserHandle != nil && !bytes.Equal(assertion.UserHandle, passkey.UserID[:]) {
+		return nil, models.ErrPasskeyInvalid
+	}
+
+	if err := r.updatePasskeySignCount(&passkey.WebAuthnCredential, assertion.SignCount); err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	if mfaToken != "" {
+		if user, err = r.findChallengedUser(challengedUserID, claims.Email); err != nil {
+			return nil, err
+		}
+		if err := r.consumeMFAChallenge(claims, challengedUserID); err != nil {
+			return nil, err
+		}
+		r.resetLoginThrottle(claims.Email)
+	} else {
+		if user, err = r.GetUser(passkey.UserID); err != nil {
+			return nil, err
+		}
+		if user.EmailVerifiedAt == nil && utils.RequireEmailVerification() {
+			return nil, models.ErrEmailNotVerified
+		}
+	}
+
+	return r.issueTokens(user, uuid.Nil, client)
+}
+
+func (r *AuthRepository) ListPasskeys(userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	passkeys := []models.WebAuthnCredential{}
+	if err := r.DB.Raw(`
+		SELECT `+passkeyColumns+`
+		FROM webauthn_credentials
+		WHERE user_id = ?
+		ORDER BY created_at`, userID).Scan(&passkeys).Error; err != nil {
+		return nil, models.New("database_error", "failed to list passkeys")
+	}
+	return passkeys, nil
+}
+
+func (r *AuthRepository) RenamePasskey(userID, passkeyID uuid.UUID, name string) (*models.WebAuthnCredential, error) {
+	var passkey models.WebAuthnCredential
+	result := r.DB.Raw(`
+		UPDATE webauthn_credentials SET name = ?
+		WHERE id = ? AND user_id = ?
+		RETURNING `+passkeyColumns,
+		passkeyName(name), passkeyID, userID).Scan(&passkey)
+	if result.Error != nil {
+		return nil, models.New("database_error", "failed to rename passkey")
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrPasskeyNotFound
+	}
+	return &passkey, nil
+}
+
+// DeletePasskey removes a passkey after proof confirms the account holder is
+// present, so a stolen access token cannot take away the user's way in.
+func (r *AuthRepository) DeletePasskey(userID, passkeyID uuid.UUID, proof models.Reauthentication, client models.ClientInfo) error {
+	if err := r.reauthenticate(userID, proof, client); err != nil {
+		return err
+	}
+
+	result := r.DB.Exec("DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", passkeyID, userID)
+	if result.Error != nil {
+		return models.New("database_error", "failed to remove passkey")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrPasskeyNotFound
+	}
+
+	recordSecurityEvent(r.DB, &models.SecurityEvent{
+		UserID:    userID,
+		EventType: models.EventPasskeyRemoved,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Details:   map[string]any{"passkey_id": passkeyID},
+	})
+
+	return nil
+}
+
+// startWebAuthnCeremony stores a fresh challenge. Only its hash is kept, and
+// expired challenges are swept on the way in.
+func (r *AuthRepository) startWebAuthnCeremony(ceremony string, userID uuid.UUID) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", models.New("passkey_challenge_failed", "failed to generate challenge")
+	}
+
+	var owner *uuid.UUID
+	if userID != uuid.Nil {
+		owner = &userID
+	}
+
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM webauthn_challenges WHERE expires_at < NOW()").Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			INSERT INTO webauthn_challenges (challenge_hash, ceremony, user_id, expires_at)
+			VALUES (?, ?, ?, ?)`,
+			utils.HashToken(challenge), ceremony, owner, utils.TokenExpiryTime(config.Current().Tokens.WebAuthnChallenge)).Error
+	})
+	if err != nil {
+		return "", models.New("passkey_challenge_failed", "failed to store challenge")
+	}
+
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge deletes a pending challenge and returns the user
+// it was issued to, or uuid.Nil for a username-less login. Deleting makes
+// every challenge single-use.
+func (r *AuthRepository) consumeWebAuthnChallenge(challenge, ceremony string) (uuid.UUID, error) {
+	var pending struct {
+		UserID *uuid.UUID
+	}
+	result := r.DB.Raw(`
+		DELETE FROM webauthn_challenges
+		WHERE challenge_hash = ? AND ceremony = ? AND expires_at > NOW()
+		RETURNING user_id`,
+		utils.HashToken(challenge), ceremony).Scan(&pending)
+	if result.Error != nil {
+		return uuid.Nil, models.New("database_error", "failed to verify challenge")
+	}
+	if result.RowsAffected == 0 {
+		return uuid.Nil, models.ErrPasskeyInvalid
+	}
+
+	if pending.UserID == nil {
+		return uuid.Nil, nil
+	}
+	return *pending.UserID, nil
+}
+
+// updatePasskeySignCount stores the authenticator's new signature counter.
+// A counter that fails to increase suggests a cloned authenticator, except
+// for passkeys that always report zero.
+func (r *AuthRepository) updatePasskeySignCount(passkey *models.WebAuthnCredential, signCount uint32) error {
+	newCount := int64(signCount)
+	if (newCount != 0 || passkey.SignCount != 0) && newCount <= passkey.SignCount {
+		return models.ErrPasskeyInvalid
+	}
+
+	result := r.DB.Exec(`
+		UPDATE webauthn_credentials SET sign_count = ?, last_used_at = NOW()
+		WHERE id = ? AND sign_count = ?`,
+		newCount, passkey.ID, passkey.SignCount)
+	if result.Error != nil {
+		return models.New("database_error", "failed to update passkey")
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrPasskeyInvalid
+	}
+	return nil
+}
+
+func credentialDescriptors(passkeys []models.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, webauthn.CredentialDescriptor{
+			Type:       webauthn.PublicKeyType,
+			ID:         passkey.CredentialID,
+			Transports: passkey.Transports,
+		})
+	}
+	return descriptors
+}
+
+func passkeyName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Passkey"
+	}
+	if runes := []rune(name); len(runes) > utils.MaxPasskeyNameLength {
+		return string(runes[:utils.MaxPasskeyNameLength])
+	}
+	return name
+}
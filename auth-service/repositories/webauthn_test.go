@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"auth-service/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestUpdatePasskeySignCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  int64
+		new     uint32
+		updated int64
+		want    error
+	}{
+		{"authenticator without a counter", 0, 0, 1, nil},
+		{"counter advanced", 5, 6, 1, nil},
+		{"counter repeated", 5, 5, 0, models.ErrPasskeyInvalid},
+		{"counter went back", 5, 4, 0, models.ErrPasskeyInvalid},
+		{"counter reset to zero", 5, 0, 0, models.ErrPasskeyInvalid},
+		{"concurrent assertion won", 5, 6, 0, models.ErrPasskeyInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			repo := NewAuthRepository(db)
+			passkey := &models.WebAuthnCredential{ID: uuid.New(), SignCount: tt.stored}
+
+			// A clone or replay is rejected before the database is touched;
+			// otherwise the update only applies if the stored count is unchanged.
+			if tt.new > uint32(tt.stored) || tt.new == 0 && tt.stored == 0 {
+				mock.ExpectExec(`UPDATE webauthn_credentials SET sign_count = \$1, last_used_at = NOW\(\)\s+WHERE id = \$2 AND sign_count = \$3`).
+					WithArgs(int64(tt.new), passkey.ID, tt.stored).
+					WillReturnResult(sqlmock.NewResult(0, tt.updated))
+			}
+
+			if err := repo.updatePasskeySignCount(passkey, tt.new); err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,37 @@
 package utils
 
-import "time"
+import (
+	"auth-service/config"
+	"time"
+)
 
 const (
-	AccessTokenLifetime  = 15 * time.Minute
-	RefreshTokenLifetime = 7 * 24 * time.Hour
+	RecoveryCodeCount = 10
+
+	// APIKeyPrefix makes leaked keys easy to recognise, e.g. by secret
+	// scanners. The first APIKeyDisplayLength characters identify a key in
+	// listings.
+	APIKeyPrefix        = "shpk_"
+	APIKeyDisplayLength = 12
+
+	EmailVerificationPurpose = "email_verification"
+	MFAChallengePurpose      = "mfa_challenge"
+	MagicLinkPurpose         = "magic_link"
+
+	// MaxTokenPermissions keeps access tokens small enough for HTTP headers.
+	MaxTokenPermissions = 100
+
+	MaxPasskeyNameLength = 64
+
+	SigningKeyRefreshInterval = time.Minute
+
+	// JWKSMaxAge is how long verifiers may cache the published keys.
+	JWKSMaxAge = 5 * time.Minute
+	// SigningKeyActivationDelay is how long a new key is only published
+	// before it signs anything. A shared HTTP cache and the verifier's own
+	// cache may each hold the old set for JWKSMaxAge, and replicas pick the
+	// key up within one refresh.
+	SigningKeyActivationDelay = 2*JWKSMaxAge + SigningKeyRefreshInterval
 )
 
 func TokenExpiryTime(duration time.Duration) time.Time {
@@ -12,9 +39,30 @@ func TokenExpiryTime(duration time.Duration) time.Time {
 }
 
 func AccessTokenExpiry() time.Time {
-	return TokenExpiryTime(AccessTokenLifetime)
+	return TokenExpiryTime(config.Current().Tokens.Access)
 }
 
 func RefreshTokenExpiry() time.Time {
-	return TokenExpiryTime(RefreshTokenLifetime)
+	return TokenExpiryTime(config.Current().Tokens.Refresh)
+}
+
+// RequireEmailVerification reports whether users must verify their email
+// before they can log in.
+func RequireEmailVerification() bool {
+	return config.Current().RequireEmailVerification
+}
+
+// SigningKeyRotationInterval is how long a signing key stays active before it
+// is replaced.
+func SigningKeyRotationInterval() time.Duration {
+	return config.Current().JWT.KeyRotationInterval
+}
+
+// SigningKeyOverlap is how long a replaced signing key is still published.
+func SigningKeyOverlap() time.Duration {
+	return config.Current().JWT.KeyOverlap
+}
+
+func RateLimits() string {
+	return config.Current().RateLimits
 }
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"auth-service/config"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+func dataEncryptionKey() []byte {
+	key := sha256.Sum256([]byte(config.Current().DataEncryptionKey.Reveal()))
+	return key[:]
+}
+
+// Encrypt seals plaintext with AES-256-GCM for storage at rest. The nonce is
+// prepended to the ciphertext.
+func Encrypt(plaintext []byte) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func Decrypt(ciphertext string) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataEncryptionKey())
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"auth-service/config"
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	useConfig(t, nil)
+
+	for _, plaintext := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("secret"), 1000)} {
+		sealed, err := Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := Decrypt(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Decrypt = %q, want %q", opened, plaintext)
+		}
+	}
+}
+
+func TestEncryptUsesFreshNonce(t *testing.T) {
+	useConfig(t, nil)
+
+	a, _ := Encrypt([]byte("same"))
+	b, _ := Encrypt([]byte("same"))
+	if a == b {
+		t.Error("two encryptions of the same plaintext are identical")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	useConfig(t, nil)
+
+	sealed, err := Encrypt([]byte("totp secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(sealed)
+
+	for i := range raw {
+		tampered := bytes.Clone(raw)
+		tampered[i] ^= 0x01
+		if _, err := Decrypt(base64.StdEncoding.EncodeToString(tampered)); err == nil {
+			t.Fatalf("flipping byte %d was not detected", i)
+		}
+	}
+
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(raw[:8])} {
+		if _, err := Decrypt(bad); err == nil {
+			t.Errorf("Decrypt(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestDecryptWithOtherKeyFails(t *testing.T) {
+	useConfig(t, nil)
+	sealed, err := Encrypt([]byte("totp secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useConfig(t, func(cfg *config.Config) {
+		cfg.DataEncryptionKey = "another-data-encryption-key-0123456789"
+	})
+	if _, err := Decrypt(sealed); err == nil {
+		t.Error("ciphertext opened with a different DATA_ENCRYPTION_KEY")
+	}
+}
@@ -1,42 +1,38 @@
 package utils
 
 import (
+	"auth-service/config"
+	"auth-service/migrations"
+	"auth-service/pkg/migrate"
+	"context"
 	"fmt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
-	"os"
-	"time"
+	"sync/atomic"
 )
 
-func BuildDSN() string {
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	sslmode := os.Getenv("DB_SSLMODE")
+var migrationsApplied atomic.Bool
 
-	if host == "" {
-		host = "localhost"
-	}
-	if port == "" {
-		port = "5432"
-	}
-	if sslmode == "" {
-		sslmode = "disable"
-	}
+// MigrationsApplied reports whether Migrate has completed successfully in this
+// process.
+func MigrationsApplied() bool {
+	return migrationsApplied.Load()
+}
+
+func BuildDSN() string {
+	db := config.Current().Database
 
-	if password != "" {
+	if db.Password != "" {
 		return fmt.Sprintf(
-			"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC connect_timeout=10",
-			host, port, user, password, dbname, sslmode,
+			"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC connect_timeout=10",
+			db.Host, db.Port, db.User, db.Password.Reveal(), db.Name, db.SSLMode,
 		)
 	}
 
 	return fmt.Sprintf(
-		"host=%s port=%s user=%s dbname=%s sslmode=%s TimeZone=UTC connect_timeout=10",
-		host, port, user, dbname, sslmode,
+		"host=%s port=%d user=%s dbname=%s sslmode=%s TimeZone=UTC connect_timeout=10",
+		db.Host, db.Port, db.User, db.Name, db.SSLMode,
 	)
 }
 
@@ -53,234 +49,95 @@ func NewGormDB() (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to get sql.DB: %w", err)
 	}
 
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	pool := config.Current().Database
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
 	return db, nil
 }
 
-func AutoMigrate(db *gorm.DB) error {
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	if err := tx.Exec(`
-        DROP TRIGGER IF EXISTS update_users_updated_at ON users;
-        DROP TRIGGER IF EXISTS token_expiry_trigger ON refresh_tokens;
-        DROP TRIGGER IF EXISTS update_roles_updated_at ON roles;
-        DROP TRIGGER IF EXISTS update_user_roles_updated_at ON user_roles;
-        DROP TRIGGER IF EXISTS user_default_role_trigger ON users;
-    `).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("помилка видалення тригерів: %v", err)
-	}
-
-	if err := tx.Exec(`
-        CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
-        CREATE EXTENSION IF NOT EXISTS "pg_cron";
-    `).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("помилка створення розширень: %v", err)
+// CloseGormDB closes the connection pool. Queries still running fail.
+func CloseGormDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.Close()
+}
 
-	if err := tx.Exec(`
-        CREATE TABLE IF NOT EXISTS roles (
-            id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-            name TEXT UNIQUE NOT NULL,
-            description TEXT,
-            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-        );
-
-        CREATE TABLE IF NOT EXISTS users (
-            id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-            name TEXT NOT NULL,
-            email TEXT UNIQUE NOT NULL,
-            password_hash TEXT NOT NULL,
-            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-        );
+// migrationHooks are Go steps run inside a migration's transaction, after
+// its SQL.
+var migrationHooks = map[int64]func(tx *gorm.DB) error{
+	1: migrateRefreshTokenHashes,
+}
 
-        CREATE TABLE IF NOT EXISTS user_roles (
-            id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-            role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
-            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-            UNIQUE(user_id, role_id)
-        );
-        
-        CREATE TABLE IF NOT EXISTS refresh_tokens (
-            token UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-            expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
-            is_revoked BOOLEAN DEFAULT FALSE
-        );
-    `).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("помилка створення таблиць: %v", err)
+// NewMigrator returns a migrator for the embedded schema migrations.
+func NewMigrator(db *gorm.DB) (*migrate.Migrator, error) {
+	list, err := migrate.Load(migrations.Files)
+	if err != nil {
+		return nil, err
 	}
-
-	if err := tx.Exec(`
-        CREATE INDEX IF NOT EXISTS idx_users_email ON users (email);
-        CREATE INDEX IF NOT EXISTS idx_roles_name ON roles (name);
-        CREATE INDEX IF NOT EXISTS idx_user_roles_user_id ON user_roles (user_id);
-        CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
-        CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
-        CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_revoked ON refresh_tokens (expires_at) 
-            WHERE is_revoked = FALSE;
-    `).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("помилка створення індексів: %v", err)
+	for i := range list {
+		list[i].AfterUp = migrationHooks[list[i].Version]
 	}
+	return migrate.New(db, list), nil
+}
 
-	if err := tx.Exec(`
-        CREATE OR REPLACE FUNCTION update_updated_at_column()
-        RETURNS TRIGGER AS $func$
-        BEGIN
-            NEW.updated_at = CURRENT_TIMESTAMP;
-            RETURN NEW;
-        END;
-        $func$ LANGUAGE plpgsql;
-        
-        CREATE OR REPLACE FUNCTION revoke_expired_tokens()
-        RETURNS BIGINT AS $func$
-        DECLARE
-            tokens_revoked BIGINT;
-        BEGIN
-            WITH updated AS (
-                UPDATE refresh_tokens
-                SET is_revoked = TRUE
-                WHERE expires_at < NOW() 
-                AND is_revoked = FALSE
-                RETURNING 1
-            )
-            SELECT COUNT(*) INTO tokens_revoked FROM updated;
-            RETURN tokens_revoked;
-        END;
-        $func$ LANGUAGE plpgsql;
-        
-        CREATE OR REPLACE FUNCTION check_token_expiry()
-        RETURNS TRIGGER AS $func$
-        BEGIN
-            IF NEW.expires_at < NOW() THEN
-                NEW.is_revoked := TRUE;
-            END IF;
-            RETURN NEW;
-        END;
-        $func$ LANGUAGE plpgsql;
-
-        CREATE OR REPLACE FUNCTION ensure_user_has_default_role()
-        RETURNS TRIGGER AS $func$
-        DECLARE
-            user_role_id UUID;
-            default_role_id UUID;
-        BEGIN
-            SELECT role_id INTO user_role_id FROM user_roles WHERE user_id = NEW.id LIMIT 1;
-            
-            IF user_role_id IS NULL THEN
-                SELECT id INTO default_role_id FROM roles WHERE name = 'USER' LIMIT 1;
-                
-                IF default_role_id IS NOT NULL THEN
-                    INSERT INTO user_roles (user_id, role_id)
-                    VALUES (NEW.id, default_role_id);
-                END IF;
-            END IF;
-            
-            RETURN NEW;
-        END;
-        $func$ LANGUAGE plpgsql;
-
-        CREATE OR REPLACE FUNCTION user_has_role(p_user_id UUID, p_role_name TEXT)
-        RETURNS BOOLEAN AS $func$
-        BEGIN
-            RETURN EXISTS (
-                SELECT 1
-                FROM user_roles ur
-                JOIN roles r ON ur.role_id = r.id
-                WHERE ur.user_id = p_user_id
-                AND r.name = p_role_name
-            );
-        END;
-        $func$ LANGUAGE plpgsql;
-    `).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("помилка створення функцій: %v", err)
+// Migrate applies pending schema migrations. It fails without changing
+// anything when an applied migration was edited since.
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
 	}
 
-	if err := tx.Exec(`
-        CREATE TRIGGER update_users_updated_at
-            BEFORE UPDATE ON users
-            FOR EACH ROW
-            EXECUTE FUNCTION update_updated_at_column();
-            
-        CREATE TRIGGER update_roles_updated_at
-            BEFORE UPDATE ON roles
-            FOR EACH ROW
-            EXECUTE FUNCTION update_updated_at_column();
-        
-        CREATE TRIGGER update_user_roles_updated_at
-            BEFORE UPDATE ON user_roles
-            FOR EACH ROW
-            EXECUTE FUNCTION update_updated_at_column();
-            
-        CREATE TRIGGER token_expiry_trigger
-            BEFORE INSERT OR UPDATE ON refresh_tokens
-            FOR EACH ROW
-            EXECUTE FUNCTION check_token_expiry();
-
-        CREATE TRIGGER user_default_role_trigger
-            AFTER INSERT ON users
-            FOR EACH ROW
-            EXECUTE FUNCTION ensure_user_has_default_role();
-    `).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("помилка створення тригерів: %v", err)
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range applied {
+		log.Printf("applied migration %s", migration)
 	}
 
-	if err := tx.Exec(`
-        INSERT INTO roles (name, description)
-        VALUES 
-            ('ADMIN', 'Administrator with full system access'),
-            ('USER', 'Regular user with limited access')
-        ON CONFLICT (name) DO UPDATE 
-        SET description = EXCLUDED.description,
-            updated_at = CURRENT_TIMESTAMP;
-    `).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("помилка додавання базових ролей: %v", err)
+	migrationsApplied.Store(true)
+	return nil
+}
+
+// migrateRefreshTokenHashes converts the legacy refresh_tokens layout, where
+// the raw token was the primary key, to hashed storage. Tokens that were
+// already handed out keep working because their cookie value hashes to the
+// same token_hash.
+func migrateRefreshTokenHashes(tx *gorm.DB) error {
+	var legacy bool
+	if err := tx.Raw(`
+        SELECT EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_name = 'refresh_tokens' AND column_name = 'token'
+        )`).Scan(&legacy).Error; err != nil {
+		return err
 	}
 
-	if err := tx.Exec(`
-        DO $$
-        BEGIN
-            IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_cron') THEN
-                DELETE FROM cron.job WHERE command IN (
-                    'SELECT revoke_expired_tokens()',
-                    'DELETE FROM refresh_tokens WHERE is_revoked = TRUE'
-                );
+	if legacy {
+		if err := tx.Exec(`CREATE EXTENSION IF NOT EXISTS pgcrypto;`).Error; err != nil {
+			return err
+		}
 
-                PERFORM cron.schedule(
-                    'revoke-expired-tokens',
-                    '*/30 * * * *',
-                    'SELECT revoke_expired_tokens()'
-                );
+		if err := tx.Exec(`
+            UPDATE refresh_tokens
+            SET token_hash = encode(hmac(token::text, ?, 'sha256'), 'hex')
+            WHERE token_hash IS NULL`, config.Current().RefreshTokenSecret.Reveal()).Error; err != nil {
+			return err
+		}
 
-                PERFORM cron.schedule(
-                    'cleanup-revoked-tokens',
-                    '0 3 * * *',
-                    'DELETE FROM refresh_tokens WHERE is_revoked = TRUE'
-                );
-            END IF;
-        END $$;
-    `).Error; err != nil {
-		log.Printf("Попередження: не вдалося налаштувати pg_cron: %v", err)
+		if err := tx.Exec(`
+            ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_pkey;
+            ALTER TABLE refresh_tokens DROP COLUMN token;
+            ALTER TABLE refresh_tokens ADD PRIMARY KEY (id);
+        `).Error; err != nil {
+			return err
+		}
 	}
 
-	return tx.Commit().Error
+	return tx.Exec(`ALTER TABLE refresh_tokens ALTER COLUMN token_hash SET NOT NULL;`).Error
 }
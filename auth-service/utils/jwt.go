@@ -1,44 +1,165 @@
 package utils
 
 import (
+	"auth-service/config"
 	"auth-service/models"
-	"github.com/dgrijalva/jwt-go"
+	"auth-service/pkg/jwtauth"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
-	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+type Claims = jwtauth.Claims
 
-type Claims struct {
-	UserID string `json:"userId"`
-	Email  string `json:"email"`
-	jwt.StandardClaims
+func JWTIssuer() string {
+	return config.Current().JWT.Issuer
 }
 
-func GenerateAccessToken(user *models.User) (string, int64, error) {
-	expirationTime := AccessTokenExpiry()
+func JWTAudience() string {
+	return config.Current().JWT.Audience
+}
+
+// ErrTooManyPermissions is returned instead of a token that would silently
+// miss some of the user's permissions.
+var ErrTooManyPermissions = errors.New("too many permissions for an access token")
+
+func GenerateAccessToken(user *models.User, roles, permissions []string) (string, int64, error) {
+	if len(permissions) > MaxTokenPermissions {
+		return "", 0, ErrTooManyPermissions
+	}
+
+	return signAccessToken(&Claims{
+		UserID:        user.ID.String(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		Roles:         roles,
+		Permissions:   permissions,
+	}, user.ID.String(), JWTAudience(), AccessTokenExpiry())
+}
+
+// GenerateDelegatedAccessToken issues an access token an OAuth client uses on
+// behalf of a user. Its audience is the client, so first-party routes reject
+// it, and it carries only the scopes the user granted, never roles or
+// permissions.
+func GenerateDelegatedAccessToken(user *models.User, clientID string, scopes []string) (string, int64, error) {
 	claims := &Claims{
-		UserID: user.ID.String(),
-		Email:  user.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+		UserID:   user.ID.String(),
+		ClientID: clientID,
+		Scope:    strings.Join(scopes, " "),
+	}
+	if slices.Contains(scopes, "email") {
+		claims.Email = user.Email
+		claims.EmailVerified = user.EmailVerifiedAt != nil
+	}
+	return signAccessToken(claims, user.ID.String(), clientID, AccessTokenExpiry())
+}
+
+// GenerateClientAccessToken issues an access token for an OAuth client acting
+// on its own behalf (client_credentials grant). There is no user, so the
+// subject is the client ID.
+func GenerateClientAccessToken(clientID string, scopes []string) (string, int64, error) {
+	return signAccessToken(&Claims{
+		ClientID: clientID,
+		Scope:    strings.Join(scopes, " "),
+	}, clientID, JWTAudience(), AccessTokenExpiry())
+}
+
+// GenerateServiceAccessToken issues a short-lived access token for a service
+// account authenticated with an API key.
+func GenerateServiceAccessToken(account *models.ServiceAccount, scopes []string) (string, int64, error) {
+	return signAccessToken(&Claims{
+		Scope:   strings.Join(scopes, " "),
+		SubType: jwtauth.SubTypeService,
+	}, account.ID.String(), JWTAudience(), TokenExpiryTime(config.Current().Tokens.Service))
+}
+
+// signAccessToken fills in the registered claims shared by every access token
+// and signs it with the current key.
+func signAccessToken(claims *Claims, subject, audience string, expirationTime time.Time) (string, int64, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Subject:   subject,
+		Issuer:    JWTIssuer(),
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(expirationTime),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := DefaultKeySet.Sign(claims)
 	return tokenString, expirationTime.Unix(), err
 }
 
+type IDTokenClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken issues an OpenID Connect ID token for the client. Profile
+// and email claims are only included when their scope was granted.
+func GenerateIDToken(user *models.User, clientID, nonce string, scopes []string) (string, error) {
+	claims := &IDTokenClaims{
+		Nonce: nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID.String(),
+			Issuer:    JWTIssuer(),
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(AccessTokenExpiry()),
+		},
+	}
+
+	for _, scope := range scopes {
+		switch scope {
+		case "profile":
+			claims.Name = user.Name
+		case "email":
+			verified := user.EmailVerifiedAt != nil
+			claims.Email = user.Email
+			claims.EmailVerified = &verified
+		}
+	}
+
+	return DefaultKeySet.Sign(claims)
+}
+
+func GenerateEmailVerificationToken(user *models.User) (string, error) {
+	return SignClaims(EmailVerificationPurpose, SignedClaims{
+		Subject:   user.ID.String(),
+		Email:     user.Email,
+		ExpiresAt: TokenExpiryTime(config.Current().Tokens.EmailVerification).Unix(),
+	})
+}
+
+// GenerateMFAChallengeToken issues the short-lived token a client exchanges,
+// together with a second factor, for a full session. The caller stores the
+// nonce so that the challenge can be completed only once.
+func GenerateMFAChallengeToken(user *models.User, nonce string) (string, time.Time, error) {
+	expiresAt := TokenExpiryTime(config.Current().Tokens.MFAChallenge)
+	token, err := SignClaims(MFAChallengePurpose, SignedClaims{
+		Subject:   user.ID.String(),
+		Email:     user.Email,
+		Nonce:     nonce,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	return token, expiresAt, err
+}
+
 func GenerateRefreshToken(userID uuid.UUID) (*models.RefreshToken, error) {
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return nil, models.New("token_generate_failed", "failed to generate refresh token")
+	}
+
 	expiresAt := RefreshTokenExpiry()
 	return &models.RefreshToken{
-		Token:     generateTokenString(),
+		Token:     token,
+		TokenHash: HashToken(token),
 		UserID:    userID,
 		ExpiresAt: expiresAt,
 	}, nil
 }
-
-func generateTokenString() uuid.UUID {
-	return uuid.New()
-}
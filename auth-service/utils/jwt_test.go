@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"auth-service/models"
+	"auth-service/pkg/jwtauth"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useKeySet loads DefaultKeySet with a fresh active key for the duration of
+// the test.
+func useKeySet(t *testing.T) {
+	t.Helper()
+	previous := DefaultKeySet
+	DefaultKeySet = &KeySet{}
+	if err := DefaultKeySet.Load([]models.SigningKey{newTestKey(t, "ES256", time.Now().Add(-time.Minute))}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DefaultKeySet = previous })
+}
+
+// parseAccessToken verifies token the way the middleware in front of the
+// other services does.
+func parseAccessToken(t *testing.T, token string) *Claims {
+	t.Helper()
+	claims, err := jwtauth.Parse(jwt.NewParser(jwt.WithValidMethods(jwtauth.SigningMethods)), token, jwtauth.Config{
+		Keyfunc:  DefaultKeySet.Keyfunc,
+		Issuer:   JWTIssuer(),
+		Audience: JWTAudience(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestGenerateAccessTokenCarriesRoles(t *testing.T) {
+	useConfig(t, nil)
+	useKeySet(t)
+	user := &models.User{ID: uuid.New(), Email: "user@example.com"}
+
+	token, expiresAt, err := GenerateAccessToken(user, []string{models.RoleAdmin, models.RoleUser}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := parseAccessToken(t, token)
+
+	if claims.Subject != user.ID.String() || claims.UserID != user.ID.String() {
+		t.Errorf("sub = %q, userId = %q, want %s", claims.Subject, claims.UserID, user.ID)
+	}
+	if !reflect.DeepEqual(claims.Roles, []string{models.RoleAdmin, models.RoleUser}) {
+		t.Errorf("roles = %v", claims.Roles)
+	}
+	if !claims.HasRole(models.RoleAdmin) || claims.HasRole("admin") {
+		t.Error("HasRole does not match role names exactly")
+	}
+	if claims.ExpiresAt.Unix() != expiresAt {
+		t.Errorf("exp = %d, returned expiry %d", claims.ExpiresAt.Unix(), expiresAt)
+	}
+}
+
+func TestGenerateAccessTokenPermissionLimit(t *testing.T) {
+	useConfig(t, nil)
+	useKeySet(t)
+	user := &models.User{ID: uuid.New()}
+
+	permissions := make([]string, MaxTokenPermissions+1)
+	for i := range permissions {
+		permissions[i] = fmt.Sprintf("resource%d:read", i)
+	}
+
+	token, _, err := GenerateAccessToken(user, nil, permissions[:MaxTokenPermissions])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parseAccessToken(t, token).Permissions; len(got) != MaxTokenPermissions {
+		t.Errorf("token carries %d permissions, want %d", len(got), MaxTokenPermissions)
+	}
+
+	if _, _, err := GenerateAccessToken(user, nil, permissions); err != ErrTooManyPermissions {
+		t.Fatalf("err = %v, want %v instead of a truncated token", err, ErrTooManyPermissions)
+	}
+}
+
+func TestGenerateDelegatedAccessToken(t *testing.T) {
+	useConfig(t, nil)
+	useKeySet(t)
+	now := time.Now()
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", EmailVerifiedAt: &now}
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtauth.SigningMethods))
+
+	token, _, err := GenerateDelegatedAccessToken(user, "partner", []string{"openid", "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First-party routes check the service's own audience.
+	if _, err := jwtauth.Parse(parser, token, jwtauth.Config{Keyfunc: DefaultKeySet.Keyfunc, Issuer: JWTIssuer(), Audience: JWTAudience()}); err == nil {
+		t.Fatal("delegated token accepted by a first-party route")
+	}
+
+	claims, err := jwtauth.Parse(parser, token, jwtauth.Config{Keyfunc: DefaultKeySet.Keyfunc, Issuer: JWTIssuer(), Audience: JWTAudience(), AcceptClientTokens: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(claims.Audience, jwt.ClaimStrings{"partner"}) || claims.ClientID != "partner" || claims.UserID != user.ID.String() {
+		t.Errorf("aud = %v, client_id = %q, userId = %q", claims.Audience, claims.ClientID, claims.UserID)
+	}
+	if claims.Scope != "openid email" || claims.Roles != nil || claims.Permissions != nil {
+		t.Errorf("scope = %q, roles = %v, permissions = %v; want only the granted scopes", claims.Scope, claims.Roles, claims.Permissions)
+	}
+	if claims.Email != user.Email || !claims.EmailVerified {
+		t.Errorf("email = %q, verified = %v", claims.Email, claims.EmailVerified)
+	}
+
+	token, _, err = GenerateDelegatedAccessToken(user, "partner", []string{"openid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = jwtauth.Parse(parser, token, jwtauth.Config{Keyfunc: DefaultKeySet.Keyfunc, AcceptClientTokens: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "" || claims.EmailVerified {
+		t.Errorf("email claims %q, %v given without the email scope", claims.Email, claims.EmailVerified)
+	}
+}
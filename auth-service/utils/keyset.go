@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"auth-service/pkg/jwtauth"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSigningKey   = errors.New("no signing key loaded")
+	ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+)
+
+// KeySource persists signing keys so that every replica signs with, and
+// publishes, the same keyset.
+type KeySource interface {
+	ListSigningKeys() ([]models.SigningKey, error)
+	RotateSigningKeyIfDue(algorithm string, interval, overlap time.Duration) (bool, error)
+}
+
+type signingKey struct {
+	id          string
+	method      jwt.SigningMethod
+	private     crypto.Signer
+	activatesAt time.Time
+}
+
+// KeySet holds every published signing key, newest first. The newest key
+// whose activation time has passed signs new tokens; newer keys are only
+// published until then, and older keys stay around for verification until
+// their overlap window ends.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*signingKey
+}
+
+var DefaultKeySet = &KeySet{}
+
+func SigningAlgorithm() string {
+	return config.Current().JWT.SigningAlgorithm
+}
+
+func GenerateSigningKey(algorithm string) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := Encrypt(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		ID:         uuid.NewString(),
+		Algorithm:  algorithm,
+		PrivateKey: encrypted,
+	}, nil
+}
+
+func (ks *KeySet) Load(keys []models.SigningKey) error {
+	loaded := make([]*signingKey, 0, len(keys))
+	for _, key := range keys {
+		parsed, err := parseSigningKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key %s: %w", key.ID, err)
+		}
+		loaded = append(loaded, parsed)
+	}
+
+	ks.mu.Lock()
+	ks.keys = loaded
+	ks.mu.Unlock()
+	return nil
+}
+
+// Loaded reports whether a key is ready to sign.
+func (ks *KeySet) Loaded() bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.active() != nil
+}
+
+// active returns the signing key; ks.mu must be held.
+func (ks *KeySet) active() *signingKey {
+	now := time.Now()
+	for _, key := range ks.keys {
+		if !key.activatesAt.After(now) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	active := ks.active()
+	if active == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.id
+	return token.SignedString(active.private)
+}
+
+// Keyfunc resolves the verification key for a token by its kid header. It is
+// meant to be passed to jwt.ParseWithClaims.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for _, key := range ks.keys {
+		if key.id != kid {
+			continue
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, ErrUnsupportedAlg
+		}
+		return key.private.Public(), nil
+	}
+	return nil, jwtauth.ErrUnknownKeyID
+}
+
+func (ks *KeySet) JWKS() jwtauth.JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := jwtauth.JWKS{Keys: make([]jwtauth.JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk := jwtauth.JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+
+		switch pub := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// Refresh rotates the signing key if it is due and reloads the published
+// keys from the source.
+func (ks *KeySet) Refresh(source KeySource, algorithm string) error {
+	if _, err := source.RotateSigningKeyIfDue(algorithm, SigningKeyRotationInterval(), SigningKeyOverlap()); err != nil {
+		return err
+	}
+
+	keys, err := source.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	return ks.Load(keys)
+}
+
+// RunRotation periodically refreshes the keyset until ctx is cancelled.
+// Replicas that did not perform a rotation pick up the new key on their next
+// tick, well within the overlap window.
+func (ks *KeySet) RunRotation(ctx context.Context, source KeySource, algorithm string) {
+	ticker := time.NewTicker(SigningKeyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ks.Refresh(source, algorithm); err != nil {
+				log.Printf("failed to refresh signing keys: %v", err)
+			}
+		}
+	}
+}
+
+func parseSigningKey(key models.SigningKey) (*signingKey, error) {
+	decrypted, err := Decrypt(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(decrypted)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedAlg
+	}
+
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil {
+		return nil, ErrUnsupportedAlg
+	}
+
+	return &signingKey{id: key.ID, method: method, private: private, activatesAt: key.ActivatesAt}, nil
+}
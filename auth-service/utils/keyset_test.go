@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T, algorithm string, activatesAt time.Time) models.SigningKey {
+	t.Helper()
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.ActivatesAt = activatesAt
+	return *key
+}
+
+func signedKid(t *testing.T, ks *KeySet) string {
+	t.Helper()
+	signed, err := ks.Sign(jwt.RegisteredClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(signed, ks.Keyfunc)
+	if err != nil {
+		t.Fatalf("token does not verify against its own keyset: %v", err)
+	}
+	return token.Header["kid"].(string)
+}
+
+func TestKeySetSignsAndVerifiesEveryAlgorithm(t *testing.T) {
+	useConfig(t, nil)
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			key := newTestKey(t, alg, time.Now().Add(-time.Minute))
+			ks := &KeySet{}
+			if err := ks.Load([]models.SigningKey{key}); err != nil {
+				t.Fatal(err)
+			}
+			if kid := signedKid(t, ks); kid != key.ID {
+				t.Errorf("signed with %s, want %s", kid, key.ID)
+			}
+			if jwks := ks.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Alg != alg {
+				t.Errorf("JWKS = %+v", jwks)
+			}
+		})
+	}
+}
+
+func TestKeySetPublishesNewKeyBeforeSigning(t *testing.T) {
+	useConfig(t, nil)
+
+	current := newTestKey(t, "ES256", time.Now().Add(-time.Hour))
+	pending := newTestKey(t, "ES256", time.Now().Add(SigningKeyActivationDelay))
+
+	ks := &KeySet{}
+	if err := ks.Load([]models.SigningKey{pending, current}); err != nil {
+		t.Fatal(err)
+	}
+
+	if kid := signedKid(t, ks); kid != current.ID {
+		t.Errorf("signed with %s before its activation, want %s", kid, current.ID)
+	}
+	published := map[string]bool{}
+	for _, jwk := range ks.JWKS().Keys {
+		published[jwk.Kid] = true
+	}
+	if !published[pending.ID] || !published[current.ID] {
+		t.Errorf("JWKS publishes %v, want both keys", published)
+	}
+
+	pending.ActivatesAt = time.Now().Add(-time.Second)
+	if err := ks.Load([]models.SigningKey{pending, current}); err != nil {
+		t.Fatal(err)
+	}
+	if kid := signedKid(t, ks); kid != pending.ID {
+		t.Errorf("signed with %s after activation, want %s", kid, pending.ID)
+	}
+}
+
+func TestKeySetWithoutActiveKey(t *testing.T) {
+	useConfig(t, nil)
+
+	ks := &KeySet{}
+	if ks.Loaded() {
+		t.Error("empty keyset reports loaded")
+	}
+	if err := ks.Load([]models.SigningKey{newTestKey(t, "ES256", time.Now().Add(time.Hour))}); err != nil {
+		t.Fatal(err)
+	}
+	if ks.Loaded() {
+		t.Error("keyset with only a pending key reports loaded")
+	}
+	if _, err := ks.Sign(jwt.RegisteredClaims{}); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Sign err = %v, want %v", err, ErrNoSigningKey)
+	}
+}
+
+func TestKeySetRejectsUnknownKidAndAlgorithmSwap(t *testing.T) {
+	useConfig(t, nil)
+
+	ks := &KeySet{}
+	if err := ks.Load([]models.SigningKey{newTestKey(t, "ES256", time.Time{})}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := ks.Sign(jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := &KeySet{}
+	if err := other.Load([]models.SigningKey{newTestKey(t, "ES256", time.Time{})}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(signed, other.Keyfunc); err == nil {
+		t.Error("token verified against a keyset without its kid")
+	}
+
+	token, _ := jwt.Parse(signed, ks.Keyfunc)
+	token.Method = jwt.SigningMethodRS256
+	if _, err := ks.Keyfunc(token); !errors.Is(err, ErrUnsupportedAlg) {
+		t.Errorf("Keyfunc with swapped alg err = %v, want %v", err, ErrUnsupportedAlg)
+	}
+}
+
+type fakeKeySource struct {
+	keys              []models.SigningKey
+	rotated           int
+	interval, overlap time.Duration
+}
+
+func (f *fakeKeySource) ListSigningKeys() ([]models.SigningKey, error) {
+	return f.keys, nil
+}
+
+func (f *fakeKeySource) RotateSigningKeyIfDue(_ string, interval, overlap time.Duration) (bool, error) {
+	f.rotated++
+	f.interval, f.overlap = interval, overlap
+	return false, nil
+}
+
+func TestKeySetRefresh(t *testing.T) {
+	useConfig(t, func(cfg *config.Config) {
+		cfg.JWT.KeyRotationInterval = 7 * 24 * time.Hour
+		cfg.JWT.KeyOverlap = 48 * time.Hour
+	})
+
+	source := &fakeKeySource{keys: []models.SigningKey{newTestKey(t, "EdDSA", time.Time{})}}
+	ks := &KeySet{}
+	if err := ks.Refresh(source, "EdDSA"); err != nil {
+		t.Fatal(err)
+	}
+	if source.rotated != 1 || !ks.Loaded() {
+		t.Errorf("rotated %d times, loaded %v", source.rotated, ks.Loaded())
+	}
+	if source.interval != 7*24*time.Hour || source.overlap != 48*time.Hour {
+		t.Errorf("rotation schedule = every %s with %s overlap, want the configured one", source.interval, source.overlap)
+	}
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"auth-service/config"
+	"time"
+)
+
+// LockoutPolicy controls how failed logins lock an account or a source IP.
+// Once Threshold failures are reached within Window, each further failure
+// doubles the lock, starting at BaseLock and capped at MaxLock.
+type LockoutPolicy struct {
+	Threshold   int
+	IPThreshold int
+	Window      time.Duration
+	BaseLock    time.Duration
+	MaxLock     time.Duration
+}
+
+func LockoutPolicyFromConfig() LockoutPolicy {
+	return LockoutPolicy(config.Current().Lockout)
+}
+
+// LockDuration returns how long to lock after the given number of failures,
+// or zero when the threshold has not been reached.
+func (p LockoutPolicy) LockDuration(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+
+	lock := p.BaseLock
+	for i := threshold; i < failures && lock < p.MaxLock; i++ {
+		lock *= 2
+	}
+	if lock > p.MaxLock {
+		lock = p.MaxLock
+	}
+	return lock
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockDuration(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 5, BaseLock: time.Minute, MaxLock: 10 * time.Minute}
+
+	tests := []struct {
+		failures  int
+		threshold int
+		want      time.Duration
+	}{
+		{0, 5, 0},
+		{4, 5, 0},
+		{5, 5, time.Minute},
+		{6, 5, 2 * time.Minute},
+		{7, 5, 4 * time.Minute},
+		{8, 5, 8 * time.Minute},
+		{9, 5, 10 * time.Minute},
+		{1000, 5, 10 * time.Minute},
+		{100, 0, 0}, // a zero threshold disables the lock
+	}
+
+	for _, tt := range tests {
+		if got := policy.LockDuration(tt.failures, tt.threshold); got != tt.want {
+			t.Errorf("LockDuration(%d, %d) = %v, want %v", tt.failures, tt.threshold, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+type MailSender interface {
+	Send(email models.Email) error
+}
+
+// LogMailSender writes messages to the log instead of delivering them. It is
+// meant for local development only.
+type LogMailSender struct{}
+
+func (LogMailSender) Send(email models.Email) error {
+	log.Printf("mail to %s: %s\n%s", email.To, email.Subject, email.Body)
+	return nil
+}
+
+type SMTPMailSender struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTPMailSender) Send(email models.Email) error {
+	host := strings.Split(s.Addr, ":")[0]
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		s.From, email.To, email.Subject, email.Body,
+	)
+	return smtp.SendMail(s.Addr, auth, s.From, []string{email.To}, []byte(msg))
+}
+
+func NewMailSender() MailSender {
+	mail := config.Current().Mail
+	if mail.Addr == "" {
+		return LogMailSender{}
+	}
+
+	return &SMTPMailSender{
+		Addr:     mail.Addr,
+		From:     mail.From,
+		Username: mail.Username,
+		Password: mail.Password.Reveal(),
+	}
+}
+
+// FrontendURL builds a link into the storefront, used in emails.
+func FrontendURL(path string) string {
+	return strings.TrimRight(config.Current().FrontendURL, "/") + path
+}
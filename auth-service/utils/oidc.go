@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"auth-service/config"
+	"auth-service/pkg/oidc"
+	"strings"
+)
+
+// OIDCProviders builds the configured identity providers, keyed by name.
+func OIDCProviders() map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider)
+	for name, cfg := range config.Current().OIDC {
+		provider := &oidc.Provider{
+			Name:         name,
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret.Reveal(),
+			RedirectURL:  PublicURL("/login/oauth/" + name + "/callback"),
+			AuthURL:      cfg.AuthURL,
+			TokenURL:     cfg.TokenURL,
+			JWKSURL:      cfg.JWKSURL,
+			UserInfoURL:  cfg.UserInfoURL,
+			Scopes:       cfg.Scopes,
+		}
+
+		if len(provider.Scopes) == 0 && provider.IsOIDC() {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = provider
+	}
+	return providers
+}
+
+// PublicURL builds an absolute URL to this service, used for redirect URIs
+// registered with identity providers.
+func PublicURL(path string) string {
+	return strings.TrimRight(config.Current().PublicURL, "/") + path
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"auth-service/models"
+	"unicode/utf8"
+)
+
+const (
+	MinPasswordLength = 8
+	// bcrypt ignores everything past the first 72 bytes.
+	MaxPasswordBytes = 72
+)
+
+// ValidatePassword applies the password policy shared by every flow that sets
+// a password.
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength || len(password) > MaxPasswordBytes {
+		return models.ErrWeakPassword
+	}
+	return nil
+}
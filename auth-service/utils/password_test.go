@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"auth-service/models"
+	"strings"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"", false},
+		{"1234567", false},
+		{"12345678", true},
+		{"äöüßäöüß", true}, // eight runes, sixteen bytes
+		{"äöüßäöü", false},
+		{strings.Repeat("a", MaxPasswordBytes), true},
+		{strings.Repeat("a", MaxPasswordBytes+1), false},
+		{strings.Repeat("ä", 37), false}, // 74 bytes, truncated by bcrypt
+	}
+
+	for _, tt := range tests {
+		err := ValidatePassword(tt.password)
+		if tt.valid && err != nil {
+			t.Errorf("ValidatePassword(%q) = %v, want nil", tt.password, err)
+		}
+		if !tt.valid && err != models.ErrWeakPassword {
+			t.Errorf("ValidatePassword(%q) = %v, want %v", tt.password, err, models.ErrWeakPassword)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"auth-service/config"
+	"auth-service/models"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedClaimsRoundTrip(t *testing.T) {
+	useConfig(t, nil)
+
+	want := SignedClaims{
+		Subject:   uuid.NewString(),
+		Email:     "user@example.com",
+		Nonce:     "nonce",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}
+	token, err := SignClaims(EmailVerificationPurpose, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := VerifyClaims(EmailVerificationPurpose, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("claims = %+v, want %+v", *got, want)
+	}
+}
+
+func TestVerifyClaimsRejects(t *testing.T) {
+	useConfig(t, nil)
+
+	claims := SignedClaims{Subject: "user", Email: "user@example.com", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	token, err := SignClaims(EmailVerificationPurpose, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, signature, _ := strings.Cut(token, ".")
+
+	expired, _ := SignClaims(EmailVerificationPurpose, SignedClaims{Subject: "user", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","email":"user@example.com","exp":9999999999}`))
+	garbage := "!!!"
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+	}{
+		{"other purpose", MagicLinkPurpose, token},
+		{"missing signature", EmailVerificationPurpose, encoded},
+		{"empty", EmailVerificationPurpose, ""},
+		{"forged payload", EmailVerificationPurpose, forged + "." + signature},
+		{"truncated signature", EmailVerificationPurpose, encoded + "." + signature[:len(signature)-1]},
+		{"expired", EmailVerificationPurpose, expired},
+		{"signed garbage", EmailVerificationPurpose, garbage + "." + signPayload(EmailVerificationPurpose, garbage)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifyClaims(tt.purpose, tt.token); err != ErrInvalidSignedToken {
+				t.Errorf("err = %v, want %v", err, ErrInvalidSignedToken)
+			}
+		})
+	}
+
+	useConfig(t, func(cfg *config.Config) {
+		cfg.RefreshTokenSecret = "another-refresh-token-secret-0123456789"
+	})
+	if _, err := VerifyClaims(EmailVerificationPurpose, token); err != ErrInvalidSignedToken {
+		t.Errorf("token verified after the secret changed: err = %v", err)
+	}
+}
+
+func TestGenerateEmailVerificationToken(t *testing.T) {
+	cfg := useConfig(t, nil)
+	user := &models.User{ID: uuid.New(), Email: "user@example.com"}
+
+	token, err := GenerateEmailVerificationToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyClaims(EmailVerificationPurpose, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != user.ID.String() || claims.Email != user.Email {
+		t.Errorf("claims = %+v, want subject %s and email %s", claims, user.ID, user.Email)
+	}
+	wantExpiry := time.Now().Add(cfg.Tokens.EmailVerification).Unix()
+	if claims.ExpiresAt < wantExpiry-5 || claims.ExpiresAt > wantExpiry+5 {
+		t.Errorf("expires at %d, want about %d", claims.ExpiresAt, wantExpiry)
+	}
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"auth-service/config"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const opaqueTokenBytes = 32
+
+// GenerateOpaqueToken returns a random 256-bit token, URL-safe encoded.
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the keyed hash under which an opaque token is stored.
+// Only the client ever sees the raw token.
+func HashToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(config.Current().RefreshTokenSecret.Reveal()))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
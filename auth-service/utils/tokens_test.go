@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"auth-service/config"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestGenerateOpaqueToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := GenerateOpaqueToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			t.Fatalf("token %q is not raw URL base64: %v", token, err)
+		}
+		if len(raw) != opaqueTokenBytes {
+			t.Fatalf("token has %d bytes, want %d", len(raw), opaqueTokenBytes)
+		}
+		if seen[token] {
+			t.Fatalf("token %q generated twice", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	useConfig(t, nil)
+
+	hash := HashToken("token")
+	if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		t.Fatalf("hash %q is not 64 lowercase hex characters", hash)
+	}
+	if HashToken("token") != hash {
+		t.Error("hash is not deterministic")
+	}
+	if HashToken("token2") == hash {
+		t.Error("different tokens hash the same")
+	}
+
+	useConfig(t, func(cfg *config.Config) {
+		cfg.RefreshTokenSecret = "another-refresh-token-secret-0123456789"
+	})
+	if HashToken("token") == hash {
+		t.Error("hash does not depend on REFRESH_TOKEN_SECRET")
+	}
+}
+
+func TestGenerateRefreshTokenStoresOnlyHash(t *testing.T) {
+	useConfig(t, nil)
+
+	token, err := GenerateRefreshToken([16]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.TokenHash != HashToken(token.Token) {
+		t.Error("TokenHash is not the hash of Token")
+	}
+	if strings.Contains(token.TokenHash, token.Token) {
+		t.Error("TokenHash contains the raw token")
+	}
+}
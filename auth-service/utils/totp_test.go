@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"encoding/base32"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key from RFC 6238 appendix B.
+var rfc6238Secret = base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	// The RFC lists eight-digit codes; six-digit codes are their last six
+	// digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("code %s rejected at %d", tt.code, tt.unix)
+			continue
+		}
+		if step != tt.unix/totpPeriod {
+			t.Errorf("code %s matched step %d, want %d", tt.code, step, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestTOTPWindow(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+	current := at.Unix() / totpPeriod
+	key, _ := base32NoPadding.DecodeString(rfc6238Secret)
+
+	for offset := int64(-2); offset <= 2; offset++ {
+		step, ok := ValidateTOTP(rfc6238Secret, totpCode(key, current+offset), at)
+		wantOK := offset >= -totpSkew && offset <= totpSkew
+		if ok != wantOK {
+			t.Errorf("code %+d steps away accepted = %v, want %v", offset, ok, wantOK)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code %+d steps away matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestValidateTOTPRejectsMalformedInput(t *testing.T) {
+	at := time.Unix(59, 0)
+
+	if _, ok := ValidateTOTP(strings.ToLower(rfc6238Secret), "287082", at); !ok {
+		t.Error("lowercase secret rejected")
+	}
+	for _, code := range []string{"", "28708", "2870820", "28708a", " 287082"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, at); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", at); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(key) != totpSecretBytes {
+		t.Fatalf("secret %q decodes to %d bytes, err %v", secret, len(key), err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("%d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q is not formatted as xxxx-xxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+
+		typed := strings.ToUpper(code[:4]) + " " + code[5:]
+		if NormalizeRecoveryCode(typed) != NormalizeRecoveryCode(code) {
+			t.Errorf("%q and %q normalize differently", typed, code)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"auth-service/config"
+	"testing"
+)
+
+const (
+	testSecret        = "test-refresh-token-secret-0123456789"
+	testEncryptionKey = "test-data-encryption-key-0123456789"
+)
+
+// useConfig installs the default configuration with test secrets, after
+// applying change, for the duration of the test.
+func useConfig(t *testing.T, change func(cfg *config.Config)) *config.Config {
+	t.Helper()
+	cfg := config.Default()
+	cfg.RefreshTokenSecret = testSecret
+	cfg.DataEncryptionKey = testEncryptionKey
+	if change != nil {
+		change(cfg)
+	}
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(nil) })
+	return cfg
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"auth-service/config"
+	"auth-service/pkg/webauthn"
+	"net/url"
+	"strings"
+)
+
+// WebAuthnRelyingParty describes this service to authenticators. The RP ID
+// defaults to the storefront host, so passkeys work across its subdomains
+// only if WEBAUTHN_RP_ID is set to the registrable domain.
+func WebAuthnRelyingParty() *webauthn.RelyingParty {
+	cfg := config.Current()
+
+	var origins []string
+	for _, origin := range cfg.WebAuthn.Origins {
+		origins = append(origins, strings.TrimRight(origin, "/"))
+	}
+	if len(origins) == 0 {
+		origins = []string{FrontendURL("")}
+	}
+
+	id := cfg.WebAuthn.RPID
+	if id == "" {
+		if u, err := url.Parse(origins[0]); err == nil {
+			id = u.Hostname()
+		}
+	}
+
+	return &webauthn.RelyingParty{
+		ID:      id,
+		Name:    cfg.WebAuthn.RPName,
+		Origins: origins,
+		Timeout: cfg.Tokens.WebAuthnChallenge,
+	}
+}